@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Paspartout/gowiki/server"
+)
+
+const defaultConfigFile = "gowiki.toml"
+
+// Further wikis are configured in sections of the config file named
+//
+//	[wikis.team1]
+//	prefix = "/team1"
+//	path = "data-team1"
+//
+// with the settings of a wiki and a host, a prefix or both to select them
+const wikisSection = "wikis."
+
+var wikiSectionName = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// wikiConfig is a [wikis.NAME] section of the config file
+type wikiConfig struct {
+	name   string
+	file   string
+	values [][2]string
+}
+
+// Takes the settings of [wikis.NAME] sections out of values, keeping their
+// order
+func splitWikis(fileName string, values [][2]string) ([][2]string, []*wikiConfig, error) {
+	var rest [][2]string
+	var wikis []*wikiConfig
+	byName := make(map[string]*wikiConfig)
+	for _, kv := range values {
+		if !strings.HasPrefix(kv[0], wikisSection) {
+			rest = append(rest, kv)
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(kv[0], wikisSection), "-", 2)
+		if len(parts) != 2 || !wikiSectionName.MatchString(parts[0]) {
+			return nil, nil, fmt.Errorf("%s: setting %s is invalid, wiki names are letters, digits and _", fileName, kv[0])
+		}
+		wiki := byName[parts[0]]
+		if wiki == nil {
+			wiki = &wikiConfig{name: parts[0], file: fileName}
+			byName[wiki.name] = wiki
+			wikis = append(wikis, wiki)
+		}
+		wiki.values = append(wiki.values, [2]string{parts[1], kv[1]})
+	}
+	return rest, wikis, nil
+}
+
+// Makes the settings of the wikis in sections, each starting from the
+// defaults
+func wikiConfigs(sections []*wikiConfig) ([]server.Config, error) {
+	var wikis []server.Config
+	for _, section := range sections {
+		var c server.Config
+		fs := flag.NewFlagSet(section.name, flag.ContinueOnError)
+		c.RegisterFlags(fs)
+		fs.StringVar(&c.Host, "host", "", "Host name the wiki is served for")
+		fs.StringVar(&c.Prefix, "prefix", "", "Path the wiki is served below")
+		if err := applyConfig(fs, section.file, section.values); err != nil {
+			return nil, fmt.Errorf("wiki %s: %s", section.name, err)
+		}
+		wikis = append(wikis, c)
+	}
+	return wikis, nil
+}
+
+// Reads a config file in a flat subset of TOML. Every key is the name of a
+// command line flag; keys in a [section] get the section as prefix, so
+//
+//	[tls]
+//	cert = "cert.pem"
+//
+// sets -tls-cert. Strings are "basic" or 'literal', arrays of them are joined
+// with commas. The returned values are in file order.
+func readConfig(fileName string) ([][2]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var values [][2]string
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			continue
+		}
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected key = value", fileName, line)
+		}
+		key := strings.TrimSpace(parts[0])
+		if section != "" {
+			key = section + "-" + key
+		}
+		value, err := configValue(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", fileName, line, err)
+		}
+		values = append(values, [2]string{key, value})
+	}
+	return values, scanner.Err()
+}
+
+// Removes a # comment that is not inside a string
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Converts a TOML value to the string form the flag package parses: basic
+// "strings" with escapes, literal 'strings', bare numbers and booleans and
+// arrays of them, whose items are joined with commas
+func configValue(raw string) (string, error) {
+	value, rest, err := parseConfigValue(raw, false)
+	if err != nil {
+		return "", err
+	}
+	if rest = strings.TrimSpace(rest); rest != "" {
+		return "", fmt.Errorf("unexpected %s after the value", rest)
+	}
+	return value, nil
+}
+
+// Parses the value at the start of s and returns what follows it. Bare
+// values in arrays end at the next comma or bracket.
+func parseConfigValue(s string, inArray bool) (value, rest string, err error) {
+	s = strings.TrimLeft(s, " \t")
+	if s == "" {
+		return "", "", fmt.Errorf("missing value")
+	}
+	switch s[0] {
+	case '"':
+		if strings.HasPrefix(s, `"""`) {
+			return "", "", fmt.Errorf("multi-line strings are not supported")
+		}
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("invalid string %s", s[:i+1])
+				}
+				return value, s[i+1:], nil
+			}
+		}
+		return "", "", fmt.Errorf("unterminated string %s", s)
+	case '\'':
+		if strings.HasPrefix(s, "'''") {
+			return "", "", fmt.Errorf("multi-line strings are not supported")
+		}
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case '[':
+		if inArray {
+			return "", "", fmt.Errorf("arrays of arrays are not supported")
+		}
+		var items []string
+		for rest := s[1:]; ; {
+			rest = strings.TrimLeft(rest, " \t")
+			if strings.HasPrefix(rest, "]") {
+				return strings.Join(items, ","), rest[1:], nil
+			}
+			item, after, err := parseConfigValue(rest, true)
+			if err != nil {
+				return "", "", err
+			}
+			// the flags split lists on commas
+			if strings.Contains(item, ",") {
+				return "", "", fmt.Errorf("array item %q contains a comma", item)
+			}
+			items = append(items, item)
+			rest = strings.TrimLeft(after, " \t")
+			switch {
+			case strings.HasPrefix(rest, ","):
+				rest = rest[1:]
+			case !strings.HasPrefix(rest, "]"):
+				return "", "", fmt.Errorf("unterminated array %s", s)
+			}
+		}
+	default:
+		if !inArray {
+			return strings.TrimSpace(s), "", nil // numbers and booleans
+		}
+		end := strings.IndexAny(s, ",] \t")
+		if end < 0 {
+			end = len(s)
+		} else if end == 0 {
+			return "", "", fmt.Errorf("missing value")
+		}
+		return s[:end], s[end:], nil
+	}
+}
+
+// Applies the config file to all flags not given on the command line and
+// returns the wikis configured in its [wikis.NAME] sections. Without -config,
+// gowiki.toml is read if it exists.
+func loadConfig(fileName string) ([]server.Config, error) {
+	if fileName == "" {
+		if _, err := os.Stat(defaultConfigFile); err != nil {
+			return nil, nil
+		}
+		fileName = defaultConfigFile
+	}
+	values, err := readConfig(fileName)
+	if err != nil {
+		return nil, err
+	}
+	values, sections, err := splitWikis(fileName, values)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyConfig(flag.CommandLine, fileName, values); err != nil {
+		return nil, err
+	}
+	return wikiConfigs(sections)
+}
+
+// Sets the flags of fs to the values read from fileName, except those given
+// on the command line
+func applyConfig(fs *flag.FlagSet, fileName string, values [][2]string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, kv := range values {
+		if fs.Lookup(kv[0]) == nil || kv[0] == "config" {
+			return fmt.Errorf("%s: unknown setting %s", fileName, kv[0])
+		}
+		if set[kv[0]] {
+			continue // flags override the config file
+		}
+		if err := fs.Set(kv[0], kv[1]); err != nil {
+			return fmt.Errorf("%s: %s: %s", fileName, kv[0], err)
+		}
+	}
+	return nil
+}
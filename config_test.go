@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfigValue(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		err  bool
+	}{
+		{raw: `"data"`, want: "data"},
+		{raw: `"tab\there \"quoted\" \u00e9"`, want: "tab\there \"quoted\" é"},
+		{raw: `'C:\wiki\data'`, want: `C:\wiki\data`},
+		{raw: `'say "hi"'`, want: `say "hi"`},
+		{raw: `""`, want: ""},
+		{raw: `8080`, want: "8080"},
+		{raw: `true`, want: "true"},
+		{raw: `["ann", "bob"]`, want: "ann,bob"},
+		{raw: `[ 'ann' ,"bob",carl ]`, want: "ann,bob,carl"},
+		{raw: `["a]b", 'c[d']`, want: "a]b,c[d"},
+		{raw: `[1, 2, 3,]`, want: "1,2,3"},
+		{raw: `[]`, want: ""},
+		{raw: `"data" "more"`, err: true},
+		{raw: `"unterminated`, err: true},
+		{raw: `'unterminated`, err: true},
+		{raw: `"bad \q escape"`, err: true},
+		{raw: `["a,b"]`, err: true},
+		{raw: `["a" "b"]`, err: true},
+		{raw: `["a", ["b"]]`, err: true},
+		{raw: `["a"`, err: true},
+		{raw: `[,]`, err: true},
+		{raw: `"""multi"""`, err: true},
+		{raw: ``, err: true},
+	}
+	for _, tt := range tests {
+		got, err := configValue(tt.raw)
+		if tt.err {
+			if err == nil {
+				t.Errorf("configValue(%s) = %q, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("configValue(%s) = %q, %v, want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestStripComment(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`path = "data" # the pages`, `path = "data" `},
+		{`motd = "# not a comment"`, `motd = "# not a comment"`},
+		{`motd = '# not a comment' # one`, `motd = '# not a comment' `},
+		{`motd = "it's # inside"`, `motd = "it's # inside"`},
+		{`motd = "a\\" # after an escaped backslash`, `motd = "a\\" `},
+		{`motd = "a\"#b"`, `motd = "a\"#b"`},
+		{`# only a comment`, ``},
+	}
+	for _, tt := range tests {
+		if got := stripComment(tt.line); got != tt.want {
+			t.Errorf("stripComment(%s) = %s, want %s", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gowiki.toml")
+	config := `# gowiki settings
+address = ":8080"
+admins = ["ann", 'bob'] # comma separated for the flag
+
+[tls]
+cert = 'C:\certs\wiki.pem'
+
+[wikis.team1]
+prefix = "/team1"
+`
+	if err := ioutil.WriteFile(file, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	values, err := readConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]string{
+		{"address", ":8080"},
+		{"admins", "ann,bob"},
+		{"tls-cert", `C:\certs\wiki.pem`},
+		{"wikis.team1-prefix", "/team1"},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("readConfig = %q, want %q", values, want)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("path = \"data\"\nadmins = [\"ann\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readConfig(file); err == nil || err.Error() != file+`:2: unterminated array ["ann"` {
+		t.Errorf("readConfig of an unterminated array = %v", err)
+	}
+}
+
+func TestSplitWikis(t *testing.T) {
+	tests := []struct {
+		values [][2]string
+		rest   [][2]string
+		wikis  map[string][][2]string
+		err    bool
+	}{
+		{
+			values: [][2]string{{"path", "data"}, {"wikis.team1-prefix", "/team1"}, {"users", "u"}, {"wikis.team1-path", "d1"}, {"wikis.ops-host", "ops.example.com"}},
+			rest:   [][2]string{{"path", "data"}, {"users", "u"}},
+			wikis: map[string][][2]string{
+				"team1": {{"prefix", "/team1"}, {"path", "d1"}},
+				"ops":   {{"host", "ops.example.com"}},
+			},
+		},
+		{values: [][2]string{{"wikis.team-1-path", "d"}}, wikis: map[string][][2]string{"team": {{"1-path", "d"}}}},
+		{values: [][2]string{{"wikis.team1", "d"}}, err: true},
+		{values: [][2]string{{"wikis.te am-path", "d"}}, err: true},
+		{values: [][2]string{{"wikis.-path", "d"}}, err: true},
+	}
+	for _, tt := range tests {
+		rest, wikis, err := splitWikis("gowiki.toml", tt.values)
+		if tt.err {
+			if err == nil {
+				t.Errorf("splitWikis(%q) succeeded", tt.values)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitWikis(%q): %s", tt.values, err)
+			continue
+		}
+		if !reflect.DeepEqual(rest, tt.rest) {
+			t.Errorf("splitWikis(%q) left %q, want %q", tt.values, rest, tt.rest)
+		}
+		got := make(map[string][][2]string)
+		for _, w := range wikis {
+			got[w.name] = w.values
+		}
+		if !reflect.DeepEqual(got, tt.wikis) {
+			t.Errorf("splitWikis(%q) = %q, want %q", tt.values, got, tt.wikis)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	tests := []struct {
+		args   []string
+		values [][2]string
+		want   string
+		err    bool
+	}{
+		{nil, [][2]string{{"name", "file"}}, "file", false},
+		{[]string{"-name", "flag"}, [][2]string{{"name", "file"}}, "flag", false},
+		{nil, [][2]string{{"unknown", "x"}}, "", true},
+		{nil, [][2]string{{"config", "other.toml"}}, "", true},
+		{nil, [][2]string{{"count", "many"}}, "", true},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		name := fs.String("name", "", "")
+		fs.String("config", "", "")
+		fs.Int("count", 0, "")
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		err := applyConfig(fs, "gowiki.toml", tt.values)
+		if tt.err {
+			if err == nil {
+				t.Errorf("applyConfig(%q) succeeded", tt.values)
+			}
+			continue
+		}
+		if err != nil || *name != tt.want {
+			t.Errorf("applyConfig(%q) with %q: name %q, %v, want %q", tt.values, tt.args, *name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// logFile is a log file that can be reopened after it was rotated
+type logFile struct {
+	sync.Mutex
+	name string
+	f    *os.File
+}
+
+func openLogFile(name string) (*logFile, error) {
+	lf := &logFile{name: name}
+	return lf, lf.reopen()
+}
+
+func (lf *logFile) Write(b []byte) (int, error) {
+	lf.Lock()
+	defer lf.Unlock()
+	return lf.f.Write(b)
+}
+
+// Opens the file by its name again, e.g. after logrotate moved it away
+func (lf *logFile) reopen() error {
+	f, err := os.OpenFile(lf.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	lf.Lock()
+	old := lf.f
+	lf.f = f
+	lf.Unlock()
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Reopens the log file whenever the process receives SIGHUP
+func (lf *logFile) reopenOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := lf.reopen(); err != nil {
+				log.Print("Error reopening log file: ", err)
+			}
+		}
+	}()
+}
@@ -1,347 +1,176 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"context"
 	"flag"
 	"fmt"
-	"html/template"
 	"io"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
-	"path/filepath"
-	"regexp"
 	"strings"
+	"time"
 
-	"github.com/gomarkdown/markdown"
-	"github.com/gomarkdown/markdown/ast"
-	"github.com/gomarkdown/markdown/html"
-	"github.com/gomarkdown/markdown/parser"
-	"github.com/microcosm-cc/bluemonday"
+	"github.com/Paspartout/gowiki/server"
+	"golang.org/x/crypto/bcrypt"
 )
 
-const (
-	LOCAL_TEMPLATE_PATH = "./tmpl/"
-	LOCAL_STATIC_PATH   = "./static/"
-	LOCAL_DATA_PATH     = "./data/"
-)
-
-const (
-	VIEW_PATH   = "/view/"
-	SAVE_PATH   = "/save/"
-	DELETE_PATH = "/delete/"
-	EDIT_PATH   = "/edit/"
-	PAGES_PATH  = "/pages/"
-	STATIC_PATH = "/static/"
-)
-
-type joki struct {
-	dataPath  string
-	templates map[string]*template.Template
-	wikiName  string
-}
-
-const (
-	extension      = ".md"
-	frontPageTitle = "Home"
-)
-
-// Page represents a page of the wiki
-type Page struct {
-	fileName string // not part of the viewed page
-	Title    string
-	Body     []byte
-	WikiName string
-}
-
-// RenderedPage represents a page that has been rendered to html
-type RenderedPage struct {
-	Title    string
-	Body     template.HTML
-	WikiName string
-}
-
-func (p *Page) save() error {
-	err := ioutil.WriteFile(p.fileName, p.Body, 0600)
-	_, isPerr := err.(*os.PathError)
-	if err != nil && isPerr {
-		// Try to fix path error by making dataPath directory
-		err = os.Mkdir(filepath.Dir(p.fileName), 0700)
-		if err != nil {
-			return err
-		}
-		log.Printf("Creating %s directory for pages", filepath.Dir(p.fileName))
-		return p.save()
-	}
-	return err
-}
-
-// Removes a page
-func (p *Page) remove() error {
-	return os.Remove(p.fileName)
-}
-
-// Renames the page to the new title
-func (p *Page) rename(newTitle string) error {
-	if !validTitle.MatchString(newTitle) {
-		return fmt.Errorf("new title \"%s\" is invalid", newTitle)
-	}
-
-	newFileName := filepath.Dir(p.fileName) + newTitle + extension
-	if err := os.Rename(p.fileName, newFileName); err == nil {
-		p.Title = newTitle
-		p.fileName = newFileName
-		return nil
-	} else {
-		return err
-	}
-}
+const noAccessLog = "off"
 
-// Loads a page using its title
-func (joki *joki) loadPage(title string) (*Page, error) {
-	fileName := joki.dataPath + title + extension
-	body, err := ioutil.ReadFile(fileName)
-	if err != nil {
-		return nil, err
+func main() {
+	config := server.DefaultConfig()
+	config.RegisterFlags(flag.CommandLine)
+
+	var address, exportDir, configFile string
+	var confluenceZip, notionZip, importNamespace, importSource, importCollisions string
+	var logFileName, accessLogFormat, exportFormat string
+	var otlpEndpoint, otlpService, backupFile, incrementalFrom, restoreFile string
+	var traceRatio float64
+	var verify, signExisting, restoreDryRun bool
+	var shutdownTimeout time.Duration
+	var hashPw bool
+	var tlsConf tlsConfig
+
+	flag.StringVar(&configFile, "config", "", "Config file with settings named like the flags, "+defaultConfigFile+" is read if it exists")
+	flag.StringVar(&address, "address", ":8080", "The address to listen to")
+	flag.StringVar(&logFileName, "log-file", "", "Write the log to this file instead of stderr, it is reopened on SIGHUP for log rotation")
+	flag.StringVar(&accessLogFormat, "access-log", server.LogfmtFormat, "Log every request as "+server.LogfmtFormat+" or "+server.JSONFormat+", or "+noAccessLog)
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "How long to wait for requests in flight when stopped by SIGINT or SIGTERM")
+	flag.StringVar(&tlsConf.certFile, "tls-cert", "", "Certificate file to serve https with")
+	flag.StringVar(&tlsConf.keyFile, "tls-key", "", "Key file of the -tls-cert certificate")
+	flag.StringVar(&tlsConf.domains, "autocert", "", "Comma separated domains to get Let's Encrypt certificates for, serve on :443")
+	flag.StringVar(&tlsConf.cacheDir, "autocert-cache", "autocert-cache", "Directory to keep Let's Encrypt certificates in")
+	flag.StringVar(&tlsConf.email, "autocert-email", "", "Contact address for Let's Encrypt expiry notices")
+	flag.StringVar(&tlsConf.httpAddr, "autocert-http", ":80", "Address answering Let's Encrypt challenges and redirecting to https")
+	flag.Var(server.LinkResolverFlag{}, "link", "Resolve references like [JIRA-123] as NAME=URL [glyph], {ref} and {id} in the URL are replaced, may be repeated")
+	flag.StringVar(&exportDir, "export", "", "Render all pages as a static html site into this directory and exit")
+	flag.StringVar(&exportFormat, "export-format", "html", "Export a html site, or markdown for hugo or jekyll")
+	flag.StringVar(&confluenceZip, "import-confluence", "", "Import a Confluence space from its HTML export zip and exit")
+	flag.StringVar(&notionZip, "import-notion", "", "Import a Notion workspace from its Markdown & CSV export zip and exit")
+	flag.IntVar(&config.MaxRenders, "max-renders", config.MaxRenders, "Render at most this many pages at once, 0 for no limit")
+	flag.IntVar(&config.MaxExports, "max-exports", config.MaxExports, "Make at most this many PDFs, backups and engine comparisons at once, 0 for no limit")
+	flag.IntVar(&config.MaxQueue, "max-queue", config.MaxQueue, "Requests waiting for -max-renders or -max-exports beyond this are answered with 503")
+	flag.DurationVar(&config.QueueTimeout, "queue-timeout", config.QueueTimeout, "Answer requests with 503 that waited this long for -max-renders or -max-exports")
+	flag.StringVar(&backupFile, "backup", "", "Write the data path to this .tar.gz file and exit, use /admin/backup for a running wiki")
+	flag.StringVar(&incrementalFrom, "incremental", "", "With -backup, write only what changed since this earlier backup next to it")
+	flag.StringVar(&restoreFile, "restore", "", "Replace the data path with a -backup file and exit, the previous data is kept next to it")
+	flag.BoolVar(&restoreDryRun, "restore-dry-run", false, "With -restore, check the backup and log what it would change without changing anything")
+	flag.StringVar(&importSource, "import", "", "Import the markdown files of a directory or zip and exit")
+	flag.StringVar(&importCollisions, "import-collisions", "skip", "What to do with imported pages whose title exists: skip, overwrite or rename")
+	flag.StringVar(&importNamespace, "import-namespace", "", "Namespace to import pages into, e.g. Confluence/Ops")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "Send traces of the requests to this OTLP/HTTP collector, e.g. http://localhost:4318")
+	flag.StringVar(&otlpService, "otlp-service", "gowiki", "Service name of the traces")
+	flag.Float64Var(&traceRatio, "trace-ratio", 1, "Fraction of the requests to trace, unless the caller decided already")
+	flag.BoolVar(&verify, "verify", false, "Check all pages and their history against the signatures of -signing-key and exit")
+	flag.BoolVar(&signExisting, "sign-existing", false, "Sign all pages and their history that are not signed yet and exit")
+	flag.BoolVar(&hashPw, "hash-password", false, "Read a password from stdin, print its hash for the users file and exit")
+	flag.Parse()
+	var err error
+	if config.Wikis, err = loadConfig(configFile); err != nil {
+		log.Fatal("Error loading config: ", err)
 	}
-	return &Page{Title: title, Body: body, fileName: fileName}, nil
-}
-
-func (joki *joki) newPage(title string) *Page {
-	return &Page{fileName: joki.dataPath + title + extension, Title: title}
-}
 
-func (joki *joki) exists(title string) bool {
-	filename := joki.dataPath + title + extension
-	_, err := os.Stat(filename)
-	return !os.IsNotExist(err)
-}
-
-func (joki *joki) initTemplates() {
-	const (
-		templatePath   = "tmpl/"
-		templateBase   = "tmpl/layout/base.html"
-		templateEnding = ".html"
-	)
-	templates := []string{"view", "edit", "delete", "new", "pages"}
-
-	for _, tpl := range templates {
-		var err error
-		joki.templates[tpl], err = template.New(tpl+templateEnding).ParseFiles(templateBase, templatePath+tpl+templateEnding)
+	var logOutput io.Writer = os.Stderr
+	if logFileName != "" {
+		lf, err := openLogFile(logFileName)
 		if err != nil {
-			log.Fatal("Error loading template:", tpl, err)
+			log.Fatal("Error opening log file: ", err)
 		}
+		lf.reopenOnHangup()
+		log.SetOutput(lf)
+		logOutput = lf
 	}
-}
-
-func (joki *joki) renderTemplate(w http.ResponseWriter, tmpl string, p interface{}) {
-	err := joki.templates[tmpl].ExecuteTemplate(w, tmpl+".html", p)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
-}
-
-var validTitle = regexp.MustCompile(`^([a-zA-Z0-9]+)$`)
-var validPath = regexp.MustCompile(`^/(((view|delete)/([a-zA-Z0-9]+))|((edit|save)/([a-zA-Z0-9]*)))$`)
-var linkRegex = regexp.MustCompile(`\[([a-zA-Z0-9]+)\]`)
-var langTags = regexp.MustCompile("^language-[a-zA-Z0-9]+$")
-var colorTags = regexp.MustCompile("^has-text-[a-zA-Z0-9-]+$")
-
-const mdExt parser.Extensions = parser.Tables | parser.FencedCode |
-	parser.Autolink | parser.Strikethrough | parser.SpaceHeadings |
-	parser.NoEmptyLineBeforeBlock | parser.HeadingIDs | parser.AutoHeadingIDs |
-	parser.BackslashLineBreak | parser.DefinitionLists | parser.MathJax |
-	parser.SuperSubscript | parser.Footnotes
-
-func (joki *joki) insertLinks(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
-
-	if _, ok := node.(*ast.Text); !ok {
-		return ast.GoToNext, false
-	}
-
-	// Interlinking
-	withLinks := linkRegex.ReplaceAllFunc(node.AsLeaf().Literal,
-		func(link []byte) []byte {
-			linkTitle := string(link)
-			linkTitle = linkTitle[1 : len(linkTitle)-1]
-
-			linkStr := "<a href=\"" + linkTitle + "\">"
-
-			if joki.exists(linkTitle) {
-				linkStr += linkTitle
-			} else {
-				linkStr += "<span class=\"has-text-danger\">" + linkTitle + " <sup>(No such page)</sup></span>"
-			}
-
-			linkStr += "</a>"
-			return []byte(linkStr)
-		})
-
-	w.Write(withLinks)
-
-	return ast.GoToNext, true
-}
-
-func (joki *joki) renderMarkdown(content []byte) []byte {
-	// carriage returns (ASCII 13) are messing things up
-	content = bytes.Replace(content, []byte{13}, []byte{}, -1)
-	opts := html.RendererOptions{
-		Flags:          html.CommonFlags,
-		RenderNodeHook: joki.insertLinks,
+	if accessLogFormat != server.LogfmtFormat && accessLogFormat != server.JSONFormat && accessLogFormat != noAccessLog {
+		log.Fatal("Unknown access log format: ", accessLogFormat)
 	}
 
-	return markdown.ToHTML(content, parser.NewWithExtensions(mdExt), html.NewRenderer(opts))
-}
-
-func (joki *joki) viewHandler(w http.ResponseWriter, r *http.Request, title string) {
-	p, err := joki.loadPage(title)
-	if err != nil {
-		http.Redirect(w, r, EDIT_PATH+title, http.StatusFound)
+	if hashPw {
+		if err := hashPassword(); err != nil {
+			log.Fatal("Error hashing password: ", err)
+		}
 		return
 	}
-
-	bodyRendered := joki.renderMarkdown(p.Body)
-
-	// Filter output html
-	bm := bluemonday.UGCPolicy()
-	bm.AllowAttrs("class").Matching(langTags).OnElements("code")  // language tags
-	bm.AllowAttrs("class").Matching(colorTags).OnElements("span") // span color selection
-	bodyRendered = bm.SanitizeBytes(bodyRendered)
-
-	renderedPage := &RenderedPage{
-		Title: p.Title,
-		Body:  template.HTML(bodyRendered)}
-
-	joki.renderTemplate(w, "view", renderedPage)
-}
-
-// Handles editing pages or creating a new page
-func (joki *joki) editHandler(w http.ResponseWriter, r *http.Request, title string) {
-	p, err := joki.loadPage(title)
-	if err != nil && os.IsNotExist(err) {
-		joki.renderTemplate(w, "new", title)
-		return
-	} else if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if backupFile != "" {
+		if err := server.Backup(config, backupFile, incrementalFrom); err != nil {
+			log.Fatal("Error backing up: ", err)
+		}
 		return
 	}
-	joki.renderTemplate(w, "edit", p)
-}
-
-// Handles saving and moving pages
-func (joki *joki) saveHandler(w http.ResponseWriter, r *http.Request, title string) {
-	body := strings.Replace(r.FormValue("body"), "\r", "", -1)
-	newTitle := r.FormValue("title")
-	if title == "" {
-		title = newTitle // use form title for creating a new page
+	if restoreFile != "" {
+		if err := server.Restore(config, restoreFile, restoreDryRun); err != nil {
+			log.Fatal("Error restoring: ", err)
+		}
+		return
 	}
 
-	// Check for valid title before saving
-	if !validTitle.MatchString(title) {
-		http.Error(w, "Title name is invalid: "+title, http.StatusBadRequest)
+	if verify || signExisting || importSource != "" || confluenceZip != "" || notionZip != "" || exportDir != "" {
+		wiki, err := server.Open(config)
+		if err != nil {
+			log.Fatal("Error opening wiki: ", err)
+		}
+		switch {
+		case verify || signExisting:
+			if err := wiki.Verify(signExisting); err != nil {
+				log.Fatal("Error verifying signatures: ", err)
+			}
+		case importSource != "":
+			if err := wiki.Import(importSource, importNamespace, importCollisions); err != nil {
+				log.Fatal("Error importing: ", err)
+			}
+		case confluenceZip != "":
+			if err := wiki.ImportConfluence(confluenceZip, importNamespace); err != nil {
+				log.Fatal("Error importing Confluence space: ", err)
+			}
+		case notionZip != "":
+			if err := wiki.ImportNotion(notionZip, importNamespace); err != nil {
+				log.Fatal("Error importing Notion export: ", err)
+			}
+		default:
+			if err := wiki.Export(exportDir, exportFormat); err != nil {
+				log.Fatal("Error exporting wiki: ", err)
+			}
+		}
 		return
 	}
 
-	// Create or Overwrite page
-	p := joki.newPage(title)
-	p.Body = []byte(body)
-	err := p.save()
+	handler, err := server.NewServer(config)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		log.Fatal("Error opening wiki: ", err)
 	}
-
-	// Rename/Move page if title was changed
-	if newTitle != title {
-		err := p.rename(newTitle)
+	if otlpEndpoint != "" {
+		flush, err := startTracing(otlpEndpoint, otlpService, traceRatio)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			log.Fatal("Error starting tracing: ", err)
 		}
-		title = newTitle
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := flush(ctx); err != nil {
+				log.Print("Error flushing traces: ", err)
+			}
+		}()
 	}
-
-	http.Redirect(w, r, VIEW_PATH+title, http.StatusFound)
-}
-
-func (joki *joki) deleteHandler(w http.ResponseWriter, r *http.Request, title string) {
-	deletionConfirmed := r.FormValue("Confirmed") == "True"
-	p := joki.newPage(title)
-
-	if deletionConfirmed {
-		err := p.remove()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	if accessLogFormat != noAccessLog {
+		if handler, err = server.AccessLog(logOutput, accessLogFormat, handler); err != nil {
+			log.Fatal(err)
 		}
-		http.Redirect(w, r, VIEW_PATH+frontPageTitle, http.StatusFound)
-	} else {
-		joki.renderTemplate(w, "delete", p)
 	}
-}
-
-func (joki *joki) makeHandler(fn func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		m := validPath.FindStringSubmatch(r.URL.Path)
-		// log.Printf("%#v\n", m)
-		if m == nil {
-			http.NotFound(w, r)
-			return
-		}
-
-		// m[4]+m[7] is the content of the capture groups that eventually contain
-		// the page title in /edit/title and /save/title but always contain
-		// the page title in /view/title and /delete/title
-		fn(w, r, m[4]+m[7])
+	if err := listen(address, handler, &tlsConf, shutdownTimeout); err != nil {
+		log.Fatal(err)
 	}
+	log.Print("Stopped")
 }
 
-func (joki *joki) pagesHandler(w http.ResponseWriter, r *http.Request) {
-	dataFiles, err := ioutil.ReadDir(joki.dataPath)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Filter for page files
-	pages := make([]string, 0, len(dataFiles))
-	for _, f := range dataFiles {
-		fName := f.Name()
-		if !f.IsDir() && fName[len(fName)-3:] == extension {
-			pages = append(pages, fName[:len(fName)-len(extension)])
-		}
+// Reads a password from stdin and prints its hash for the users file
+func hashPassword() error {
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return err
 	}
-
-	joki.renderTemplate(w, "pages", pages)
-}
-
-func main() {
-
-	joki := joki{
-		templates: make(map[string]*template.Template),
+	hash, err := bcrypt.GenerateFromPassword([]byte(strings.TrimRight(password, "\r\n")), bcrypt.DefaultCost)
+	if err != nil {
+		return err
 	}
-
-	var address string
-
-	flag.StringVar(&address, "address", ":8080", "The address to listen to")
-	flag.StringVar(&joki.dataPath, "path", LOCAL_DATA_PATH, "Path to the folder that contains the document files")
-	flag.StringVar(&joki.wikiName, "wikiname", "JoKi", "Name of wiki")
-	flag.Parse()
-
-	joki.initTemplates()
-
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, VIEW_PATH+frontPageTitle, http.StatusFound)
-	})
-
-	http.HandleFunc(VIEW_PATH, joki.makeHandler(joki.viewHandler))
-	http.HandleFunc(SAVE_PATH, joki.makeHandler(joki.saveHandler))
-	http.HandleFunc(DELETE_PATH, joki.makeHandler(joki.deleteHandler))
-	http.HandleFunc(EDIT_PATH, joki.makeHandler(joki.editHandler))
-
-	http.HandleFunc(PAGES_PATH, joki.pagesHandler)
-	http.Handle(STATIC_PATH, http.StripPrefix(STATIC_PATH, http.FileServer(http.Dir(LOCAL_STATIC_PATH))))
-
-	http.ListenAndServe(address, nil)
+	fmt.Println(string(hash))
+	return nil
 }
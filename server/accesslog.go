@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The formats access log entries can be written in
+const (
+	LogfmtFormat = "logfmt"
+	JSONFormat   = "json"
+)
+
+// statusRecorder remembers the status and size of a response for the log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.size += int64(n)
+	return n, err
+}
+
+// logField is a key and value of an access log entry
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// accessLog writes one entry per request
+type accessLog struct {
+	sync.Mutex
+	w      io.Writer
+	format string
+}
+
+// AccessLog logs the requests handled by next to w, one line per request in
+// LogfmtFormat or JSONFormat
+func AccessLog(w io.Writer, format string, next http.Handler) (http.Handler, error) {
+	if format != LogfmtFormat && format != JSONFormat {
+		return nil, fmt.Errorf("unknown access log format: %s", format)
+	}
+	return (&accessLog{w: w, format: format}).handler(next), nil
+}
+
+// Logs the requests handled by next
+func (l *accessLog) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r)
+		if sr.status == 0 {
+			sr.status = http.StatusOK // nothing was written
+		}
+
+		ip := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		fields := []logField{
+			{"time", start.UTC().Format(time.RFC3339)},
+			{"method", r.Method},
+			{"path", r.URL.RequestURI()},
+			{"status", sr.status},
+			{"bytes", sr.size},
+			{"duration_ms", float64(time.Since(start).Microseconds()) / 1000},
+			{"ip", ip},
+		}
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			fields = append(fields, logField{"forwarded_for", forwarded})
+		}
+		l.write(fields)
+	})
+}
+
+// Writes an entry as a line of JSON or logfmt
+func (l *accessLog) write(fields []logField) {
+	var line bytes.Buffer
+	if l.format == JSONFormat {
+		line.WriteString("{")
+		for i, f := range fields {
+			value, _ := json.Marshal(f.value)
+			if i > 0 {
+				line.WriteString(",")
+			}
+			fmt.Fprintf(&line, "%q:%s", f.key, value)
+		}
+		line.WriteString("}\n")
+	} else {
+		for i, f := range fields {
+			value := fmt.Sprint(f.value)
+			if strings.ContainsAny(value, " \"=\\") || value == "" {
+				value = strconv.Quote(value)
+			}
+			if i > 0 {
+				line.WriteString(" ")
+			}
+			line.WriteString(f.key + "=" + value)
+		}
+		line.WriteString("\n")
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.w.Write(line.Bytes())
+}
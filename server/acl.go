@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// The group of the users given with -admins
+const adminsGroup = "@admins"
+
+// Loads groups from a file with one "name: user, user" per line.
+// Empty lines and lines starting with # are ignored.
+func loadGroups(fileName string, groups map[string][]string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return fmt.Errorf("%s:%d: expected name: user, user", fileName, line)
+		}
+		var members []string
+		for _, user := range strings.Split(parts[1], ",") {
+			if user = strings.TrimSpace(user); user != "" {
+				members = append(members, user)
+			}
+		}
+		groups["@"+strings.TrimSpace(parts[0])] = members
+	}
+	return scanner.Err()
+}
+
+// Returns whether user is one of names, which are user names or groups
+// like @ops
+func (joki *joki) listed(user string, names []string) bool {
+	for _, name := range names {
+		switch {
+		case name == user:
+			return true
+		case name == adminsGroup && joki.adminUser(user):
+			return true
+		case strings.HasPrefix(name, "@") && containsString(joki.groups[name], user):
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether the request may see the history, old versions and the
+// markdown source of pages: everyone who may read them, unless
+// -history-readers names the users and @groups who may
+func (joki *joki) canSeeHistory(r *http.Request) bool {
+	return len(joki.historyReaders) == 0 || joki.isAdmin(r) || joki.listed(joki.currentUser(r), joki.historyReaders)
+}
+
+// Returns whether the request may read page title. Pages listing readers
+// in their front matter can only be read by them, their editors and admins.
+func (joki *joki) canRead(r *http.Request, title string) bool {
+	meta := joki.meta.get(title)
+	if !joki.authEnabled() || len(meta.Readers) == 0 {
+		return true
+	}
+	return joki.readable(joki.currentUser(r), meta)
+}
+
+// Returns whether user may read a page with meta, which need not be stored
+// as a page, like a deleted one
+func (joki *joki) readable(user string, meta PageMeta) bool {
+	if !joki.authEnabled() || len(meta.Readers) == 0 {
+		return true
+	}
+	return user != "" && (joki.adminUser(user) || joki.listed(user, meta.Readers) || joki.listed(user, meta.Editors))
+}
+
+// Returns whether the request may change page title. Pages listing editors
+// in their front matter can only be changed by them and admins, the others
+// by everyone who may read them. The settings of namespaces are left to
+// admins.
+func (joki *joki) canEdit(r *http.Request, title string) bool {
+	meta := joki.meta.get(title)
+	if !joki.authEnabled() {
+		return true
+	}
+	if configNamespace(title) != "" {
+		return joki.isAdmin(r)
+	}
+	if len(meta.Editors) == 0 {
+		return joki.canRead(r, title)
+	}
+	user := joki.currentUser(r)
+	return user != "" && (joki.adminUser(user) || joki.listed(user, meta.Editors))
+}
+
+// Routes of makeHandler that only read a page
+var readingRoutes = map[string]bool{
+	"view": true, "history": true, "diff": true, "pdf": true, "feedback": true, "raw": true,
+}
+
+// Checks the access control list of page title for a route of makeHandler.
+// Anonymous users are sent to the login, others are refused.
+func (joki *joki) pageAllowed(w http.ResponseWriter, r *http.Request, route, title string) bool {
+	allowed := joki.canEdit(r, title)
+	if readingRoutes[route] {
+		allowed = joki.canRead(r, title)
+	}
+	if allowed {
+		return true
+	}
+	if joki.currentUser(r) != "" {
+		http.Error(w, "You may not access "+title, http.StatusForbidden)
+	} else if r.Method != http.MethodGet {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+	} else {
+		http.Redirect(w, r, LOGIN_PATH+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+	}
+	return false
+}
+
+// Answers with 403 and returns false if the request may not read page title,
+// with 404 if it is a draft of someone else
+func (joki *joki) apiCanReadPage(w http.ResponseWriter, r *http.Request, title string) bool {
+	if joki.hiddenDraft(r, title) {
+		writeJSONError(w, http.StatusNotFound, "no such page: "+title)
+		return false
+	}
+	if !joki.canRead(r, title) {
+		writeJSONError(w, http.StatusForbidden, "you may not read "+title)
+		return false
+	}
+	return true
+}
+
+// Answers with 403 and returns false if the request may not change page
+// title, with 404 if it is a draft of someone else
+func (joki *joki) apiCanEditPage(w http.ResponseWriter, r *http.Request, title string) bool {
+	if joki.hiddenDraft(r, title) {
+		writeJSONError(w, http.StatusNotFound, "no such page: "+title)
+		return false
+	}
+	if !joki.canEdit(r, title) {
+		writeJSONError(w, http.StatusForbidden, "you may not change "+title)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPageACL(t *testing.T) {
+	tw := newTestWiki(t, nil)
+	tw.store("Open", "# Open")
+	tw.store("Team", "---\nreaders: [ann]\n---\n# Team")
+	tw.store("Ops", "---\nreaders: [\"@ops\"]\n---\n# Ops")
+	tw.store("Locked", "---\neditors: [ann]\n---\n# Locked")
+	tw.store("Private", "---\nreaders: [cy]\neditors: [ann]\n---\n# Private")
+
+	tests := []struct {
+		route, title, user string
+		status             int
+	}{
+		{"view", "Open", "", http.StatusOK},
+		{"edit", "Open", "cy", http.StatusOK},
+
+		// readers
+		{"view", "Team", "", http.StatusFound},
+		{"view", "Team", "cy", http.StatusForbidden},
+		{"view", "Team", "ann", http.StatusOK},
+		{"view", "Team", "bob", http.StatusOK},
+		{"edit", "Team", "cy", http.StatusForbidden},
+		{"edit", "Team", "ann", http.StatusOK},
+		{"raw", "Team", "cy", http.StatusForbidden},
+
+		// groups
+		{"view", "Ops", "cy", http.StatusOK},
+		{"view", "Ops", "ann", http.StatusForbidden},
+
+		// editors
+		{"view", "Locked", "cy", http.StatusOK},
+		{"edit", "Locked", "cy", http.StatusForbidden},
+		{"edit", "Locked", "ann", http.StatusOK},
+		{"edit", "Locked", "bob", http.StatusOK},
+
+		// editors may read what they edit
+		{"view", "Private", "ann", http.StatusOK},
+		{"view", "Private", "cy", http.StatusOK},
+		{"edit", "Private", "cy", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := tw.request(http.MethodGet, "/"+tt.route+"/"+tt.title, tt.user, nil)
+		if w.Code != tt.status {
+			t.Errorf("%s %s as %q: status %d, want %d", tt.route, tt.title, tt.user, w.Code, tt.status)
+		}
+	}
+}
+
+func TestSaveACL(t *testing.T) {
+	tests := []struct {
+		user   string
+		status int
+		saved  bool
+	}{
+		{"", http.StatusUnauthorized, false},
+		{"cy", http.StatusForbidden, false},
+		{"ann", http.StatusFound, true},
+		{"bob", http.StatusFound, true},
+	}
+	for _, tt := range tests {
+		tw := newTestWiki(t, nil)
+		tw.store("Locked", "---\neditors: [ann]\n---\n# Locked")
+		body := "---\neditors: [ann]\n---\n# Changed"
+		w := tw.save("Locked", body, tt.user)
+		if w.Code != tt.status {
+			t.Errorf("saving as %q: status %d, want %d", tt.user, w.Code, tt.status)
+		}
+		if saved := tw.body("Locked") == body; saved != tt.saved {
+			t.Errorf("saving as %q: saved %v, want %v", tt.user, saved, tt.saved)
+		}
+	}
+}
+
+func TestHiddenPagesAreNotListed(t *testing.T) {
+	tw := newTestWiki(t, nil)
+	tw.store("Open", "# Open")
+	tw.store("Team", "---\nreaders: [ann]\n---\n# Team")
+
+	tests := []struct {
+		user string
+		team bool
+	}{
+		{"", false},
+		{"cy", false},
+		{"ann", true},
+		{"bob", true},
+	}
+	for _, tt := range tests {
+		if visible := tw.joki.visible("Team", tt.user); visible != tt.team {
+			t.Errorf("Team visible for %q: %v, want %v", tt.user, visible, tt.team)
+		}
+		if !tw.joki.visible("Open", tt.user) {
+			t.Errorf("Open hidden from %q", tt.user)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Architecture decision records live in the ADR namespace and are numbered,
+// e.g. ADR/0007. Their status and links to other records are plain lines
+// like "Status: accepted" and "Supersedes: [ADR/0003]" in the page.
+const (
+	adrNamespace = "ADR"
+	adrTemplate  = adrNamespace + "/Template"
+
+	adrProposed   = "proposed"
+	adrSuperseded = "superseded"
+)
+
+const defaultADRTemplate = `# {{.Number}}. {{.Title}}
+
+Status: proposed
+Date: {{.Date}}
+{{if .Supersedes}}Supersedes: [{{.Supersedes}}]
+{{end}}
+## Context
+
+## Decision
+
+## Consequences
+`
+
+var (
+	adrNumber       = regexp.MustCompile(`^[0-9]{4}$`)
+	adrStatus       = regexp.MustCompile(`(?im)^status:[ \t]*(.*)$`)
+	adrSupersedes   = regexp.MustCompile(`(?im)^supersedes:[ \t]*(.*)$`)
+	adrSupersededBy = regexp.MustCompile(`(?im)^superseded-by:[ \t]*(.*)$`)
+)
+
+// ADR is a decision record as listed in the index
+type ADR struct {
+	Title        string // page title, ADR/0007
+	Number       int
+	Name         string // first heading of the record
+	Status       string
+	Supersedes   []string
+	SupersededBy []string
+}
+
+// Returns the links in the field matched by re
+func adrLinks(re *regexp.Regexp, body []byte) []string {
+	var links []string
+	for _, m := range re.FindAllSubmatch(body, -1) {
+		for _, l := range linkRegex.FindAllSubmatch(m[1], -1) {
+			links = append(links, joinTitle(adrNamespace, string(l[1])))
+		}
+	}
+	return links
+}
+
+// Resolves a link written on an ADR page to a full title
+func joinTitle(ns, link string) string {
+	if strings.HasPrefix(link, ns+"/") {
+		return link
+	}
+	return ns + "/" + link
+}
+
+// Reads the records of the ADR namespace, ordered by number. Superseded-by
+// is filled from the Supersedes lines of later records as well.
+func (joki *joki) adrs() ([]ADR, error) {
+	titles, err := joki.listPages()
+	if err != nil {
+		return nil, err
+	}
+	var adrs []ADR
+	index := make(map[string]int)
+	for _, title := range titles {
+		if namespace(title) != adrNamespace || !adrNumber.MatchString(title[len(adrNamespace)+1:]) {
+			continue
+		}
+		p, err := joki.loadPage(title)
+		if err != nil {
+			return nil, err
+		}
+		adr := ADR{Title: title, Status: adrProposed}
+		adr.Number, _ = strconv.Atoi(title[len(adrNamespace)+1:])
+		adr.Name = title
+		if hs := pageHeadings(p.Body); len(hs) > 0 {
+			adr.Name = hs[0].Text
+		}
+		if m := adrStatus.FindSubmatch(p.Body); m != nil {
+			adr.Status = strings.ToLower(strings.TrimSpace(string(m[1])))
+		}
+		adr.Supersedes = adrLinks(adrSupersedes, p.Body)
+		adr.SupersededBy = adrLinks(adrSupersededBy, p.Body)
+		index[title] = len(adrs)
+		adrs = append(adrs, adr)
+	}
+
+	for _, adr := range adrs {
+		for _, old := range adr.Supersedes {
+			i, ok := index[old]
+			if ok && !containsString(adrs[i].SupersededBy, adr.Title) {
+				adrs[i].SupersededBy = append(adrs[i].SupersededBy, adr.Title)
+			}
+		}
+	}
+	sort.Slice(adrs, func(i, j int) bool { return adrs[i].Number < adrs[j].Number })
+	return adrs, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Shows the index of all decision records
+func (joki *joki) adrHandler(w http.ResponseWriter, r *http.Request) {
+	adrs, err := joki.adrs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user := joki.currentUser(r)
+	visible := adrs[:0]
+	for _, adr := range adrs {
+		if joki.visible(adr.Title, user) {
+			visible = append(visible, adr)
+		}
+	}
+	joki.renderTemplate(w, r, "adr", visible)
+}
+
+// Marks the record in body as superseded by the record title
+func supersede(body []byte, title string) []byte {
+	if adrStatus.Match(body) {
+		body = adrStatus.ReplaceAll(body, []byte("Status: "+adrSuperseded))
+	} else {
+		body = append([]byte("Status: "+adrSuperseded+"\n"), body...)
+	}
+	line := []byte("Superseded-by: [" + title + "]")
+	loc := adrStatus.FindIndex(body)
+	return append(body[:loc[1]], append(append([]byte("\n"), line...), body[loc[1]:]...)...)
+}
+
+// Creates the next numbered record from the ADR template. If it supersedes
+// an earlier record, that one is marked superseded in the same change.
+func (joki *joki) adrNewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Creating a decision record requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "A decision record needs a title", http.StatusBadRequest)
+		return
+	}
+	adrs, err := joki.adrs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	number := 1
+	if len(adrs) > 0 {
+		number = adrs[len(adrs)-1].Number + 1
+	}
+	title := fmt.Sprintf("%s/%04d", adrNamespace, number)
+	old := r.FormValue("supersedes")
+	if old != "" && !joki.exists(old) {
+		http.Error(w, "No such decision record: "+old, http.StatusBadRequest)
+		return
+	}
+
+	source := []byte(defaultADRTemplate)
+	if tp, err := joki.loadPage(adrTemplate); err == nil {
+		source = tp.Body
+	}
+	body, err := instantiate(source, map[string]string{
+		"Number":     strconv.Itoa(number),
+		"Title":      name,
+		"Date":       time.Now().Format("2006-01-02"),
+		"Supersedes": old,
+	})
+	if err != nil {
+		http.Error(w, "ADR template: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if old != "" {
+		if err := joki.checkEditLock(r, old); err != nil {
+			http.Error(w, err.Error(), changeStatus(err))
+			return
+		}
+	}
+	if err := joki.throttle(r, title); err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+	if _, err := joki.storePage(title, body, joki.currentUser(r)); err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+
+	changed := []string{pageFile(title)}
+	if old != "" {
+		p, err := joki.loadPage(old)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := joki.storePage(old, supersede(p.Body, title), joki.currentUser(r)); err != nil {
+			http.Error(w, err.Error(), changeStatus(err))
+			return
+		}
+		changed = append(changed, pageFile(old))
+	}
+	if err := joki.commitChange(r, "Create "+title+": "+name, changed, nil); err != nil {
+		http.Error(w, "Decision record saved but not committed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, EDIT_PATH+title, http.StatusFound)
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Languages the embedded search index stems words of
+const (
+	noStemming     = ""
+	englishStemmer = "english"
+)
+
+// searchAnalyzer turns the words of pages and queries into the terms the
+// embedded search index keeps, so "installing" finds "installs" and "k8s"
+// finds "kubernetes". Synonyms are read from a file with one group of
+// comma separated words per line, which is read again when it changes.
+type searchAnalyzer struct {
+	sync.RWMutex
+	language string
+	file     string            // of the synonyms, "" for none
+	modTime  time.Time         // of the synonyms file when it was read
+	synonyms map[string]string // word -> the first word of its group
+}
+
+// Returns the analyzer stemming words of language with the synonyms in file
+func newSearchAnalyzer(language, file string) (*searchAnalyzer, error) {
+	if language != noStemming && language != englishStemmer {
+		return nil, fmt.Errorf("unknown search language: %s", language)
+	}
+	a := &searchAnalyzer{language: language, file: file, synonyms: make(map[string]string)}
+	if file == "" {
+		return a, nil
+	}
+	if _, err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reads the synonyms file again if it changed since it was read last and
+// returns whether it did
+func (a *searchAnalyzer) reload() (bool, error) {
+	if a.file == "" {
+		return false, nil
+	}
+	info, err := os.Stat(a.file)
+	if err != nil {
+		return false, err
+	}
+	a.RLock()
+	unchanged := info.ModTime().Equal(a.modTime)
+	a.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	synonyms, err := readSynonyms(a.file)
+	if err != nil {
+		return false, err
+	}
+	a.Lock()
+	a.synonyms, a.modTime = synonyms, info.ModTime()
+	a.Unlock()
+	log.Printf("Read %d synonyms from %s", len(synonyms), a.file)
+	return true, nil
+}
+
+// Reads groups of synonyms like "kubernetes, k8s, kube", one per line.
+// Lines starting with # are comments.
+func readSynonyms(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	synonyms := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var group []string
+		for _, word := range strings.Split(line, ",") {
+			if words := tokenize(word); len(words) == 1 {
+				group = append(group, words[0])
+			} else if len(words) > 1 {
+				return nil, fmt.Errorf("%s: synonyms are single words, not \"%s\"", file, strings.TrimSpace(word))
+			}
+		}
+		for _, word := range group {
+			synonyms[word] = group[0]
+		}
+	}
+	return synonyms, scanner.Err()
+}
+
+// Returns the terms of text
+func (a *searchAnalyzer) terms(text string) []string {
+	words := tokenize(text)
+	a.RLock()
+	defer a.RUnlock()
+	for i, word := range words {
+		words[i] = a.term(word)
+	}
+	return words
+}
+
+// Returns the term of a lowercased word: the stem of the first word of its
+// synonyms
+func (a *searchAnalyzer) term(word string) string {
+	if first, ok := a.synonyms[word]; ok {
+		word = first
+	}
+	if a.language == englishStemmer {
+		word = stemEnglish(word)
+	}
+	return word
+}
+
+// Returns the words of query together with their stems and synonyms, to
+// highlight them in the pages found
+func (a *searchAnalyzer) variants(query string) []string {
+	a.RLock()
+	defer a.RUnlock()
+	var words []string
+	for _, word := range tokenize(query) {
+		words = append(words, word)
+		term := a.term(word)
+		if term != word {
+			words = append(words, term)
+		}
+		for synonym := range a.synonyms {
+			if synonym != word && a.term(synonym) == term {
+				words = append(words, synonym)
+			}
+		}
+	}
+	return words
+}
+
+// Strips the most common inflections off an English word, so plurals and
+// verb forms end up as the same term. It is much lighter than a Porter
+// stemmer, and leaves short and non-ASCII words alone.
+func stemEnglish(word string) string {
+	if len(word) <= 3 {
+		return word
+	}
+	for _, r := range word {
+		if r < 'a' || r > 'z' {
+			return word
+		}
+	}
+	switch {
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		return word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "sses"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "ches") || strings.HasSuffix(word, "shes") || strings.HasSuffix(word, "xes"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "ing") && len(word) > 5:
+		return undouble(word[:len(word)-3])
+	case strings.HasSuffix(word, "ed") && len(word) > 4:
+		return undouble(word[:len(word)-2])
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") &&
+		!strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// Drops the doubled consonant a suffix left, running -> runn -> run
+func undouble(stem string) string {
+	n := len(stem)
+	if n >= 3 && stem[n-1] == stem[n-2] && !strings.ContainsRune("aeioulsz", rune(stem[n-1])) {
+		return stem[:n-1]
+	}
+	return stem
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	annotationsDir    = ".comments"
+	maxAnnotationSize = 64 << 10
+)
+
+// Annotation is a comment on a range of text of a rendered page. The range
+// is found again by its quote and some surrounding text, so it survives
+// edits elsewhere on the page.
+type Annotation struct {
+	ID      string    `json:"id"`
+	Quote   string    `json:"quote"`
+	Prefix  string    `json:"prefix"`
+	Suffix  string    `json:"suffix"`
+	Text    string    `json:"text"`
+	Author  string    `json:"author"`
+	Created time.Time `json:"created"`
+}
+
+// Serializes changes to the annotation files
+var annotationsLock sync.Mutex
+
+func (joki *joki) annotationsFile(title string) string {
+	return filepath.Join(joki.dataPath, annotationsDir, filepath.FromSlash(title)+".json")
+}
+
+// Loads the annotations of a page, none if it has no file yet
+func (joki *joki) annotations(title string) ([]Annotation, error) {
+	data, err := ioutil.ReadFile(joki.annotationsFile(title))
+	if os.IsNotExist(err) {
+		return []Annotation{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var annotations []Annotation
+	return annotations, json.Unmarshal(data, &annotations)
+}
+
+func (joki *joki) saveAnnotations(title string, annotations []Annotation) error {
+	fileName := joki.annotationsFile(title)
+	if len(annotations) == 0 {
+		err := os.Remove(fileName)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(annotations, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0600)
+}
+
+// Moves the annotations along with a renamed page
+func (joki *joki) moveAnnotations(oldTitle, newTitle string) error {
+	newFile := joki.annotationsFile(newTitle)
+	if err := os.MkdirAll(filepath.Dir(newFile), 0700); err != nil {
+		return err
+	}
+	err := os.Rename(joki.annotationsFile(oldTitle), newFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Lists, adds and removes the annotations of a page:
+// GET /api/v1/comments/<title>, POST with a JSON annotation and
+// DELETE with ?id=, which only the author or an admin may do
+func (joki *joki) annotationsHandler(w http.ResponseWriter, r *http.Request) {
+	title := strings.TrimPrefix(r.URL.Path, COMMENTS_PATH+"/")
+	if !validPageTitle(title) || !joki.exists(title) {
+		writeJSONError(w, http.StatusNotFound, "no such page: "+title)
+		return
+	}
+	if !joki.apiCanReadPage(w, r, title) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if !joki.apiCanRead(w, r) {
+			return
+		}
+		annotations, err := joki.annotations(title)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, annotations)
+	case http.MethodPost, http.MethodDelete:
+		if !joki.apiCanWrite(w, r) {
+			return
+		}
+		if joki.quarantined(r) {
+			writeJSONError(w, http.StatusForbidden, errQuarantined)
+		} else if r.Method == http.MethodPost {
+			joki.addAnnotation(w, r, title)
+		} else {
+			joki.removeAnnotation(w, r, title)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET, POST and DELETE are allowed")
+	}
+}
+
+func (joki *joki) addAnnotation(w http.ResponseWriter, r *http.Request, title string) {
+	var a Annotation
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAnnotationSize)).Decode(&a); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	a.Quote, a.Text = strings.TrimSpace(a.Quote), strings.TrimSpace(a.Text)
+	if a.Quote == "" || a.Text == "" {
+		writeJSONError(w, http.StatusBadRequest, "a comment needs a quote and a text")
+		return
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.ID, a.Created = hex.EncodeToString(buf), time.Now()
+	if user := joki.currentUser(r); user != "" || a.Author == "" {
+		a.Author = joki.author(r)
+	}
+
+	annotationsLock.Lock()
+	defer annotationsLock.Unlock()
+	annotations, err := joki.annotations(title)
+	if err == nil {
+		err = joki.saveAnnotations(title, append(annotations, a))
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, a)
+}
+
+func (joki *joki) removeAnnotation(w http.ResponseWriter, r *http.Request, title string) {
+	id := r.FormValue("id")
+
+	annotationsLock.Lock()
+	defer annotationsLock.Unlock()
+	annotations, err := joki.annotations(title)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i, a := range annotations {
+		if a.ID != id {
+			continue
+		}
+		if !joki.isAdmin(r) && a.Author != joki.currentUser(r) {
+			writeJSONError(w, http.StatusForbidden, "only the author or an admin may remove a comment")
+			return
+		}
+		if err := joki.saveAnnotations(title, append(annotations[:i], annotations[i+1:]...)); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSONError(w, http.StatusNotFound, "no such comment: "+id)
+}
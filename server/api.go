@@ -0,0 +1,263 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const maxAPIBodySize = 10 << 20
+
+// APIPage is the JSON representation of a page
+type APIPage struct {
+	Title    string    `json:"title"`
+	Body     string    `json:"body,omitempty"`
+	Modified time.Time `json:"modified"`
+}
+
+// apiPageUpdate is the JSON body accepted to save a page
+type apiPageUpdate struct {
+	Body    string `json:"body"`
+	Message string `json:"message"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Error: msg})
+}
+
+// Returns whether the client prefers the raw markdown over JSON
+func wantsMarkdown(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "text/markdown", "text/plain":
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
+
+// Answers with 403 or 401 and returns false if the request may not change
+// pages
+func (joki *joki) apiCanWrite(w http.ResponseWriter, r *http.Request) bool {
+	if joki.readonly {
+		writeJSONError(w, http.StatusForbidden, "the wiki is read only")
+		return false
+	}
+	return joki.apiLoggedIn(w, r)
+}
+
+// Answers with 401 and returns false for anonymous requests to a wiki with
+// authentication
+func (joki *joki) apiLoggedIn(w http.ResponseWriter, r *http.Request) bool {
+	if !joki.authEnabled() || joki.currentUser(r) != "" {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+joki.wikiName+`"`)
+	writeJSONError(w, http.StatusUnauthorized, "login required")
+	return false
+}
+
+// Answers with 401 and returns false if the request may not read pages
+func (joki *joki) apiCanRead(w http.ResponseWriter, r *http.Request) bool {
+	return !joki.private || joki.apiLoggedIn(w, r)
+}
+
+// Lists all pages as JSON
+func (joki *joki) apiPagesHandler(w http.ResponseWriter, r *http.Request) {
+	if !joki.apiCanRead(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+		return
+	}
+
+	titles, err := joki.listPages()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pages := make([]APIPage, 0, len(titles))
+	for _, title := range joki.visiblePages(r, titles) {
+		modTime, err := joki.modTime(title)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		pages = append(pages, APIPage{Title: title, Modified: modTime})
+	}
+	writeJSON(w, http.StatusOK, pages)
+}
+
+// Reads, saves or deletes a single page
+func (joki *joki) apiPageHandler(w http.ResponseWriter, r *http.Request) {
+	title := strings.TrimPrefix(r.URL.Path, API_PAGES_PATH+"/")
+	if !validPageTitle(title) {
+		writeJSONError(w, http.StatusNotFound, "title is invalid: "+title)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if joki.apiCanRead(w, r) && joki.apiCanReadPage(w, r, title) {
+			joki.apiGetPage(w, r, title)
+		}
+	case http.MethodPut:
+		if joki.apiCanWrite(w, r) && joki.apiCanEditPage(w, r, title) {
+			joki.apiPutPage(w, r, title)
+		}
+	case http.MethodDelete:
+		if joki.apiCanWrite(w, r) && joki.apiCanEditPage(w, r, title) {
+			joki.apiDeletePage(w, r, title)
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET, PUT and DELETE are allowed")
+	}
+}
+
+func (joki *joki) apiGetPage(w http.ResponseWriter, r *http.Request, title string) {
+	if !joki.canSeeHistory(r) {
+		writeJSONError(w, http.StatusForbidden, "you may not see the source of pages")
+		return
+	}
+	span := startSpan(r, "load page", attribute.String("page.title", title))
+	p, err := joki.loadPage(title)
+	span.End()
+	if os.IsNotExist(err) {
+		writeJSONError(w, http.StatusNotFound, "no such page: "+title)
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if wantsMarkdown(r) {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Write(p.Body)
+		return
+	}
+	modTime, _ := joki.modTime(title)
+	writeJSON(w, http.StatusOK, APIPage{Title: title, Body: string(p.Body), Modified: modTime})
+}
+
+// Saves a page from a JSON body or from raw markdown
+func (joki *joki) apiPutPage(w http.ResponseWriter, r *http.Request, title string) {
+	raw, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	update := apiPageUpdate{Body: string(raw)}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		if err := json.Unmarshal(raw, &update); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+	}
+	body := strings.Replace(update.Body, "\r", "", -1)
+
+	status, message := http.StatusOK, "Update "+title
+	if !joki.exists(title) {
+		status, message = http.StatusCreated, "Create "+title
+	}
+	if update.Message != "" {
+		message = update.Message
+	}
+	if err := joki.checkRequiredTags(title, []byte(body)); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := joki.checkEditLock(r, title); err != nil {
+		writeJSONError(w, changeStatus(err), err.Error())
+		return
+	}
+	if held, err := joki.holdForModeration(r, HeldEdit{Title: title, Body: body, Message: message, API: true}); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	} else if held {
+		writeJSON(w, http.StatusAccepted, APIPage{Title: title, Body: body, Modified: time.Now()})
+		return
+	}
+
+	if err := joki.throttle(r, title); err != nil {
+		setRetryAfter(w, err)
+		writeJSONError(w, changeStatus(err), err.Error())
+		return
+	}
+	span := startSpan(r, "store page", attribute.String("page.title", title))
+	_, err = joki.storePage(title, []byte(body), joki.currentUser(r))
+	endSpan(span, err)
+	if err != nil {
+		writeJSONError(w, changeStatus(err), err.Error())
+		return
+	}
+	if err := joki.commitChange(r, message, []string{pageFile(title)}, nil); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "page saved but not committed: "+err.Error())
+		return
+	}
+
+	modTime, _ := joki.modTime(title)
+	if status == http.StatusCreated {
+		w.Header().Set("Location", API_PAGES_PATH+"/"+title)
+	}
+	writeJSON(w, status, APIPage{Title: title, Body: body, Modified: modTime})
+}
+
+func (joki *joki) apiDeletePage(w http.ResponseWriter, r *http.Request, title string) {
+	if !joki.exists(title) {
+		writeJSONError(w, http.StatusNotFound, "no such page: "+title)
+		return
+	}
+	if err := joki.checkEditLock(r, title); err != nil {
+		writeJSONError(w, changeStatus(err), err.Error())
+		return
+	}
+	if held, err := joki.holdForModeration(r, HeldEdit{Title: title, Delete: true, Message: "Delete " + title}); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	} else if held {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err := joki.throttle(r, title); err != nil {
+		setRetryAfter(w, err)
+		writeJSONError(w, changeStatus(err), err.Error())
+		return
+	}
+	span := startSpan(r, "remove page", attribute.String("page.title", title))
+	err := joki.removePage(title)
+	endSpan(span, err)
+	if err != nil {
+		writeJSONError(w, changeStatus(err), err.Error())
+		return
+	}
+	if err := joki.commitChange(r, "Delete "+title, nil, []string{pageFile(title)}); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "page deleted but not committed: "+err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+)
+
+// Matches /asof/<time>/view/<title>
+var asOfPath = regexp.MustCompile(`^` + ASOF_PATH + `([^/]+)/view/(` + titlePattern + `)$`)
+
+// Parses the time of a snapshot: a date, which means the end of that day,
+// a time like 2024-01-01T12:00:00Z or a revision id
+func parseAsOf(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(revisionTimeFormat, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("\"%s\" is no date, time or revision", s)
+}
+
+// Returns the version of a page that was current at t. Every save archives
+// the version it replaces under the time it was saved, so that is the
+// current page or the newest revision saved before t, unless the page was
+// deleted in between.
+func (joki *joki) versionAt(title string, t time.Time) ([]byte, error) {
+	if modTime, err := joki.modTime(title); err == nil && !modTime.After(t) {
+		p, err := joki.loadPage(title)
+		if err != nil {
+			return nil, err
+		}
+		return p.Body, nil
+	}
+
+	revs, err := joki.revisions(title)
+	if err != nil {
+		return nil, err
+	}
+	for _, rev := range revs {
+		if rev.Time.After(t) {
+			continue
+		}
+		entries, err := joki.trashEntries()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Title == title && e.Deleted.After(rev.Time) && !e.Deleted.After(t) {
+				return nil, os.ErrNotExist
+			}
+		}
+		return joki.loadRevision(title, rev.ID)
+	}
+	return nil, os.ErrNotExist
+}
+
+// Shows a page as it was at a date, with links to other pages as they were
+// at that date, too
+func (joki *joki) asOfHandler(w http.ResponseWriter, r *http.Request) {
+	m := asOfPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	asOf, title := m[1], m[2]
+	t, err := parseAsOf(asOf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !joki.canRead(r, title) {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := joki.versionAt(title, t)
+	if os.IsNotExist(err) {
+		http.Error(w, title+" did not exist at "+asOf, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if meta := pageMeta(body); meta.Draft && !joki.canSeeDraft(joki.currentUser(r), meta) {
+		http.NotFound(w, r)
+		return
+	}
+
+	html := joki.renderSafe(title, body)
+	html = bytes.Replace(html, []byte(`href="`+VIEW_PATH), []byte(`href="`+ASOF_PATH+asOf+VIEW_PATH), -1)
+	joki.renderTemplate(w, r, "view", &RenderedPage{
+		Title:       title,
+		Body:        template.HTML(html),
+		Breadcrumbs: breadcrumbs(title),
+		Meta:        pageMeta(body),
+		Locked:      true,
+		AsOf:        asOf,
+		History:     true,
+	})
+}
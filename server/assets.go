@@ -0,0 +1,47 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// The templates and static files are built into the binary, so gowiki runs
+// from any directory and programs embedding the server need not ship them
+//
+//go:embed tmpl static
+var assets embed.FS
+
+// Returns the static files built in
+func staticAssets() http.FileSystem {
+	static, err := fs.Sub(assets, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FS(static)
+}
+
+// Copies the directory src of the files built in to dst
+func copyAssets(src, dst string) error {
+	return fs.WalkDir(assets, src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, filepath.FromSlash(path))
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := assets.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, 0644)
+	})
+}
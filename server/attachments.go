@@ -0,0 +1,268 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	attachmentsDir = "attachments"
+	maxUploadSize  = 32 << 20
+)
+
+var validFileName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// Attachment is a file attached to a page
+type Attachment struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	URL     string `json:"url"`
+	IsImage bool   `json:"image"`
+}
+
+// Markdown returns the reference to insert into a page
+func (a Attachment) Markdown() string {
+	if a.IsImage {
+		return "![" + a.Name + "](" + a.URL + ")"
+	}
+	return "[" + a.Name + "](" + a.URL + ")"
+}
+
+// IsDrawing returns whether the attachment can be opened in the drawing editor
+func (a Attachment) IsDrawing() bool {
+	return filepath.Ext(a.Name) == ".svg"
+}
+
+// EditPage is a page opened in the editor together with its attachments
+type EditPage struct {
+	*Page
+	Attachments []Attachment
+	Editors     []string // others editing the page
+	Draft       bool
+	LockedUntil time.Time // of the edit lock, zero without -edit-locks
+}
+
+func (joki *joki) attachmentPath(title string) string {
+	return filepath.Join(joki.dataPath, attachmentsDir, title)
+}
+
+// Returns the data path relative name of an attachment for git
+func attachmentFile(title, name string) string {
+	return filepath.ToSlash(filepath.Join(attachmentsDir, title, name))
+}
+
+func newAttachment(title string, name string, size int64) Attachment {
+	return Attachment{
+		Name:    name,
+		Size:    size,
+		URL:     FILES_PATH + title + "/" + name,
+		IsImage: strings.HasPrefix(mime.TypeByExtension(filepath.Ext(name)), "image/"),
+	}
+}
+
+// Lists the attachments of a page
+func (joki *joki) attachments(title string) ([]Attachment, error) {
+	files, err := ioutil.ReadDir(joki.attachmentPath(title))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	attachments := make([]Attachment, 0, len(files))
+	for _, f := range files {
+		if !f.IsDir() {
+			attachments = append(attachments, newAttachment(title, f.Name(), f.Size()))
+		}
+	}
+	return attachments, nil
+}
+
+// Moves the attachments and their versions along with a renamed page
+func (joki *joki) moveAttachments(oldTitle, newTitle string) error {
+	if err := moveDir(joki.attachmentPath(oldTitle), joki.attachmentPath(newTitle)); err != nil {
+		return err
+	}
+	return joki.moveAttachmentVersions(oldTitle, newTitle)
+}
+
+// Stores an uploaded file for a page, removes one if "delete" is given or
+// restores the "version" of "restore". The version replaced is kept.
+// Javascript clients asking for JSON get the new attachment back so they
+// can insert its markdown reference, everyone else returns to the editor.
+func (joki *joki) uploadHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Uploading requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !joki.exists(title) {
+		http.Error(w, "Save the page before attaching files", http.StatusNotFound)
+		return
+	}
+	if r.FormValue("delete") == "" && !joki.features.enabled(uploadsFeature) {
+		http.Error(w, "The "+uploadsFeature+" feature is turned off", http.StatusForbidden)
+		return
+	}
+	if err := joki.holds.check(title); err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+	if err := joki.throttle(r, title); err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+
+	if name := r.FormValue("delete"); name != "" {
+		joki.deleteAttachment(w, r, title, name)
+		return
+	}
+	if name := r.FormValue("restore"); name != "" {
+		joki.restoreAttachment(w, r, title, name, r.FormValue("version"))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	name := filepath.Base(header.Filename)
+	if !validFileName.MatchString(name) {
+		http.Error(w, "File name is invalid: "+name, http.StatusBadRequest)
+		return
+	}
+	attachment, err := joki.attach(r, title, name, file, header.Size)
+	if err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Attachment
+			Markdown string `json:"markdown"`
+		}{attachment, attachment.Markdown()})
+		return
+	}
+	http.Redirect(w, r, EDIT_PATH+title, http.StatusFound)
+}
+
+// Stores file of size bytes as attachment name of page title, keeping the
+// version it replaces, and records the change
+func (joki *joki) attach(r *http.Request, title, name string, file io.ReadSeeker, size int64) (Attachment, error) {
+	user := joki.currentUser(r)
+	if err := joki.checkAttachmentQuota(title, name, user, size); err != nil {
+		return Attachment{}, err
+	}
+	dir := joki.attachmentPath(title)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Attachment{}, err
+	}
+	if err := joki.checkUpload(title, name, file); err != nil {
+		return Attachment{}, err
+	}
+	hash, size, err := joki.blobs.store(file)
+	if err != nil {
+		return Attachment{}, err
+	}
+	if err := joki.archiveAttachment(title, name); err != nil {
+		return Attachment{}, err
+	}
+	if err := joki.blobs.link(hash, filepath.Join(dir, name)); err != nil {
+		return Attachment{}, err
+	}
+	if err := joki.usage.add(user, size); err != nil {
+		log.Printf("Could not count %s for %s: %s", name, user, err)
+	}
+	err = joki.commitChange(r, "Attach "+name+" to "+title, []string{attachmentFile(title, name)}, nil)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("File attached but not committed: %s", err)
+	}
+	return newAttachment(title, name, size), nil
+}
+
+// Refuses to store size bytes as attachment name of page title for user if
+// the wiki, the page or what user stored would grow beyond their quotas
+func (joki *joki) checkAttachmentQuota(title, name, user string, size int64) error {
+	if err := joki.checkQuotaGrowth(size, name); err != nil {
+		return err
+	}
+	total := joki.pageSize(title) + joki.attachmentsSize(title) + size
+	if old, err := os.Stat(filepath.Join(joki.attachmentPath(title), name)); err == nil {
+		total -= old.Size() // replaced
+	}
+	if err := joki.checkPageQuota(title, total, name); err != nil {
+		return err
+	}
+	return joki.checkUserQuota(user, size, name)
+}
+
+// Removes an attachment, whose last version is kept to be restored
+func (joki *joki) deleteAttachment(w http.ResponseWriter, r *http.Request, title, name string) {
+	if !validFileName.MatchString(name) {
+		http.Error(w, "File name is invalid: "+name, http.StatusBadRequest)
+		return
+	}
+	if err := joki.archiveAttachment(title, name); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := joki.blobs.unlink(filepath.Join(joki.attachmentPath(title), name)); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	err := joki.commitChange(r, "Remove "+name+" from "+title, nil, []string{attachmentFile(title, name)})
+	if err != nil {
+		http.Error(w, "File removed but not committed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, EDIT_PATH+title, http.StatusFound)
+}
+
+// Serves /files/<title>/<name>, ?poster of a video or ?svg of a drawio
+// diagram
+func (joki *joki) filesHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, FILES_PATH)
+	i := strings.LastIndex(path, "/")
+	if i < 0 || !validTitle.MatchString(path[:i]) || !validFileName.MatchString(path[i+1:]) {
+		http.NotFound(w, r)
+		return
+	}
+	title, name := path[:i], path[i+1:]
+	if !joki.canRead(r, title) || joki.hiddenDraft(r, title) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Uploaded files must never run as part of the wiki. Images, audio and
+	// video are shown in the browser, with range requests to seek in them.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	if _, ok := r.URL.Query()["poster"]; ok {
+		joki.servePoster(w, r, title, name)
+		return
+	}
+	if _, ok := r.URL.Query()["svg"]; ok {
+		joki.serveDrawio(w, r, title, name)
+		return
+	}
+	if !newAttachment(title, name, 0).IsImage && mediaKind(name) == "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	}
+	http.ServeFile(w, r, filepath.Join(joki.attachmentPath(title), name))
+}
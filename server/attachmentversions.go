@@ -0,0 +1,187 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Prior versions of attachments are kept in this directory of the data path,
+// as <revision>.<name> in the directory of their page
+const attachmentVersionsDir = ".versions"
+
+// AttachmentVersion is a prior version of an attachment
+type AttachmentVersion struct {
+	ID   string
+	Time time.Time
+	Size int64
+}
+
+// AttachmentHistoryPage lists the prior versions of an attachment
+type AttachmentHistoryPage struct {
+	Title    string
+	Name     string
+	Current  *Attachment // nil if it was removed
+	Versions []AttachmentVersion
+	CanEdit  bool // the reader may restore versions
+}
+
+func (joki *joki) attachmentVersionsPath(title string) string {
+	return filepath.Join(joki.dataPath, attachmentVersionsDir, title)
+}
+
+func (joki *joki) attachmentVersionFile(title, name, id string) string {
+	return filepath.Join(joki.attachmentVersionsPath(title), id+"."+name)
+}
+
+// Keeps the current version of an attachment before it gets replaced or
+// removed. The version is named after the time it was last written.
+func (joki *joki) archiveAttachment(title, name string) error {
+	file := filepath.Join(joki.attachmentPath(title), name)
+	info, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return nil // nothing to keep for new attachments
+	} else if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(joki.attachmentVersionsPath(title), 0700); err != nil {
+		return err
+	}
+	version := joki.attachmentVersionFile(title, name, info.ModTime().UTC().Format(revisionTimeFormat))
+	if _, err := os.Stat(version); err == nil {
+		return nil // already kept
+	}
+	hash, err := joki.blobs.adopt(file)
+	if err != nil {
+		return err
+	}
+	return joki.blobs.link(hash, version)
+}
+
+// Lists the prior versions of an attachment, newest first
+func (joki *joki) attachmentVersions(title, name string) ([]AttachmentVersion, error) {
+	files, err := ioutil.ReadDir(joki.attachmentVersionsPath(title))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var versions []AttachmentVersion
+	for _, f := range files {
+		id := strings.TrimSuffix(f.Name(), "."+name)
+		if f.IsDir() || id == f.Name() || !validRevision.MatchString(id) {
+			continue // a nested page or another attachment
+		}
+		t, err := time.Parse(revisionTimeFormat, id)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, AttachmentVersion{ID: id, Time: t, Size: f.Size()})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Time.After(versions[j].Time) })
+	return versions, nil
+}
+
+// Moves the versions of attachments along with a renamed page
+func (joki *joki) moveAttachmentVersions(oldTitle, newTitle string) error {
+	return moveDir(joki.attachmentVersionsPath(oldTitle), joki.attachmentVersionsPath(newTitle))
+}
+
+// Serves /versions/<title>/<name>, the list of prior versions of an
+// attachment, or the version ?v= of it
+func (joki *joki) attachmentHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, VERSIONS_PATH)
+	i := strings.LastIndex(path, "/")
+	if i < 0 || !validTitle.MatchString(path[:i]) || !validFileName.MatchString(path[i+1:]) {
+		http.NotFound(w, r)
+		return
+	}
+	title, name := path[:i], path[i+1:]
+	if !joki.canRead(r, title) || joki.hiddenDraft(r, title) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if id := r.FormValue("v"); id != "" {
+		if !validRevision.MatchString(id) {
+			http.NotFound(w, r)
+			return
+		}
+		// Old versions must never run as part of the wiki either
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		if !newAttachment(title, name, 0).IsImage {
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		}
+		http.ServeFile(w, r, joki.attachmentVersionFile(title, name, id))
+		return
+	}
+
+	versions, err := joki.attachmentVersions(title, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page := &AttachmentHistoryPage{Title: title, Name: name, Versions: versions, CanEdit: joki.canEdit(r, title)}
+	if info, err := os.Stat(filepath.Join(joki.attachmentPath(title), name)); err == nil {
+		current := newAttachment(title, name, info.Size())
+		page.Current = &current
+	} else if len(versions) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	joki.renderTemplate(w, r, "versions", page)
+}
+
+// Makes the version id of an attachment its current one again, keeping the
+// one it replaces
+func (joki *joki) restoreAttachment(w http.ResponseWriter, r *http.Request, title, name, id string) {
+	if !validFileName.MatchString(name) || !validRevision.MatchString(id) {
+		http.Error(w, "No such version of "+name, http.StatusNotFound)
+		return
+	}
+	version := joki.attachmentVersionFile(title, name, id)
+	info, err := os.Stat(version)
+	if err != nil {
+		http.Error(w, "No such version of "+name, http.StatusNotFound)
+		return
+	}
+	user := joki.currentUser(r)
+	if err := joki.checkAttachmentQuota(title, name, user, info.Size()); err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+	if err := joki.archiveAttachment(title, name); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hash, err := joki.blobs.adopt(version)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := os.MkdirAll(joki.attachmentPath(title), 0700); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := joki.blobs.link(hash, filepath.Join(joki.attachmentPath(title), name)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := joki.usage.add(user, info.Size()); err != nil {
+		log.Printf("Could not count %s for %s: %s", name, user, err)
+	}
+	message := fmt.Sprintf("Restore %s of %s to %s", name, title, id)
+	if err := joki.commitChange(r, message, []string{attachmentFile(title, name)}, nil); err != nil {
+		http.Error(w, "File restored but not committed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, VERSIONS_PATH+title+"/"+name, http.StatusFound)
+}
@@ -0,0 +1,380 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookie   = "gowiki_session"
+	sessionLifetime = 7 * 24 * time.Hour
+
+	memorySessions = "memory"
+	diskSessions   = "disk"
+	sqliteSessions = "sqlite"
+	redisSessions  = "redis"
+	sessionsDir    = ".sessions"    // of diskSessions in the data path
+	sessionsDB     = ".sessions.db" // of sqliteSessions in the data path
+)
+
+// LoginPage is shown to log in or out
+type LoginPage struct {
+	User  string
+	Next  string
+	Error string
+}
+
+// sessionStore maps session tokens handed out as cookies to users. The
+// sessions expire in the cache, which may be shared by several replicas.
+type sessionStore struct {
+	cache Cache
+}
+
+func newSessionStore(cache Cache) *sessionStore {
+	return &sessionStore{cache: cache}
+}
+
+// Opens the cache keeping the sessions of kind. Sessions on disk or in
+// SQLite survive a restart, those in Redis are shared by all replicas. An
+// empty kind keeps them in Redis if there is a client, else in memory.
+func openSessionCache(kind, dataPath string, client *redis.Client, redisPrefix string) (Cache, error) {
+	if kind == "" {
+		kind = memorySessions
+		if client != nil {
+			kind = redisSessions
+		}
+	}
+	switch kind {
+	case memorySessions:
+		return newMemoryCache(0), nil
+	case diskSessions:
+		return openDiskCache(filepath.Join(dataPath, sessionsDir))
+	case sqliteSessions:
+		return openSQLiteCache(filepath.Join(dataPath, sessionsDB))
+	case redisSessions:
+		if client == nil {
+			return nil, fmt.Errorf("sessions in Redis need -redis")
+		}
+		return &redisCache{client: client, prefix: redisPrefix + "session:"}, nil
+	}
+	return nil, fmt.Errorf("unknown session store: %s", kind)
+}
+
+// Starts a new session for user and returns its token
+func (s *sessionStore) create(user string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	if err := s.cache.Set(token, []byte(user), sessionLifetime); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Returns the user of a session or "" if the session is unknown or expired
+func (s *sessionStore) user(token string) string {
+	user, _ := s.cache.Get(token)
+	return string(user)
+}
+
+func (s *sessionStore) remove(token string) {
+	if err := s.cache.Delete(token); err != nil {
+		log.Printf("Could not end session: %s", err)
+	}
+}
+
+// Loads users from a file with one "name:bcrypthash" per line.
+// Empty lines and lines starting with # are ignored.
+func loadUsers(fileName string, users map[string][]byte) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("%s:%d: expected name:hash", fileName, line)
+		}
+		users[parts[0]] = []byte(parts[1])
+	}
+	return scanner.Err()
+}
+
+// Adds a user given as "name:password" on the command line
+func addUser(credentials string, users map[string][]byte) error {
+	parts := strings.SplitN(credentials, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("user \"%s\" is invalid, expected name:password", parts[0])
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(parts[1]), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	users[parts[0]] = hash
+	return nil
+}
+
+// Adds a user to the -users file, for approved registrations and accepted
+// invitations. Admins are only added in memory, the caller keeps them.
+func (joki *joki) createUser(name string, hash []byte, admin bool) error {
+	joki.usersLock.Lock()
+	defer joki.usersLock.Unlock()
+	if _, taken := joki.users[name]; taken {
+		return fmt.Errorf("%s is taken", name)
+	}
+	if err := appendUser(joki.usersFile, name, hash); err != nil {
+		return err
+	}
+	joki.users[name] = hash
+	if admin {
+		joki.admins[name] = true
+	}
+	if err := joki.accounts.add(name); err != nil {
+		log.Printf("Could not record when %s joined: %s", name, err)
+	}
+	return nil
+}
+
+// Appends a user to the users file
+func appendUser(fileName, name string, hash []byte) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	line := name + ":" + string(hash) + "\n"
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		line = "\n" + line
+	}
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Returns whether user is one of the admins
+func (joki *joki) adminUser(user string) bool {
+	joki.usersLock.RLock()
+	defer joki.usersLock.RUnlock()
+	return joki.admins[user]
+}
+
+// Returns the password hash of a user
+func (joki *joki) userHash(name string) ([]byte, bool) {
+	joki.usersLock.RLock()
+	defer joki.usersLock.RUnlock()
+	hash, ok := joki.users[name]
+	return hash, ok
+}
+
+// Authentication is enabled as soon as any user is configured
+func (joki *joki) authEnabled() bool {
+	joki.usersLock.RLock()
+	defer joki.usersLock.RUnlock()
+	return len(joki.users) > 0
+}
+
+// userKey is the context key of the requestUser of a request
+type userKey struct{}
+
+// requestUser is the user of a request, looked up the first time it is
+// needed. Checking a basic auth password takes bcrypt's time on purpose, a
+// request pays it once.
+type requestUser struct {
+	once sync.Once
+	name string
+}
+
+// Wraps next so the user of a request is looked up at most once
+func rememberUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, &requestUser{})))
+	})
+}
+
+// Returns the logged in user of a request or "" for anonymous requests
+func (joki *joki) currentUser(r *http.Request) string {
+	u, ok := r.Context().Value(userKey{}).(*requestUser)
+	if !ok {
+		return joki.lookupUser(r)
+	}
+	u.once.Do(func() { u.name = joki.lookupUser(r) })
+	return u.name
+}
+
+// Returns the user a request logged in as. Besides the session cookie HTTP
+// basic auth is accepted for scripts.
+func (joki *joki) lookupUser(r *http.Request) string {
+	if name, password, ok := r.BasicAuth(); ok {
+		hash, known := joki.userHash(name)
+		if known && bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil {
+			return name
+		}
+		return ""
+	}
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+	return joki.sessions.user(cookie.Value)
+}
+
+// Wraps a handler so it is only reachable for logged in users
+func (joki *joki) requireLogin(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !joki.authEnabled() || joki.currentUser(r) != "" {
+			fn(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Login required", http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, LOGIN_PATH+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+	}
+}
+
+// Returns whether the user of a request may use the admin tools.
+// Without authentication everybody can change everything anyway.
+func (joki *joki) isAdmin(r *http.Request) bool {
+	return !joki.authEnabled() || joki.adminUser(joki.currentUser(r))
+}
+
+// Wraps a handler so it is only reachable for admins
+func (joki *joki) requireAdmin(fn http.HandlerFunc) http.HandlerFunc {
+	return joki.requireLogin(func(w http.ResponseWriter, r *http.Request) {
+		if !joki.isAdmin(r) {
+			http.Error(w, "Only admins may do this", http.StatusForbidden)
+			return
+		}
+		fn(w, r)
+	})
+}
+
+// Wraps read only handlers, which only need a login in a private wiki
+func (joki *joki) requireReader(fn http.HandlerFunc) http.HandlerFunc {
+	if !joki.private {
+		return fn
+	}
+	return joki.requireLogin(fn)
+}
+
+// Wraps the handlers showing the history, old versions and the markdown
+// source of pages, which -history-readers may restrict
+func (joki *joki) requireHistoryReader(fn http.HandlerFunc) http.HandlerFunc {
+	if len(joki.historyReaders) == 0 {
+		return joki.requireReader(fn)
+	}
+	return joki.requireLogin(func(w http.ResponseWriter, r *http.Request) {
+		if !joki.canSeeHistory(r) {
+			http.Error(w, "You may not see the history and source of pages", http.StatusForbidden)
+			return
+		}
+		fn(w, r)
+	})
+}
+
+// Wraps handlers that change the wiki, which a read only wiki refuses
+func (joki *joki) requireWritable(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if joki.readonly {
+			http.Error(w, "This wiki is read only", http.StatusForbidden)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// Shows the login form and logs users in
+func (joki *joki) loginHandler(w http.ResponseWriter, r *http.Request) {
+	page := &LoginPage{Next: r.FormValue("next"), User: joki.currentUser(r)}
+	// Only redirect within the wiki after logging in
+	if !localRedirect(page.Next) {
+		page.Next = "/"
+	}
+
+	if r.Method != http.MethodPost {
+		joki.renderTemplate(w, r, "login", page)
+		return
+	}
+
+	name := r.FormValue("name")
+	hash, ok := joki.userHash(name)
+	if !ok || bcrypt.CompareHashAndPassword(hash, []byte(r.FormValue("password"))) != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		page.Error = "Unknown user or wrong password"
+		joki.renderTemplate(w, r, "login", page)
+		return
+	}
+
+	if err := joki.startSession(w, r, name); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, page.Next, http.StatusFound)
+}
+
+// Returns whether next is a path of this site. Browsers read a backslash
+// like a slash, so /\host leads away as much as //host does.
+func localRedirect(next string) bool {
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return false
+	}
+	return strings.HasPrefix(next, "/") && !strings.HasPrefix(next, "//") && !strings.HasPrefix(next, "/\\")
+}
+
+// Logs user in with a new session cookie
+func (joki *joki) startSession(w http.ResponseWriter, r *http.Request, user string) error {
+	token, err := joki.sessions.create(user)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(sessionLifetime),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// Ends the session of the current user
+func (joki *joki) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		joki.sessions.remove(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestLogin(t *testing.T) {
+	tw := newTestWiki(t, nil)
+	tests := []struct {
+		name, password string
+		status         int
+		session        bool
+	}{
+		{"ann", testPassword, http.StatusFound, true},
+		{"ann", "wrong", http.StatusUnauthorized, false},
+		{"ann", "", http.StatusUnauthorized, false},
+		{"nobody", testPassword, http.StatusUnauthorized, false},
+		{"", "", http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		w := tw.request(http.MethodPost, LOGIN_PATH, "", url.Values{"name": {tt.name}, "password": {tt.password}})
+		if w.Code != tt.status {
+			t.Errorf("login of %q with %q: status %d, want %d", tt.name, tt.password, w.Code, tt.status)
+		}
+		var session *http.Cookie
+		for _, c := range w.Result().Cookies() {
+			if c.Name == sessionCookie {
+				session = c
+			}
+		}
+		if (session != nil) != tt.session {
+			t.Errorf("login of %q with %q: session cookie %v, want one: %v", tt.name, tt.password, session, tt.session)
+		}
+		if session != nil && (!session.HttpOnly || session.SameSite != http.SameSiteLaxMode) {
+			t.Errorf("session cookie %v should be HttpOnly and SameSite=Lax", session)
+		}
+	}
+}
+
+func TestLoginRedirect(t *testing.T) {
+	tw := newTestWiki(t, nil)
+	tests := []struct {
+		next, location string
+	}{
+		{"", "/"},
+		{"/edit/Home", "/edit/Home"},
+		{"/view/Home?x=1#top", "/view/Home?x=1#top"},
+		{"https://evil.example.com/", "/"},
+		{"//evil.example.com/", "/"},
+		{"evil.example.com", "/"},
+		{"javascript:alert(1)", "/"},
+		{"/\\evil.example.com", "/"},
+		{"/\\/evil.example.com", "/"},
+		{"/\t/evil.example.com", "/"},
+		{"/edit/Home\\Notes", "/edit/Home\\Notes"},
+	}
+	for _, tt := range tests {
+		form := url.Values{"name": {"ann"}, "password": {testPassword}, "next": {tt.next}}
+		w := tw.request(http.MethodPost, LOGIN_PATH, "", form)
+		if got := w.Header().Get("Location"); w.Code != http.StatusFound || got != tt.location {
+			t.Errorf("login with next %q: %d to %q, want %d to %q", tt.next, w.Code, got, http.StatusFound, tt.location)
+		}
+	}
+}
+
+func TestSessions(t *testing.T) {
+	tw := newTestWiki(t, nil)
+	tw.store("Home", "# Home")
+	w := tw.request(http.MethodPost, LOGIN_PATH, "", url.Values{"name": {"ann"}, "password": {testPassword}})
+	var session *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("no session cookie after logging in")
+	}
+
+	withCookie := func(method, target string, c *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		if c != nil {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		tw.handler.ServeHTTP(w, r)
+		return w
+	}
+	tests := []struct {
+		what   string
+		cookie *http.Cookie
+		status int
+	}{
+		{"session", session, http.StatusOK},
+		{"no session", nil, http.StatusFound},
+		{"made up session", &http.Cookie{Name: sessionCookie, Value: "0123456789abcdef"}, http.StatusFound},
+		{"empty session", &http.Cookie{Name: sessionCookie, Value: ""}, http.StatusFound},
+	}
+	for _, tt := range tests {
+		if w := withCookie(http.MethodGet, EDIT_PATH+"Home", tt.cookie); w.Code != tt.status {
+			t.Errorf("editing with %s: status %d, want %d", tt.what, w.Code, tt.status)
+		}
+	}
+
+	if w := withCookie(http.MethodGet, LOGOUT_PATH, session); w.Code != http.StatusFound {
+		t.Fatalf("logout: status %d", w.Code)
+	}
+	if w := withCookie(http.MethodGet, EDIT_PATH+"Home", session); w.Code != http.StatusFound {
+		t.Errorf("editing with the session after logging out: status %d, want %d", w.Code, http.StatusFound)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	tw := newTestWiki(t, nil)
+	tests := []struct {
+		name, password, user string
+	}{
+		{"ann", testPassword, "ann"},
+		{"ann", "wrong", ""},
+		{"nobody", testPassword, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(tt.name, tt.password)
+		if got := tw.joki.currentUser(r); got != tt.user {
+			t.Errorf("basic auth of %q with %q: user %q, want %q", tt.name, tt.password, got, tt.user)
+		}
+	}
+}
+
+func TestRequireLogin(t *testing.T) {
+	tw := newTestWiki(t, nil)
+	tw.store("Home", "# Home")
+	tests := []struct {
+		method, target, user string
+		status               int
+	}{
+		{http.MethodGet, EDIT_PATH + "Home", "", http.StatusFound},
+		{http.MethodPost, SAVE_PATH + "Home", "", http.StatusUnauthorized},
+		{http.MethodGet, EDIT_PATH + "Home", "ann", http.StatusOK},
+		{http.MethodGet, VIEW_PATH + "Home", "", http.StatusOK},
+		{http.MethodGet, USAGE_PATH, "ann", http.StatusForbidden},
+		{http.MethodGet, USAGE_PATH, "bob", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := tw.request(tt.method, tt.target, tt.user, nil); w.Code != tt.status {
+			t.Errorf("%s %s as %q: status %d, want %d", tt.method, tt.target, tt.user, w.Code, tt.status)
+		}
+	}
+	if w := tw.request(http.MethodGet, EDIT_PATH+"Home", "", nil); w.Header().Get("Location") != LOGIN_PATH+"?next=%2Fedit%2FHome" {
+		t.Errorf("anonymous edit sent to %q", w.Header().Get("Location"))
+	}
+}
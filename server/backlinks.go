@@ -0,0 +1,99 @@
+package server
+
+import (
+	"sort"
+	"sync"
+)
+
+// linkIndex records the [Links] of every page so the pages linking to a
+// title are known without reading all pages. Links are kept as written as
+// what they resolve to depends on which pages exist when they are looked up.
+type linkIndex struct {
+	sync.RWMutex
+	links   map[string][]string        // page -> links as written
+	targets map[string]map[string]bool // possible link target -> pages
+}
+
+func newLinkIndex() *linkIndex {
+	return &linkIndex{
+		links:   make(map[string][]string),
+		targets: make(map[string]map[string]bool),
+	}
+}
+
+// Returns the titles a link on page from may resolve to
+func linkCandidates(from, link string) []string {
+	if ns := namespace(from); ns != "" {
+		return []string{ns + "/" + link, link}
+	}
+	return []string{link}
+}
+
+func (idx *linkIndex) set(title string, links []string) {
+	idx.Lock()
+	defer idx.Unlock()
+	idx.removeLocked(title)
+	idx.links[title] = links
+	for _, link := range links {
+		for _, target := range linkCandidates(title, link) {
+			if idx.targets[target] == nil {
+				idx.targets[target] = make(map[string]bool)
+			}
+			idx.targets[target][title] = true
+		}
+	}
+}
+
+func (idx *linkIndex) remove(title string) {
+	idx.Lock()
+	defer idx.Unlock()
+	idx.removeLocked(title)
+}
+
+func (idx *linkIndex) removeLocked(title string) {
+	for _, link := range idx.links[title] {
+		for _, target := range linkCandidates(title, link) {
+			delete(idx.targets[target], title)
+			if len(idx.targets[target]) == 0 {
+				delete(idx.targets, target)
+			}
+		}
+	}
+	delete(idx.links, title)
+}
+
+// Returns the links on page title as written
+func (idx *linkIndex) get(title string) []string {
+	idx.RLock()
+	defer idx.RUnlock()
+	return idx.links[title]
+}
+
+// Returns the pages that may link to title together with their links
+func (idx *linkIndex) candidates(title string) map[string][]string {
+	idx.RLock()
+	defer idx.RUnlock()
+	sources := make(map[string][]string, len(idx.targets[title]))
+	for source := range idx.targets[title] {
+		sources[source] = idx.links[source]
+	}
+	return sources
+}
+
+// Lists the pages user may see linking to title, sorted by title
+func (joki *joki) backlinks(title, user string) []string {
+	var pages []string
+	for source, links := range joki.links.candidates(title) {
+		if !joki.visible(source, user) {
+			continue
+		}
+		for _, link := range links {
+			if source != title && joki.resolveLink(source, link) == title {
+				pages = append(pages, source)
+				break
+			}
+		}
+	}
+	sort.Strings(pages)
+	return pages
+}
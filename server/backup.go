@@ -0,0 +1,326 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Waits for running changes and holds back new ones while a backup is made,
+// so the backup is a consistent snapshot. Every change is a request with a
+// method other than GET or HEAD.
+func (joki *joki) pauseChanges(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			joki.changes.RLock()
+			defer joki.changes.RUnlock()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Calls fn for the directories and files of the data path dir that belong
+// in a backup, with their path relative to dir
+func walkBackup(dir string, fn func(rel string, info os.FileInfo) error) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil // symlinks and the like are no wiki content
+		}
+		if rel == sessionsDir {
+			return filepath.SkipDir // the logins belong to the running wiki, not its content
+		}
+		if rel == blobsDir {
+			return filepath.SkipDir // the attachments hold the same, the GC pass links them again
+		}
+		if rel == postersDir {
+			return filepath.SkipDir // made again when shown
+		}
+		if rel == chunkedUploadsDir {
+			return filepath.SkipDir // unfinished, they are sent again
+		}
+		if strings.HasPrefix(rel, sessionsDB) || rel == backupManifestName { // with its journal
+			return nil
+		}
+		return fn(rel, info)
+	})
+}
+
+// Writes the data path with pages, history, trash and attachments to w as
+// a gzipped tar, with its manifest last. Modification times are kept, the
+// history depends on them. An incremental backup has the manifest of its
+// parent as base and only holds the files that changed since.
+func writeBackup(w io.Writer, dir string, base *backupManifest, parent *backupParent) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifest := &backupManifest{Created: time.Now().UTC(), Parent: parent, Files: make(map[string]backupEntry)}
+	err := walkBackup(dir, func(rel string, info os.FileInfo) error {
+		name := filepath.ToSlash(rel)
+		if info.IsDir() {
+			manifest.Files[name] = backupEntry{ModTime: info.ModTime(), Dir: true}
+		} else if old, ok := base.unchanged(name, info); ok {
+			manifest.Files[name] = old
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		header.Format = tar.FormatPAX // keeps the nanoseconds of the times
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(filepath.Join(dir, rel))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err = io.Copy(tw, io.TeeReader(f, h)); err != nil {
+			return err
+		}
+		manifest.Files[name] = backupEntry{Size: info.Size(), ModTime: info.ModTime(), SHA256: hex.EncodeToString(h.Sum(nil))}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeManifest(tw, manifest); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Writes a backup of the data path to a temporary file in dir, while no
+// changes are made, incremental to base if given
+func (joki *joki) snapshot(dir string, base *backupManifest, parent *backupParent) (*os.File, error) {
+	f, err := ioutil.TempFile(dir, "gowiki-backup-*.tar.gz")
+	if err != nil {
+		return nil, err
+	}
+	joki.changes.Lock()
+	err = writeBackup(f, joki.dataPath, base, parent)
+	joki.changes.Unlock()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// Writes a backup of the data path to file, for -backup. With -incremental
+// it only holds what changed since the backup since.
+func (joki *joki) backupCommand(file, since string) error {
+	var base *backupManifest
+	var parent *backupParent
+	if since != "" {
+		var err error
+		if base, parent, err = incrementalBase(file, since); err != nil {
+			return err
+		}
+	}
+	f, err := joki.snapshot(filepath.Dir(file), base, parent)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), file); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if since != "" {
+		log.Printf("Backed up the changes of %s since %s to %s", joki.dataPath, since, file)
+		return nil
+	}
+	log.Printf("Backed up %s to %s", joki.dataPath, file)
+	return nil
+}
+
+// Downloads a backup of the data path. It is written to a temporary file
+// first, so a slow download does not hold back changes. Only GET is served:
+// pauseChanges holds other requests as changes, and the snapshot waiting for
+// all changes to finish would wait for itself.
+func (joki *joki) backupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Backups are downloaded with GET", http.StatusMethodNotAllowed)
+		return
+	}
+	f, err := joki.snapshot("", nil, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	name := "gowiki-" + time.Now().UTC().Format("20060102T150405Z") + ".tar.gz"
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	io.Copy(w, f)
+}
+
+// Unpacks a backup into dir. Without want everything is unpacked, with it
+// only the files it has, which must match their hashes. Nothing is written
+// without write, the backup is only read and checked. Returns the files
+// unpacked.
+func extractBackup(file, dir string, want map[string]backupEntry, write bool) (map[string]bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	tr := tar.NewReader(gz)
+	extracted := make(map[string]bool)
+	var dirs []*tar.Header
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		if !filepath.IsLocal(header.Name) {
+			return nil, fmt.Errorf("%s: invalid name \"%s\"", file, header.Name)
+		}
+		entry, wanted := want[header.Name]
+		if header.Name == backupManifestName || want != nil && (!wanted || entry.Dir) {
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if !write {
+				continue
+			}
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return nil, err
+			}
+			dirs = append(dirs, header)
+			continue
+		case tar.TypeReg:
+		default:
+			continue
+		}
+
+		h := sha256.New()
+		if !write {
+			if _, err := io.Copy(h, tr); err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err)
+			}
+		} else if err := writeExtracted(target, io.TeeReader(tr, h), header); err != nil {
+			return nil, err
+		}
+		if want != nil && hex.EncodeToString(h.Sum(nil)) != entry.SHA256 {
+			return nil, fmt.Errorf("%s: %s does not match its hash", file, header.Name)
+		}
+		extracted[header.Name] = true
+	}
+	// directories last, writing their files changed their times
+	for _, header := range dirs {
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+			return nil, err
+		}
+	}
+	return extracted, nil
+}
+
+// Writes a file unpacked from a backup, which must not exist yet
+func writeExtracted(target string, r io.Reader, header *tar.Header) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(target, header.ModTime, header.ModTime)
+}
+
+// Replaces the data path with a backup, for -restore. An incremental backup
+// is restored with the backups it builds on, which are found next to it by
+// its manifest, and every file is checked against its hash. The backup is
+// unpacked next to the data path first, the data path is only moved aside
+// once that worked. A dry run only checks the backup and tells what it
+// would change.
+func (joki *joki) restoreCommand(file string, dryRun bool) error {
+	chain, err := backupChain(file)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return joki.restoreDryRun(file, chain)
+	}
+	dataPath := filepath.Clean(joki.dataPath)
+	restoring := dataPath + ".restoring"
+	os.RemoveAll(restoring) // left over from a failed restore
+	if err := os.Mkdir(restoring, 0700); err != nil {
+		return err
+	}
+	if chain == nil {
+		_, err = extractBackup(file, restoring, nil, true)
+	} else {
+		_, err = restoreChain(chain, restoring, true)
+	}
+	if err != nil {
+		os.RemoveAll(restoring)
+		return err
+	}
+	if _, err := os.Stat(dataPath); err == nil {
+		old := dataPath + ".before-restore-" + time.Now().UTC().Format("20060102T150405Z")
+		if err := os.Rename(dataPath, old); err != nil {
+			return err
+		}
+		log.Printf("Moved the previous data to %s", old)
+	}
+	if err := os.Rename(restoring, dataPath); err != nil {
+		return err
+	}
+	log.Printf("Restored %s from %s", dataPath, file)
+	return nil
+}
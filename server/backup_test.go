@@ -0,0 +1,144 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Writes a backup with headers, files getting content of their name
+func writeTestBackup(t *testing.T, file string, headers ...tar.Header) {
+	t.Helper()
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, h := range headers {
+		h.Mode, h.ModTime = 0600, time.Now()
+		if h.Typeflag == tar.TypeReg {
+			h.Size = int64(len(h.Name))
+		}
+		if err := tw.WriteHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			tw.Write([]byte(h.Name))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractBackup(t *testing.T) {
+	file := func(name string) tar.Header { return tar.Header{Name: name, Typeflag: tar.TypeReg} }
+	dir := func(name string) tar.Header { return tar.Header{Name: name, Typeflag: tar.TypeDir} }
+	tests := []struct {
+		what    string
+		headers []tar.Header
+		files   []string // extracted
+		err     bool
+	}{
+		{"pages", []tar.Header{dir("Projects/"), file("Home.md"), file("Projects/GoWiki.md")}, []string{"Home.md", "Projects/GoWiki.md"}, false},
+		{"parent", []tar.Header{file("Home.md"), file("../evil.md")}, nil, true},
+		{"nested parent", []tar.Header{file("Projects/../../evil.md")}, nil, true},
+		{"absolute", []tar.Header{file("/tmp/evil.md")}, nil, true},
+		{"empty name", []tar.Header{file("")}, nil, true},
+		{"directory outside", []tar.Header{dir("../evil/")}, nil, true},
+		{"symlink", []tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}, file("Home.md")}, []string{"Home.md"}, false},
+		{"symlink then file", []tar.Header{{Name: "Projects", Typeflag: tar.TypeSymlink, Linkname: "/tmp"}, file("Projects/evil.md")}, []string{"Projects/evil.md"}, false},
+		{"hard link", []tar.Header{file("Home.md"), {Name: "passwd", Typeflag: tar.TypeLink, Linkname: "/etc/passwd"}}, []string{"Home.md"}, false},
+		{"twice", []tar.Header{file("Home.md"), file("Home.md")}, nil, true},
+	}
+	for _, tt := range tests {
+		tmp := t.TempDir()
+		backup := filepath.Join(tmp, "backup.tar.gz")
+		writeTestBackup(t, backup, tt.headers...)
+		out := filepath.Join(tmp, "out")
+		extracted, err := extractBackup(backup, out, nil, true)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: extracting succeeded", tt.what)
+			}
+			if _, err := os.Stat(filepath.Join(tmp, "evil.md")); err == nil {
+				t.Errorf("%s: a file was written outside", tt.what)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.what, err)
+			continue
+		}
+		if len(extracted) != len(tt.files) {
+			t.Errorf("%s: extracted %v, want %v", tt.what, extracted, tt.files)
+		}
+		for _, name := range tt.files {
+			target := filepath.Join(out, filepath.FromSlash(name))
+			if info, err := os.Lstat(target); err != nil || !info.Mode().IsRegular() {
+				t.Errorf("%s: %s is not a file in the restored data: %v", tt.what, name, err)
+			} else if data, _ := ioutil.ReadFile(target); string(data) != name {
+				t.Errorf("%s: %s holds %q", tt.what, name, data)
+			}
+		}
+		if _, err := os.Lstat(filepath.Join(out, "link")); err == nil {
+			t.Errorf("%s: a symlink was extracted", tt.what)
+		}
+	}
+}
+
+func TestExtractBackupHashes(t *testing.T) {
+	tmp := t.TempDir()
+	backup := filepath.Join(tmp, "backup.tar.gz")
+	writeTestBackup(t, backup, tar.Header{Name: "Home.md", Typeflag: tar.TypeReg}, tar.Header{Name: "Other.md", Typeflag: tar.TypeReg})
+	sum := func(content string) string {
+		f := filepath.Join(tmp, "sum")
+		if err := ioutil.WriteFile(f, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		s, err := fileSHA256(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		what string
+		want map[string]backupEntry
+		err  bool
+	}{
+		{"matching", map[string]backupEntry{"Home.md": {SHA256: sum("Home.md")}}, false},
+		{"changed", map[string]backupEntry{"Home.md": {SHA256: sum("something else")}}, true},
+	}
+	for _, tt := range tests {
+		for _, write := range []bool{false, true} {
+			out := filepath.Join(t.TempDir(), "out")
+			extracted, err := extractBackup(backup, out, tt.want, write)
+			if tt.err {
+				if err == nil {
+					t.Errorf("%s, write %v: extracting succeeded", tt.what, write)
+				}
+				continue
+			}
+			if err != nil || len(extracted) != 1 || !extracted["Home.md"] {
+				t.Errorf("%s, write %v: extracted %v, %v, want only Home.md", tt.what, write, extracted, err)
+			}
+			if _, err := os.Stat(filepath.Join(out, "Home.md")); (err == nil) != write {
+				t.Errorf("%s, write %v: Home.md written: %v", tt.what, write, err == nil)
+			}
+			if _, err := os.Stat(filepath.Join(out, "Other.md")); err == nil {
+				t.Errorf("%s, write %v: Other.md was not wanted", tt.what, write)
+			}
+		}
+	}
+}
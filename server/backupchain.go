@@ -0,0 +1,319 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The manifest is the last entry of every backup
+const backupManifestName = ".backup-manifest.json"
+
+// Incremental backups building on each other at most, to catch loops
+const maxBackupChain = 1000
+
+// backupEntry is a file or directory of the data path in a backup manifest
+type backupEntry struct {
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"modtime"`
+	SHA256  string    `json:"sha256,omitempty"`
+	Dir     bool      `json:"dir,omitempty"`
+	Backup  string    `json:"backup,omitempty"` // the earlier backup holding the file, this one if empty
+}
+
+// backupParent is the backup an incremental backup builds on, next to it
+type backupParent struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"` // of the whole file, it must not change
+}
+
+// backupManifest lists everything in the data path when a backup was made,
+// with the hashes of the files, and the backup holding the ones that did
+// not change since the parent
+type backupManifest struct {
+	Created time.Time              `json:"created"`
+	Parent  *backupParent          `json:"parent,omitempty"`
+	Files   map[string]backupEntry `json:"files"`
+
+	name string // of the backup file
+}
+
+// Returns the entry of file name if it did not change since the backup of m
+func (m *backupManifest) unchanged(name string, info os.FileInfo) (backupEntry, bool) {
+	if m == nil {
+		return backupEntry{}, false
+	}
+	old, ok := m.Files[name]
+	if !ok || old.Dir || old.Size != info.Size() || !old.ModTime.Equal(info.ModTime()) {
+		return backupEntry{}, false
+	}
+	if old.Backup == "" {
+		old.Backup = m.name
+	}
+	return old, true
+}
+
+func writeManifest(tw *tar.Writer, m *backupManifest) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: backupManifestName, Mode: 0600, Size: int64(len(data)), ModTime: m.Created, Format: tar.FormatPAX}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// Reads the manifest of a backup, nil for backups made before there were
+// manifests
+func readManifest(file string) (*backupManifest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		if header.Name != backupManifestName {
+			continue
+		}
+		var m backupManifest
+		if err := json.NewDecoder(tr).Decode(&m); err != nil {
+			return nil, fmt.Errorf("%s: reading the manifest: %s", file, err)
+		}
+		m.name = filepath.Base(file)
+		return &m, nil
+	}
+}
+
+func fileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Returns the manifest an incremental backup to file builds on, the one of
+// the backup since next to it
+func incrementalBase(file, since string) (*backupManifest, *backupParent, error) {
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return nil, nil, err
+	}
+	sinceDir, err := filepath.Abs(filepath.Dir(since))
+	if err != nil {
+		return nil, nil, err
+	}
+	if dir != sinceDir {
+		return nil, nil, fmt.Errorf("an incremental backup must be next to %s", since)
+	}
+	if filepath.Base(file) == filepath.Base(since) {
+		return nil, nil, fmt.Errorf("an incremental backup cannot replace %s", since)
+	}
+	base, err := readManifest(since)
+	if err != nil {
+		return nil, nil, err
+	} else if base == nil {
+		return nil, nil, fmt.Errorf("%s has no manifest, make a full backup first", since)
+	}
+	sum, err := fileSHA256(since)
+	if err != nil {
+		return nil, nil, err
+	}
+	return base, &backupParent{Name: base.name, SHA256: sum}, nil
+}
+
+// backupLink is a backup of a chain of incremental backups
+type backupLink struct {
+	file     string
+	manifest *backupManifest
+}
+
+// Returns the backup file and the backups it builds on, newest first, after
+// checking that none of them changed since the next one was made. Returns
+// nil for a backup without a manifest.
+func backupChain(file string) ([]backupLink, error) {
+	var chain []backupLink
+	for current := file; ; {
+		m, err := readManifest(current)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			if current == file {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("%s has no manifest", current)
+		}
+		chain = append(chain, backupLink{file: current, manifest: m})
+		if m.Parent == nil {
+			return chain, nil
+		}
+		if len(chain) >= maxBackupChain {
+			return nil, fmt.Errorf("%s builds on more than %d backups", file, maxBackupChain)
+		}
+		parent := filepath.Join(filepath.Dir(file), filepath.Base(m.Parent.Name))
+		sum, err := fileSHA256(parent)
+		if err != nil {
+			return nil, fmt.Errorf("%s builds on %s: %s", current, m.Parent.Name, err)
+		}
+		if sum != m.Parent.SHA256 {
+			return nil, fmt.Errorf("%s changed since %s was made from it", parent, current)
+		}
+		current = parent
+	}
+}
+
+// Unpacks the data path as the newest backup of chain saw it into dir, or
+// only checks it without write. Returns the number of files.
+func restoreChain(chain []backupLink, dir string, write bool) (int, error) {
+	newest := chain[0].manifest
+	byBackup := make(map[string]map[string]backupEntry)
+	files := 0
+	for name, entry := range newest.Files {
+		if !filepath.IsLocal(name) {
+			return 0, fmt.Errorf("%s: invalid name \"%s\"", chain[0].file, name)
+		}
+		if entry.Dir {
+			continue
+		}
+		backup := entry.Backup
+		if backup == "" {
+			backup = newest.name
+		}
+		if byBackup[backup] == nil {
+			byBackup[backup] = make(map[string]backupEntry)
+		}
+		byBackup[backup][name] = entry
+		files++
+	}
+	for _, link := range chain {
+		want := byBackup[link.manifest.name]
+		if len(want) == 0 {
+			continue
+		}
+		extracted, err := extractBackup(link.file, dir, want, write)
+		if err != nil {
+			return 0, err
+		}
+		for name := range want {
+			if !extracted[name] {
+				return 0, fmt.Errorf("%s lacks %s", link.file, name)
+			}
+		}
+		delete(byBackup, link.manifest.name)
+	}
+	for backup := range byBackup {
+		return 0, fmt.Errorf("%s needs %s, which it does not build on", chain[0].file, backup)
+	}
+	if !write {
+		return files, nil
+	}
+
+	var dirs []string
+	for name, entry := range newest.Files {
+		if entry.Dir {
+			dirs = append(dirs, name)
+		}
+	}
+	for _, name := range dirs {
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(target, 0700); err != nil {
+			return 0, err
+		}
+	}
+	// times last, making the directories in them changed theirs
+	for _, name := range dirs {
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.Chtimes(target, newest.Files[name].ModTime, newest.Files[name].ModTime); err != nil {
+			return 0, err
+		}
+	}
+	return files, nil
+}
+
+// Checks a backup and logs what restoring it would change in the data path
+func (joki *joki) restoreDryRun(file string, chain []backupLink) error {
+	if chain == nil {
+		extracted, err := extractBackup(file, "", nil, false)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s can be read and would restore %d files; it has no manifest to check them against", file, len(extracted))
+		return nil
+	}
+	files, err := restoreChain(chain, "", false)
+	if err != nil {
+		return err
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		log.Printf("Checked %s of %s", chain[i].file, chain[i].manifest.Created.Format(time.RFC3339))
+	}
+
+	wanted := chain[0].manifest.Files
+	seen := make(map[string]bool)
+	added, changed, removed := 0, 0, 0
+	err = walkBackup(joki.dataPath, func(rel string, info os.FileInfo) error {
+		name := filepath.ToSlash(rel)
+		seen[name] = true
+		entry, ok := wanted[name]
+		switch {
+		case info.IsDir():
+			if !ok {
+				log.Printf("Would remove %s/", name)
+				removed++
+			}
+		case !ok || entry.Dir:
+			log.Printf("Would remove %s", name)
+			removed++
+		case entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()):
+			sum, err := fileSHA256(filepath.Join(joki.dataPath, rel))
+			if err != nil {
+				return err
+			}
+			if sum != entry.SHA256 {
+				log.Printf("Would change %s", name)
+				changed++
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for name, entry := range wanted {
+		if !seen[name] && !entry.Dir {
+			log.Printf("Would add %s", name)
+			added++
+		}
+	}
+	log.Printf("Restoring %s would add %d, change %d and remove %d of %d files in %s; nothing was changed",
+		file, added, changed, removed, files, joki.dataPath)
+	return nil
+}
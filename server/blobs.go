@@ -0,0 +1,231 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// The content of attachments and their versions is kept once in this
+// directory of the data path, named by its SHA-256
+const blobsDir = ".blobs"
+
+var validBlob = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// blobStore deduplicates attachments. Every attachment and version is a hard
+// link to the blob of its content, so the same file attached to many pages
+// or uploaded again takes its space once. A blob is removed when the last
+// link to it goes, the GC pass recounts the links and removes the blobs
+// nothing links to anymore.
+type blobStore struct {
+	sync.Mutex
+	dir   string
+	refs  map[string]int   // hash -> attachments and versions linking to it
+	sizes map[string]int64 // hash -> size, to find the blob of a file
+}
+
+func newBlobStore(dataPath string) *blobStore {
+	return &blobStore{dir: filepath.Join(dataPath, blobsDir), refs: make(map[string]int), sizes: make(map[string]int64)}
+}
+
+func (b *blobStore) path(hash string) string {
+	return filepath.Join(b.dir, hash)
+}
+
+// Stores the content read from r as a blob, which is only written once, and
+// returns its hash
+func (b *blobStore) store(r io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(b.dir, 0700); err != nil {
+		return "", 0, err
+	}
+	tmp, err := ioutil.TempFile(b.dir, "upload-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name()) // fails once it became the blob
+	h := sha256.New()
+	size, err := io.Copy(tmp, io.TeeReader(r, h))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	b.Lock()
+	defer b.Unlock()
+	if _, err := os.Stat(b.path(hash)); err == nil {
+		return hash, size, nil // known content
+	}
+	if err := os.Rename(tmp.Name(), b.path(hash)); err != nil {
+		return "", 0, err
+	}
+	b.sizes[hash] = size
+	return hash, size, nil
+}
+
+// Returns the hash of the blob file is a link to, or ""
+func (b *blobStore) blobOf(file string) string {
+	info, err := os.Stat(file)
+	if err != nil {
+		return ""
+	}
+	for hash, size := range b.sizes {
+		if size != info.Size() {
+			continue
+		}
+		if blob, err := os.Stat(b.path(hash)); err == nil && os.SameFile(info, blob) {
+			return hash
+		}
+	}
+	return ""
+}
+
+// Makes target a link to the blob hash, in place of what it was before. File
+// systems without hard links get a copy.
+func (b *blobStore) link(hash, target string) error {
+	b.Lock()
+	defer b.Unlock()
+	if b.blobOf(target) == hash {
+		return nil // same content
+	}
+	if err := b.unlinkLocked(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(b.path(hash), target); err != nil {
+		if err := copyFile(b.path(hash), target, 0600); err != nil {
+			return err
+		}
+		return nil // the copy does not count
+	}
+	b.refs[hash]++
+	return nil
+}
+
+// Removes file, and the blob it links to if it was the last link
+func (b *blobStore) unlink(file string) error {
+	b.Lock()
+	defer b.Unlock()
+	return b.unlinkLocked(file)
+}
+
+func (b *blobStore) unlinkLocked(file string) error {
+	hash := b.blobOf(file)
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	if hash == "" {
+		return nil
+	}
+	if b.refs[hash]--; b.refs[hash] <= 0 {
+		delete(b.refs, hash)
+		delete(b.sizes, hash)
+		return os.Remove(b.path(hash))
+	}
+	return nil
+}
+
+// Returns the blob of file, which is stored and linked first if it is a
+// file of its own, like one imported or restored from a backup
+func (b *blobStore) adopt(file string) (string, error) {
+	b.Lock()
+	hash := b.blobOf(file)
+	b.Unlock()
+	if hash != "" {
+		return hash, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	hash, _, err = b.store(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	if err := b.link(hash, file); err != nil {
+		return "", err
+	}
+	return hash, os.Chtimes(file, info.ModTime(), info.ModTime()) // versions are named by it
+}
+
+// The GC pass: links the files in dirs to their blobs, recounts the links
+// and removes the blobs nothing links to. Returns the number of blobs
+// removed.
+func (b *blobStore) collect(dirs ...string) (int, error) {
+	files, err := ioutil.ReadDir(b.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	b.Lock()
+	for _, f := range files {
+		if validBlob.MatchString(f.Name()) {
+			b.sizes[f.Name()] = f.Size()
+		}
+	}
+	b.Unlock()
+
+	refs := make(map[string]int)
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+			if os.IsNotExist(err) && path == dir {
+				return nil
+			} else if err != nil || f.IsDir() || !f.Mode().IsRegular() {
+				return err
+			}
+			hash, err := b.adopt(path)
+			if err != nil {
+				return err
+			}
+			refs[hash]++
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	files, err = ioutil.ReadDir(b.dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, f := range files {
+		hash := f.Name()
+		if validBlob.MatchString(hash) && refs[hash] > 0 {
+			continue
+		}
+		if err := os.Remove(b.path(hash)); err != nil {
+			return removed, err
+		}
+		delete(b.sizes, hash)
+		removed++
+	}
+	b.refs = refs
+	return removed, nil
+}
+
+// Runs the GC pass over the attachments and their versions
+func (joki *joki) collectBlobs() {
+	removed, err := joki.blobs.collect(filepath.Join(joki.dataPath, attachmentsDir), filepath.Join(joki.dataPath, attachmentVersionsDir))
+	if err != nil {
+		log.Printf("Error collecting unused attachment content: %s", err)
+	} else if removed > 0 {
+		log.Printf("Removed the content of %d attachments nothing refers to", removed)
+	}
+}
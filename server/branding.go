@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Themes of -theme, anything else is the URL of a stylesheet. Auto follows
+// the color scheme the browser prefers.
+const (
+	autoTheme  = "auto"
+	lightTheme = "light"
+	darkTheme  = "dark"
+	darkCSS    = "/static/css/dark.css"
+)
+
+// themeCookie keeps the theme a reader picked in place of -theme
+const themeCookie = "theme"
+
+var themes = []string{autoTheme, lightTheme, darkTheme}
+
+// Branding is how the wiki presents itself in every page
+type Branding struct {
+	Name       string
+	Logo       string // URL of an image replacing the icon next to the name
+	Favicon    string
+	Stylesheet string // of the theme, loaded after the others, empty for light
+	Theme      string // auto, light or dark, empty for a stylesheet of -theme
+}
+
+// Makes the branding of the settings c
+func newBranding(c *Config) (Branding, error) {
+	b := Branding{Name: c.WikiName, Logo: c.Logo, Favicon: c.Favicon}
+	switch theme := c.Theme; {
+	case theme == "":
+		b = b.withTheme(autoTheme)
+	case theme == autoTheme || theme == lightTheme || theme == darkTheme:
+		b = b.withTheme(theme)
+	case strings.HasPrefix(theme, "/") || strings.HasPrefix(theme, "https://") || strings.HasPrefix(theme, "http://"):
+		b.Stylesheet = theme
+	default:
+		return b, fmt.Errorf("theme \"%s\" is neither %s nor the URL of a stylesheet", theme, strings.Join(themes, ", "))
+	}
+	return b, nil
+}
+
+// Returns b with the built-in theme
+func (b Branding) withTheme(theme string) Branding {
+	b.Theme = theme
+	b.Stylesheet = ""
+	if theme == darkTheme {
+		b.Stylesheet = darkCSS
+	}
+	return b
+}
+
+// Returns the templates of the theme the reader picked, or of -theme
+func (joki *joki) themedTemplates(r *http.Request) map[string]*template.Template {
+	if c, err := r.Cookie(themeCookie); err == nil {
+		if templates, ok := joki.themeTemplates[c.Value]; ok {
+			return templates
+		}
+	}
+	return joki.templates
+}
+
+// Keeps the theme a reader picked in a cookie and goes back to the page
+func (joki *joki) themeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	theme := r.FormValue("theme")
+	if _, ok := joki.themeTemplates[theme]; !ok {
+		http.Error(w, "Unknown theme "+theme, http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     themeCookie,
+		Value:    theme,
+		Path:     "/",
+		Expires:  time.Now().Add(365 * 24 * time.Hour),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	next := "/"
+	if u, err := url.Parse(r.Referer()); err == nil && u.Host == r.Host && strings.HasPrefix(u.Path, "/") {
+		next = u.RequestURI()
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// overlayDir serves the files of dir in place of those with the same name
+// in base, so admins can change the assets without touching the originals
+type overlayDir struct {
+	dir  http.Dir
+	base http.FileSystem
+}
+
+func (o overlayDir) Open(name string) (http.File, error) {
+	f, err := o.dir.Open(name)
+	if err == nil {
+		return f, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return o.base.Open(name)
+}
+
+// Returns the file system of the static assets with the overrides of
+// -static-dir
+func (joki *joki) staticFiles() http.FileSystem {
+	if joki.staticDir == "" {
+		return staticAssets()
+	}
+	return overlayDir{dir: http.Dir(joki.staticDir), base: staticAssets()}
+}
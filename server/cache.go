@@ -0,0 +1,361 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	_ "modernc.org/sqlite"
+)
+
+// Cache keeps values for a while, in memory, on disk or in Redis so all
+// replicas of a wiki share them. A failing disk or Redis is logged and reads
+// as a miss.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	// Set keeps value for ttl, a ttl of 0 keeps it until it is evicted
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+	// Clear deletes all keys starting with prefix
+	Clear(prefix string) error
+}
+
+// memoryCache keeps the most recently used values in memory
+type memoryCache struct {
+	sync.Mutex
+	size    int        // most values kept, 0 for no limit
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time // zero if the value does not expire
+}
+
+func newMemoryCache(size int) *memoryCache {
+	return &memoryCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *memoryCache) Get(key string) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.order.Remove(e)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return entry.value, true
+}
+
+func (c *memoryCache) Set(key string, value []byte, ttl time.Duration) error {
+	c.Lock()
+	defer c.Unlock()
+	entry := &cacheEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.size > 0 && c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+	return nil
+}
+
+func (c *memoryCache) Delete(key string) error {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.order.Remove(e)
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+func (c *memoryCache) Clear(prefix string) error {
+	c.Lock()
+	defer c.Unlock()
+	for key, e := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.order.Remove(e)
+			delete(c.entries, key)
+		}
+	}
+	return nil
+}
+
+// redisCache keeps values in Redis below a prefix, so several wikis can
+// share one Redis
+type redisCache struct {
+	client *redis.Client
+	prefix string
+}
+
+// Connects to the Redis at rawURL, redis://:password@host:6379/0
+func openRedis(rawURL string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (c *redisCache) Get(key string) ([]byte, bool) {
+	value, err := c.client.Get(context.Background(), c.prefix+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Could not read %s from Redis: %s", c.prefix+key, err)
+		}
+		return nil, false
+	}
+	return value, true
+}
+
+func (c *redisCache) Set(key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(context.Background(), c.prefix+key, value, ttl).Err()
+}
+
+func (c *redisCache) Delete(key string) error {
+	return c.client.Del(context.Background(), c.prefix+key).Err()
+}
+
+// Clears with SCAN instead of KEYS, which would block Redis for a while
+func (c *redisCache) Clear(prefix string) error {
+	ctx := context.Background()
+	var keys []string
+	it := c.client.Scan(ctx, 0, redisPattern(c.prefix+prefix)+"*", 100).Iterator()
+	for it.Next(ctx) {
+		keys = append(keys, it.Val())
+	}
+	if err := it.Err(); err != nil || len(keys) == 0 {
+		return err
+	}
+	return c.client.Unlink(ctx, keys...).Err()
+}
+
+// Escapes the glob characters of a key for SCAN MATCH
+func redisPattern(key string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`).Replace(key)
+}
+
+// diskCache keeps every value in a file of its own in dir. The files are
+// named by the hash of their key, which need not be a valid file name and,
+// for sessions, should not show up in a directory listing.
+type diskCache struct {
+	dir string
+}
+
+// Opens the cache in dir and removes the values that expired while nobody
+// read them
+func openDiskCache(dir string) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	c := &diskCache{dir: dir}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		file := filepath.Join(dir, f.Name())
+		if _, _, expires, err := readCacheFile(file); err != nil || expired(expires) {
+			os.Remove(file)
+		}
+	}
+	return c, nil
+}
+
+func (c *diskCache) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// A cache file holds the key, the expiry in unix nanoseconds, 0 for none,
+// each on a line of its own, and then the value
+func readCacheFile(file string) (key string, value []byte, expires int64, err error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	r := bufio.NewReader(bytes.NewReader(b))
+	if key, err = r.ReadString('\n'); err != nil {
+		return "", nil, 0, fmt.Errorf("%s is no cache file", file)
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("%s is no cache file", file)
+	}
+	if expires, err = strconv.ParseInt(strings.TrimSuffix(line, "\n"), 10, 64); err != nil {
+		return "", nil, 0, fmt.Errorf("%s is no cache file", file)
+	}
+	return strings.TrimSuffix(key, "\n"), b[len(key)+len(line):], expires, nil
+}
+
+func expired(expires int64) bool {
+	return expires != 0 && time.Now().UnixNano() > expires
+}
+
+func (c *diskCache) Get(key string) ([]byte, bool) {
+	file := c.file(key)
+	stored, value, expires, err := readCacheFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Could not read %s from the cache: %s", key, err)
+		}
+		return nil, false
+	}
+	if stored != key || expired(expires) {
+		os.Remove(file)
+		return nil, false
+	}
+	return value, true
+}
+
+// Writes to a temporary file first, a reader never sees half a value
+func (c *diskCache) Set(key string, value []byte, ttl time.Duration) error {
+	if strings.Contains(key, "\n") {
+		return fmt.Errorf("cache key %q contains a newline", key)
+	}
+	var expires int64
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).UnixNano()
+	}
+	f, err := ioutil.TempFile(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = fmt.Fprintf(f, "%s\n%d\n%s", key, expires, value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.file(key))
+}
+
+func (c *diskCache) Delete(key string) error {
+	if err := os.Remove(c.file(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *diskCache) Clear(prefix string) error {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		file := filepath.Join(c.dir, f.Name())
+		if key, _, _, err := readCacheFile(file); err == nil && strings.HasPrefix(key, prefix) {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sqliteCache keeps values in a table of a SQLite database, which several
+// processes on one machine can share
+type sqliteCache struct {
+	db *sql.DB
+}
+
+// Opens the database in file, creating it if needed, and removes the
+// expired values
+func openSQLiteCache(file string) (*sqliteCache, error) {
+	db, err := sql.Open("sqlite", file)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range []string{
+		"PRAGMA busy_timeout = 5000",
+		"CREATE TABLE IF NOT EXISTS cache (key TEXT PRIMARY KEY, value BLOB NOT NULL, expires INTEGER NOT NULL)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if _, err := db.Exec("DELETE FROM cache WHERE expires != 0 AND expires < ?", time.Now().UnixNano()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteCache{db: db}, nil
+}
+
+func (c *sqliteCache) Get(key string) ([]byte, bool) {
+	var value []byte
+	var expires int64
+	err := c.db.QueryRow("SELECT value, expires FROM cache WHERE key = ?", key).Scan(&value, &expires)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Could not read %s from SQLite: %s", key, err)
+		}
+		return nil, false
+	}
+	if expired(expires) {
+		c.Delete(key)
+		return nil, false
+	}
+	return value, true
+}
+
+func (c *sqliteCache) Set(key string, value []byte, ttl time.Duration) error {
+	var expires int64
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).UnixNano()
+	}
+	_, err := c.db.Exec(`INSERT INTO cache (key, value, expires) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires = excluded.expires`, key, value, expires)
+	return err
+}
+
+func (c *sqliteCache) Delete(key string) error {
+	_, err := c.db.Exec("DELETE FROM cache WHERE key = ?", key)
+	return err
+}
+
+// Compares with instr, LIKE would need the wildcards in prefix escaped
+func (c *sqliteCache) Clear(prefix string) error {
+	_, err := c.db.Exec("DELETE FROM cache WHERE instr(key, ?) = 1", prefix)
+	return err
+}
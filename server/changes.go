@@ -0,0 +1,233 @@
+package server
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	changesDefaultLimit = 50
+	changesMaxLimit     = 500
+)
+
+// Change is the latest modification of a page
+type Change struct {
+	Title    string
+	Modified time.Time
+	Size     int64
+	Delta    int64  // size difference to the previous revision
+	Previous string // id of the previous revision
+	Created  bool
+}
+
+// ChangesPage lists the recent changes, of a namespace or tag if given
+type ChangesPage struct {
+	Namespace string
+	Tag       string
+	Feed      string // of the listed changes
+	Changes   []Change
+}
+
+// DeltaString formats the size difference like +12 or -3
+func (c Change) DeltaString() string {
+	if c.Delta > 0 {
+		return "+" + strconv.FormatInt(c.Delta, 10)
+	}
+	return strconv.FormatInt(c.Delta, 10)
+}
+
+// Lists the most recently modified pages the request may see, newest first.
+// keep picks the pages to list, nil lists all.
+func (joki *joki) recentChanges(r *http.Request, limit int, keep func(title string) bool) ([]Change, error) {
+	titles, err := joki.listPages()
+	if err != nil {
+		return nil, err
+	}
+	titles = joki.visiblePages(r, titles)
+	changes := make([]Change, 0, len(titles))
+	for _, title := range titles {
+		if keep != nil && !keep(title) {
+			continue
+		}
+		modTime, err := joki.modTime(title)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, Change{Title: title, Modified: modTime})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Modified.After(changes[j].Modified) })
+	if len(changes) > limit {
+		changes = changes[:limit]
+	}
+
+	// Only the shown pages need their history for the delta
+	for i := range changes {
+		c := &changes[i]
+		c.Size = joki.pageSize(c.Title)
+		revs, err := joki.revisions(c.Title)
+		if err != nil {
+			return nil, err
+		}
+		if len(revs) == 0 {
+			c.Created, c.Delta = true, c.Size
+		} else {
+			c.Delta, c.Previous = c.Size-revs[0].Size, revs[0].ID
+		}
+	}
+	return changes, nil
+}
+
+// Returns the ?limit= of a request or the default
+func changesLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || limit <= 0 {
+		return changesDefaultLimit
+	}
+	if limit > changesMaxLimit {
+		return changesMaxLimit
+	}
+	return limit
+}
+
+// Returns the namespace of /changes/Projects/GoWiki below path and the
+// ?tag= the changes are filtered by, and the filter for recentChanges. The
+// page naming the namespace counts to it.
+func (joki *joki) changesFilter(r *http.Request, path string) (ns, tag string, keep func(title string) bool) {
+	ns = strings.Trim(strings.TrimPrefix(r.URL.Path, path), "/")
+	tag = strings.ToLower(r.FormValue("tag"))
+	if ns == "" && tag == "" {
+		return "", "", nil
+	}
+	return ns, tag, func(title string) bool {
+		return (ns == "" || title == ns || strings.HasPrefix(title, ns+"/")) && (tag == "" || joki.meta.tagged(title, tag))
+	}
+}
+
+// Returns the path below path and the query of the changes filtered by ns
+// and tag
+func changesPath(path, ns, tag string) string {
+	if ns != "" {
+		path += "/" + ns
+	}
+	if tag != "" {
+		path += "?tag=" + url.QueryEscape(tag)
+	}
+	return path
+}
+
+func (joki *joki) changesHandler(w http.ResponseWriter, r *http.Request) {
+	ns, tag, keep := joki.changesFilter(r, CHANGES_PATH)
+	changes, err := joki.recentChanges(r, changesLimit(r), keep)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	joki.renderTemplate(w, r, "changes", &ChangesPage{Namespace: ns, Tag: tag, Feed: changesPath(CHANGES_FEED_PATH, ns, tag), Changes: changes})
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// Returns the scheme, host and prefix the wiki was requested with
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + wikiPrefix(r)
+}
+
+// Returns the URL of the wiki for links leaving it, like those in mails:
+// -public-url, or the one of the request if it is unset. The Host header is
+// up to the client, so anyone could make mails link elsewhere with it.
+func (joki *joki) siteURL(r *http.Request) string {
+	if joki.publicURL != "" {
+		return joki.publicURL
+	}
+	return baseURL(r)
+}
+
+// Serves the recent changes as an Atom feed, filtered like /changes
+func (joki *joki) changesFeedHandler(w http.ResponseWriter, r *http.Request) {
+	ns, tag, keep := joki.changesFilter(r, CHANGES_FEED_PATH)
+	changes, err := joki.recentChanges(r, changesLimit(r), keep)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	title := "recent changes"
+	if ns != "" {
+		title += " in " + ns
+	}
+	if tag != "" {
+		title += " tagged " + tag
+	}
+	joki.writeFeed(w, r, title, changesPath(CHANGES_FEED_PATH, ns, tag), changesPath(CHANGES_PATH, ns, tag), changes)
+}
+
+// Writes changes as an Atom feed titled title, which is found at self and
+// shown as html at page. Both are paths with their query.
+func (joki *joki) writeFeed(w http.ResponseWriter, r *http.Request, title, self, page string, changes []Change) {
+	base := baseURL(r)
+	feed := atomFeed{
+		Title:   joki.wikiName + " " + title,
+		ID:      base + page,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  joki.wikiName,
+		Links: []atomLink{
+			{Href: base + self, Rel: "self"},
+			{Href: base + page},
+		},
+	}
+	if len(changes) > 0 {
+		feed.Updated = changes[0].Modified.UTC().Format(time.RFC3339)
+	}
+	for _, c := range changes {
+		summary := "Updated " + c.Title + " (" + c.DeltaString() + " bytes)"
+		if c.Created {
+			summary = "Created " + c.Title + " (" + formatSize(c.Size) + ")"
+		}
+		updated := c.Modified.UTC().Format(time.RFC3339)
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   c.Title,
+			ID:      base + VIEW_PATH + c.Title + "#" + updated,
+			Updated: updated,
+			Link:    atomLink{Href: base + VIEW_PATH + c.Title},
+			Summary: summary,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		log.Printf("Could not write the feed of %s: %s", title, err)
+	}
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Uploads in progress are kept in this directory of the data path, as
+// <id>.json describing them and <id> with the bytes received so far
+const chunkedUploadsDir = ".uploads"
+
+const (
+	tusVersion          = "1.0.0"
+	chunkedUploadExpiry = 24 * time.Hour // after the last chunk
+)
+
+var validUploadID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// chunkedUpload is an attachment uploaded in chunks
+type chunkedUpload struct {
+	Title  string `json:"title"`
+	Name   string `json:"name"`
+	User   string `json:"user"`
+	Length int64  `json:"length"`
+}
+
+// chunkedUploads are the uploads in progress. They are resumed by their
+// owner only, one chunk at a time.
+type chunkedUploads struct {
+	sync.Mutex
+	dir  string
+	busy map[string]bool // id -> a chunk is being written
+}
+
+func newChunkedUploads(dataPath string) *chunkedUploads {
+	return &chunkedUploads{dir: filepath.Join(dataPath, chunkedUploadsDir), busy: make(map[string]bool)}
+}
+
+func (u *chunkedUploads) path(id string) string {
+	return filepath.Join(u.dir, id)
+}
+
+// Starts an upload and returns its id
+func (u *chunkedUploads) create(upload *chunkedUpload) (string, error) {
+	if err := os.MkdirAll(u.dir, 0700); err != nil {
+		return "", err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(u.path(id), nil, 0600); err != nil {
+		return "", err
+	}
+	return id, ioutil.WriteFile(u.path(id)+".json", data, 0600)
+}
+
+// Returns upload id and the bytes received so far
+func (u *chunkedUploads) get(id string) (*chunkedUpload, int64, error) {
+	if !validUploadID.MatchString(id) {
+		return nil, 0, os.ErrNotExist
+	}
+	data, err := ioutil.ReadFile(u.path(id) + ".json")
+	if err != nil {
+		return nil, 0, err
+	}
+	var upload chunkedUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, 0, err
+	}
+	info, err := os.Stat(u.path(id))
+	if err != nil {
+		return nil, 0, err
+	}
+	return &upload, info.Size(), nil
+}
+
+// Claims upload id for writing a chunk, false if one is being written
+func (u *chunkedUploads) claim(id string) bool {
+	u.Lock()
+	defer u.Unlock()
+	if u.busy[id] {
+		return false
+	}
+	u.busy[id] = true
+	return true
+}
+
+func (u *chunkedUploads) release(id string) {
+	u.Lock()
+	defer u.Unlock()
+	delete(u.busy, id)
+}
+
+func (u *chunkedUploads) remove(id string) error {
+	os.Remove(u.path(id) + ".json")
+	return os.Remove(u.path(id))
+}
+
+// Removes the uploads nothing was sent to for chunkedUploadExpiry
+func (u *chunkedUploads) purge() {
+	files, err := ioutil.ReadDir(u.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error listing the uploads in progress: %s", err)
+		}
+		return
+	}
+	limit := time.Now().Add(-chunkedUploadExpiry)
+	for _, f := range files {
+		id := strings.TrimSuffix(f.Name(), ".json")
+		if !validUploadID.MatchString(id) || id != f.Name() || f.ModTime().After(limit) {
+			continue
+		}
+		u.Lock()
+		busy := u.busy[id]
+		u.Unlock()
+		if busy {
+			continue
+		}
+		if err := u.remove(id); err != nil {
+			log.Printf("Error removing the expired upload %s: %s", id, err)
+		}
+	}
+}
+
+// Parses Upload-Metadata, comma separated keys with their base64 values
+func uploadMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		value := ""
+		if len(fields) > 1 {
+			b, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				continue
+			}
+			value = string(b)
+		}
+		metadata[fields[0]] = value
+	}
+	return metadata
+}
+
+// Serves /uploads/, the tus protocol (https://tus.io) for attachments too big
+// for a single request: a POST with Upload-Length and the title and filename
+// in Upload-Metadata starts an upload at /uploads/<id>, which is sent in
+// PATCHes from the Upload-Offset that HEAD returns and attached once
+// complete. The quotas are checked when it starts and again at the end.
+func (joki *joki) chunkedUploadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,termination")
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(joki.chunkedUploadSize, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if v := r.Header.Get("Tus-Resumable"); v != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Only tus "+tusVersion+" is supported", http.StatusPreconditionFailed)
+		return
+	}
+	if !joki.features.enabled(uploadsFeature) {
+		http.Error(w, "The "+uploadsFeature+" feature is turned off", http.StatusForbidden)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, CHUNKED_UPLOADS_PATH)
+	if id == "" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Starting an upload requires POST", http.StatusMethodNotAllowed)
+			return
+		}
+		joki.createChunkedUpload(w, r)
+		return
+	}
+
+	upload, offset, err := joki.chunkedUploads.get(id)
+	if err != nil || upload.User != joki.currentUser(r) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch:
+		joki.writeChunk(w, r, id, upload, offset)
+	case http.MethodDelete:
+		if !joki.chunkedUploads.claim(id) {
+			http.Error(w, "A chunk is being written", http.StatusConflict)
+			return
+		}
+		defer joki.chunkedUploads.release(id)
+		if err := joki.chunkedUploads.remove(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Uploads are resumed with HEAD and PATCH", http.StatusMethodNotAllowed)
+	}
+}
+
+// Starts an upload after checking the page, the name and the quotas
+func (joki *joki) createChunkedUpload(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Upload-Length is required", http.StatusBadRequest)
+		return
+	}
+	if length > joki.chunkedUploadSize {
+		http.Error(w, "Uploads may have at most "+formatSize(joki.chunkedUploadSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata := uploadMetadata(r.Header.Get("Upload-Metadata"))
+	title, name := metadata["title"], filepath.Base(metadata["filename"])
+	if !validTitle.MatchString(title) || !joki.canEdit(r, title) || joki.hiddenDraft(r, title) {
+		http.NotFound(w, r)
+		return
+	}
+	if !joki.exists(title) {
+		http.Error(w, "Save the page before attaching files", http.StatusNotFound)
+		return
+	}
+	if !validFileName.MatchString(name) {
+		http.Error(w, "File name is invalid: "+name, http.StatusBadRequest)
+		return
+	}
+	if err := joki.holds.check(title); err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+	user := joki.currentUser(r)
+	if err := joki.checkAttachmentQuota(title, name, user, length); err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+	if err := joki.throttle(r, title); err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+
+	joki.chunkedUploads.purge()
+	id, err := joki.chunkedUploads.create(&chunkedUpload{Title: title, Name: name, User: user, Length: length})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", CHUNKED_UPLOADS_PATH+id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Appends the body of a PATCH to an upload and attaches the file once it is
+// complete. What arrived of an interrupted chunk is kept to resume from.
+func (joki *joki) writeChunk(w http.ResponseWriter, r *http.Request, id string, upload *chunkedUpload, offset int64) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Chunks must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	if !joki.chunkedUploads.claim(id) {
+		http.Error(w, "A chunk is being written", http.StatusConflict)
+		return
+	}
+	defer joki.chunkedUploads.release(id)
+	if info, err := os.Stat(joki.chunkedUploads.path(id)); err == nil {
+		offset = info.Size() // the chunk before may have ended since
+	}
+	if r.Header.Get("Upload-Offset") != strconv.FormatInt(offset, 10) {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		http.Error(w, "Upload-Offset must be "+strconv.FormatInt(offset, 10), http.StatusConflict)
+		return
+	}
+
+	f, err := os.OpenFile(joki.chunkedUploads.path(id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	n, err := io.Copy(f, io.LimitReader(r.Body, upload.Length-offset))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	offset += n
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if err != nil {
+		http.Error(w, "Chunk interrupted: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if offset < upload.Length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Complete, it gets attached or rejected for good
+	defer func() {
+		if err := joki.chunkedUploads.remove(id); err != nil {
+			log.Printf("Error removing the finished upload %s: %s", id, err)
+		}
+	}()
+	if err := joki.holds.check(upload.Title); err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+	file, err := os.Open(joki.chunkedUploads.path(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	if _, err := joki.attach(r, upload.Title, upload.Name, file, upload.Length); err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// ConflictPage is shown when a page changed while it was being edited
+type ConflictPage struct {
+	Title    string
+	NewTitle string
+	Body     string
+	Message  string
+	Author   string
+	Version  string
+	Lines    []DiffLine
+}
+
+// Returns a short hash identifying the content of a page. Missing pages
+// have the empty version.
+func pageVersion(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Version identifies the content the editor was opened with
+func (p *Page) Version() string {
+	return pageVersion(p.Body)
+}
+
+// Returns the version of the page as it is stored right now
+func (joki *joki) currentVersion(title string) string {
+	p, err := joki.loadPage(title)
+	if err != nil {
+		return ""
+	}
+	return p.Version()
+}
+
+// Checks whether the page was changed by someone else since the editor
+// was opened. If so the conflict page comparing both versions is shown and
+// true is returned. Saving from the conflict page overwrites the page, as
+// the editor then carries the version that was compared against.
+func (joki *joki) editConflict(w http.ResponseWriter, r *http.Request, title, body string) bool {
+	versions, ok := r.PostForm["version"]
+	if !ok {
+		return false // not saved from the editor
+	}
+	current := joki.currentVersion(title)
+	if versions[0] == current {
+		return false
+	}
+
+	var theirs string
+	if p, err := joki.loadPage(title); err == nil {
+		theirs = string(p.Body)
+	}
+	w.WriteHeader(http.StatusConflict)
+	joki.renderTemplate(w, r, "conflict", &ConflictPage{
+		Title:    title,
+		NewTitle: r.FormValue("title"),
+		Body:     body,
+		Message:  r.FormValue("message"),
+		Author:   r.FormValue("author"),
+		Version:  current,
+		Lines:    diffLines(theirs, body),
+	})
+	return true
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// confluencePage is a page of a Confluence space exported as html
+type confluencePage struct {
+	file    string // name in the export, like Release-Notes_65538.html
+	name    string // title shown in Confluence
+	parent  string // file of the parent page, "" for top level pages
+	content *html.Node
+	title   string // title in the wiki, set once all pages are known
+}
+
+var invalidFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Turns the file name of an imported attachment into a valid one
+func attachmentName(file string) string {
+	name := strings.TrimLeft(invalidFileChars.ReplaceAllString(path.Base(file), "-"), "._-")
+	if name == "" {
+		name = "attachment"
+	}
+	return name
+}
+
+// Turns a name like "Release notes 2.0" into ReleaseNotes20, or "" if it
+// has no letters or digits
+func camelCase(name string) string {
+	var title strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool {
+		return !(r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+	}) {
+		title.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return title.String()
+}
+
+// Turns the name of an imported page like "Release notes 2.0" into a wiki
+// title like ReleaseNotes20 below namespace ns. Names that turn into a title
+// already taken are numbered.
+func importTitle(ns, name string, taken map[string]bool) string {
+	name = camelCase(name)
+	if name == "" {
+		name = "Page"
+	}
+	if ns != "" {
+		name = ns + "/" + name
+	}
+	t := name
+	for i := 2; taken[t]; i++ {
+		t = name + strconv.Itoa(i)
+	}
+	taken[t] = true
+	return t
+}
+
+// Returns the element with the id, or nil
+func findID(n *html.Node, id string) *html.Node {
+	if n.Type == html.ElementNode && attr(n, "id") == id {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findID(c, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Parses a page of the export. The last breadcrumb other than the space
+// overview is the parent page.
+func parseConfluencePage(file string, r io.Reader) (*confluencePage, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &confluencePage{file: file, content: findID(doc, "main-content")}
+	if p.content == nil {
+		return nil, nil // not a page, e.g. a blog overview
+	}
+
+	if heading := findID(doc, "title-text"); heading != nil {
+		p.name = nodeText(heading)
+	}
+	// Titles are prefixed with the name of the space
+	if i := strings.Index(p.name, " : "); i >= 0 {
+		p.name = p.name[i+3:]
+	}
+	if breadcrumbs := findID(doc, "breadcrumbs"); breadcrumbs != nil {
+		var walk func(n *html.Node)
+		walk = func(n *html.Node) {
+			if n.Type == html.ElementNode && n.Data == "a" && attr(n, "href") != "index.html" {
+				p.parent = attr(n, "href")
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c)
+			}
+		}
+		walk(breadcrumbs)
+	}
+	return p, nil
+}
+
+// Sets the wiki titles of the pages, which nest like the pages in Confluence.
+// Names that turn into the same title are numbered.
+func titleConfluencePages(pages map[string]*confluencePage, ns string) {
+	taken := make(map[string]bool)
+	var title func(p *confluencePage, depth int) string
+	title = func(p *confluencePage, depth int) string {
+		if p.title != "" {
+			return p.title
+		}
+		base := ns
+		if parent, ok := pages[p.parent]; ok && depth < len(pages) {
+			base = title(parent, depth+1)
+		}
+		p.title = importTitle(base, p.name, taken)
+		return p.title
+	}
+	for _, p := range pages {
+		title(p, 0)
+	}
+}
+
+// Points links between the exported pages to the wiki pages and images and
+// links to attachments to the attachments of page p. Returns the attachment
+// files referenced, mapped to their name in the wiki.
+func rewriteConfluenceLinks(p *confluencePage, pages map[string]*confluencePage) map[string]string {
+	files := make(map[string]string)
+	attachment := func(src string) string {
+		name := attachmentName(src)
+		files[src] = name
+		return FILES_PATH + p.title + "/" + name
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			if c.Type == html.ElementNode {
+				for i, a := range c.Attr {
+					if a.Key != "href" && a.Key != "src" {
+						continue
+					}
+					target := strings.SplitN(a.Val, "#", 2)[0]
+					if linked, ok := pages[target]; ok {
+						c.Attr[i].Val = VIEW_PATH + linked.title
+					} else if strings.HasPrefix(target, attachmentsDir+"/") {
+						c.Attr[i].Val = attachment(target)
+					} else if c.Data == "img" && !strings.Contains(a.Val, "://") {
+						// icons and emoticons of Confluence itself
+						n.RemoveChild(c)
+						break
+					}
+				}
+			}
+			walk(c)
+			c = next
+		}
+	}
+	walk(p.content)
+	return files
+}
+
+// Imports a Confluence space exported as html into namespace ns. Pages
+// nest like they did in Confluence, attachments are attached to the pages
+// that use them.
+func (joki *joki) importConfluence(zipFile, ns string) error {
+	if ns != "" && !validPageTitle(ns) {
+		return fmt.Errorf("namespace \"%s\" is invalid", ns)
+	}
+	z, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+
+	// Pages are next to index.html, in a directory named after the space
+	root := ""
+	files := make(map[string]*zip.File)
+	for _, f := range z.File {
+		files[f.Name] = f
+		if path.Base(f.Name) == "index.html" && (root == "" || len(f.Name) < len(root)) {
+			root = f.Name
+		} else if path.Base(f.Name) == "entities.xml" {
+			return errors.New("XML space exports are not supported, export the space as HTML")
+		}
+	}
+	if root == "" {
+		return errors.New("no index.html found, is this a Confluence HTML export?")
+	}
+	root = path.Dir(root)
+
+	pages := make(map[string]*confluencePage)
+	for name, f := range files {
+		if path.Dir(name) != root || path.Ext(name) != ".html" || path.Base(name) == "index.html" {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		p, err := parseConfluencePage(path.Base(name), r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if p != nil {
+			pages[p.file] = p
+		}
+	}
+	titleConfluencePages(pages, ns)
+
+	var changed []string
+	for _, p := range pages {
+		for src, name := range rewriteConfluenceLinks(p, pages) {
+			f, ok := files[path.Join(root, src)]
+			if !ok {
+				log.Printf("%s: attachment %s is missing from the export", p.file, src)
+				continue
+			}
+			if err := joki.importAttachment(p.title, name, f); err != nil {
+				return fmt.Errorf("%s: %s", p.file, err)
+			}
+			changed = append(changed, attachmentFile(p.title, name))
+		}
+
+		body := "# " + p.name + "\n\n" + nodeMarkdown(p.content) + "\n"
+		if joki.exists(p.title) {
+			log.Printf("Overwriting %s, its current version is kept in the history", p.title)
+		}
+		if _, err := joki.storePage(p.title, []byte(body), ""); err != nil {
+			return fmt.Errorf("%s: %s", p.file, err)
+		}
+		changed = append(changed, pageFile(p.title))
+	}
+	log.Printf("Imported %d pages from %s", len(pages), zipFile)
+
+	if joki.git != nil {
+		return joki.git.commit("gowiki", "Import "+filepath.Base(zipFile), changed, nil)
+	}
+	return nil
+}
+
+// Copies an attachment out of an export
+func (joki *joki) importAttachment(title, name string, f *zip.File) error {
+	if err := joki.checkQuotaGrowth(int64(f.UncompressedSize64), name); err != nil {
+		return err
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	hash, _, err := joki.blobs.store(r)
+	if err != nil {
+		return err
+	}
+	dir := joki.attachmentPath(title)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return joki.blobs.link(hash, filepath.Join(dir, name))
+}
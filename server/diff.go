@@ -0,0 +1,97 @@
+package server
+
+import "strings"
+
+const (
+	diffSame    = " "
+	diffAdded   = "+"
+	diffRemoved = "-"
+)
+
+// DiffLine is a single line of a line based diff
+type DiffLine struct {
+	Kind string
+	Text string
+}
+
+// Changed lines are diffed with a table of their product, beyond this many
+// cells they are shown as replaced as a whole
+const maxDiffCells = 1 << 22
+
+// Computes a line based diff from a to b using the longest common
+// subsequence of the lines between their common start and end
+func diffLines(a, b string) []DiffLine {
+	al := strings.Split(a, "\n")
+	bl := strings.Split(b, "\n")
+	lines := make([]DiffLine, 0, len(al)+len(bl))
+
+	start := 0
+	for start < len(al) && start < len(bl) && al[start] == bl[start] {
+		lines = append(lines, DiffLine{diffSame, al[start]})
+		start++
+	}
+	end := 0
+	for end < len(al)-start && end < len(bl)-start && al[len(al)-1-end] == bl[len(bl)-1-end] {
+		end++
+	}
+	lines = append(lines, diffChanged(al[start:len(al)-end], bl[start:len(bl)-end])...)
+	for _, line := range al[len(al)-end:] {
+		lines = append(lines, DiffLine{diffSame, line})
+	}
+	return lines
+}
+
+// Diffs the lines between the common start and end of two texts
+func diffChanged(al, bl []string) []DiffLine {
+	lines := make([]DiffLine, 0, len(al)+len(bl))
+	if (len(al)+1)*(len(bl)+1) > maxDiffCells {
+		for _, line := range al {
+			lines = append(lines, DiffLine{diffRemoved, line})
+		}
+		for _, line := range bl {
+			lines = append(lines, DiffLine{diffAdded, line})
+		}
+		return lines
+	}
+
+	// lcs[i][j] is the length of the common subsequence of al[i:] and bl[j:]
+	lcs := make([][]int32, len(al)+1)
+	cells := make([]int32, (len(al)+1)*(len(bl)+1))
+	for i := range lcs {
+		lcs[i] = cells[i*(len(bl)+1) : (i+1)*(len(bl)+1)]
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(al) && j < len(bl) {
+		switch {
+		case al[i] == bl[j]:
+			lines = append(lines, DiffLine{diffSame, al[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, DiffLine{diffRemoved, al[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{diffAdded, bl[j]})
+			j++
+		}
+	}
+	for ; i < len(al); i++ {
+		lines = append(lines, DiffLine{diffRemoved, al[i]})
+	}
+	for ; j < len(bl); j++ {
+		lines = append(lines, DiffLine{diffAdded, bl[j]})
+	}
+	return lines
+}
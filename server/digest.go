@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	digestFile     = ".digest.json" // when the last digest was sent
+	digestInterval = 7 * 24 * time.Hour
+	digestCheck    = time.Hour // how often the job looks whether a digest is due
+)
+
+// digestPage sums up the changes to a page in a digest
+type digestPage struct {
+	title    string
+	authors  []string
+	messages []string
+	deleted  bool
+}
+
+// Sends the weekly digest to the targets of the digest rules, if there are
+// any. The first digest goes out a week after the first start.
+func (joki *joki) startDigest() {
+	if joki.notifier == nil || len(joki.notifier.targets(Notification{Event: digestEvent}, "")) == 0 {
+		return
+	}
+	file := filepath.Join(joki.dataPath, digestFile)
+	go func() {
+		for {
+			if err := joki.sendDigestIfDue(file, time.Now()); err != nil {
+				log.Printf("Could not send the digest: %s", err)
+			}
+			time.Sleep(digestCheck)
+		}
+	}()
+}
+
+// Sends the changes since the last digest if it was sent digestInterval
+// before now and records when it was sent in file
+func (joki *joki) sendDigestIfDue(file string, now time.Time) error {
+	var state struct {
+		Sent time.Time `json:"sent"`
+	}
+	data, err := ioutil.ReadFile(file)
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+	if !state.Sent.IsZero() && now.Sub(state.Sent) < digestInterval {
+		return nil
+	}
+	if !state.Sent.IsZero() {
+		text, err := joki.digest(state.Sent, now)
+		if err != nil {
+			return err
+		}
+		if text != "" {
+			joki.sendDigest(text)
+		}
+	}
+	state.Sent = now
+	if data, err = json.Marshal(state); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
+
+// Sends the digest text to the targets of the digest rules
+func (joki *joki) sendDigest(text string) {
+	n := Notification{Wiki: joki.wikiName, Event: digestEvent, Message: text}
+	if joki.publicURL != "" {
+		n.URL = joki.publicURL + CHANGES_PATH
+	}
+	for _, rule := range joki.notifier.targets(n, "") {
+		if err := joki.notifier.transports[rule.transport].Send(rule.target, n); err != nil {
+			log.Printf("Could not send the digest to %s: %s", rule.target, err)
+		}
+	}
+}
+
+// Returns the changes of the edit log between since and until grouped by
+// namespace, with who made them and their summaries, or "" if there were
+// none. Drafts and pages with readers are left out, the digest goes to
+// everyone subscribed.
+func (joki *joki) digest(since, until time.Time) (string, error) {
+	entries, err := joki.moderation.edits()
+	if err != nil {
+		return "", err
+	}
+	pages := make(map[string]*digestPage)
+	add := func(title, author, message string, deleted bool) {
+		p := pages[title]
+		if p == nil {
+			p = &digestPage{title: title}
+			pages[title] = p
+		}
+		if !containsString(p.authors, author) {
+			p.authors = append(p.authors, author)
+		}
+		if message != "" && !containsString(p.messages, message) {
+			p.messages = append(p.messages, message)
+		}
+		p.deleted = deleted
+	}
+	for _, entry := range entries {
+		if entry.Time.Before(since) || !entry.Time.Before(until) {
+			continue
+		}
+		for _, title := range entry.Pages {
+			add(title, entry.Author, entry.Message, false)
+		}
+		for _, title := range entry.Removed {
+			add(title, entry.Author, entry.Message, true)
+		}
+	}
+
+	groups := make(map[string][]*digestPage)
+	for title, p := range pages {
+		meta := joki.meta.get(title)
+		if !p.deleted && (meta.Draft || len(meta.Readers) > 0) {
+			continue
+		}
+		groups[namespace(title)] = append(groups[namespace(title)], p)
+	}
+	if len(groups) == 0 {
+		return "", nil
+	}
+	names := make([]string, 0, len(groups))
+	for ns := range groups {
+		names = append(names, ns)
+	}
+	sort.Strings(names)
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Changes to %s from %s to %s\n", joki.wikiName, since.Format("Jan 2"), until.Format("Jan 2"))
+	for _, ns := range names {
+		if ns == "" {
+			text.WriteString("\nTop level\n")
+		} else {
+			text.WriteString("\n" + ns + "\n")
+		}
+		group := groups[ns]
+		sort.Slice(group, func(i, j int) bool { return group[i].title < group[j].title })
+		for _, p := range group {
+			verb := "changed"
+			if p.deleted {
+				verb = "deleted"
+			}
+			fmt.Fprintf(&text, "- %s %s by %s\n", p.title, verb, strings.Join(p.authors, ", "))
+			for _, message := range p.messages {
+				text.WriteString("    " + message + "\n")
+			}
+			if joki.publicURL != "" && !p.deleted {
+				text.WriteString("    " + joki.publicURL + VIEW_PATH + p.title + "\n")
+			}
+		}
+	}
+	return strings.TrimSuffix(text.String(), "\n"), nil
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DrawPage is the drawing editor for an SVG attachment of a page
+type DrawPage struct {
+	Title  string
+	Name   string
+	Exists bool // the drawing is edited, not started
+}
+
+// Colors of drawio styles that are copied into their preview
+var drawioColor = regexp.MustCompile(`^#[0-9a-fA-F]{3,8}$`)
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+type mxPoint struct {
+	X  float64 `xml:"x,attr"`
+	Y  float64 `xml:"y,attr"`
+	As string  `xml:"as,attr"`
+}
+
+type mxCell struct {
+	ID       string `xml:"id,attr"`
+	Parent   string `xml:"parent,attr"`
+	Value    string `xml:"value,attr"`
+	Style    string `xml:"style,attr"`
+	Vertex   string `xml:"vertex,attr"`
+	Edge     string `xml:"edge,attr"`
+	Source   string `xml:"source,attr"`
+	Target   string `xml:"target,attr"`
+	Geometry struct {
+		X      float64   `xml:"x,attr"`
+		Y      float64   `xml:"y,attr"`
+		Width  float64   `xml:"width,attr"`
+		Height float64   `xml:"height,attr"`
+		Points []mxPoint `xml:"mxPoint"`
+	} `xml:"mxGeometry"`
+}
+
+type mxGraphModel struct {
+	Cells []mxCell `xml:"root>mxCell"`
+}
+
+// Reads the first diagram of a drawio file, whose model may be compressed
+// the way draw.io saves it: deflated, base64 and URL encoded
+func drawioModel(data []byte) (*mxGraphModel, error) {
+	var file struct {
+		XMLName  xml.Name
+		Diagrams []struct {
+			Inner string `xml:",innerxml"`
+		} `xml:"diagram"`
+	}
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file.XMLName.Local == "mxGraphModel" {
+		var model mxGraphModel
+		return &model, xml.Unmarshal(data, &model)
+	}
+	if file.XMLName.Local != "mxfile" || len(file.Diagrams) == 0 {
+		return nil, fmt.Errorf("not a drawio file")
+	}
+	inner := []byte(strings.TrimSpace(file.Diagrams[0].Inner))
+	if !bytes.HasPrefix(inner, []byte("<")) {
+		compressed, err := base64.StdEncoding.DecodeString(string(inner))
+		if err != nil {
+			return nil, err
+		}
+		inflated, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+		if err != nil {
+			return nil, err
+		}
+		unescaped, err := url.PathUnescape(string(inflated))
+		if err != nil {
+			return nil, err
+		}
+		inner = []byte(unescaped)
+	}
+	var model mxGraphModel
+	return &model, xml.Unmarshal(inner, &model)
+}
+
+// Returns the value of key in a drawio style like "rounded=1;fillColor=#fff"
+func styleValue(style, key string) string {
+	for _, s := range strings.Split(style, ";") {
+		if strings.HasPrefix(s, key+"=") {
+			return s[len(key)+1:]
+		}
+	}
+	return ""
+}
+
+func styleColor(style, key, fallback string) string {
+	if c := styleValue(style, key); drawioColor.MatchString(c) {
+		return c
+	} else if c == "none" {
+		return "none"
+	}
+	return fallback
+}
+
+// Renders a drawio diagram as SVG: its shapes as rectangles, ellipses or
+// rhombi with their labels and its edges as arrows. Not every style of
+// draw.io is known, the editor is the place for the details.
+func drawioSVG(data []byte) ([]byte, error) {
+	model, err := drawioModel(data)
+	if err != nil {
+		return nil, err
+	}
+	cells := make(map[string]*mxCell)
+	for i := range model.Cells {
+		cells[model.Cells[i].ID] = &model.Cells[i]
+	}
+	// Shapes in groups and containers are placed relative to them
+	var origin func(c *mxCell, depth int) (float64, float64)
+	origin = func(c *mxCell, depth int) (float64, float64) {
+		p, ok := cells[c.Parent]
+		if !ok || p.Vertex != "1" || depth > 16 {
+			return 0, 0
+		}
+		x, y := origin(p, depth+1)
+		return x + p.Geometry.X, y + p.Geometry.Y
+	}
+	center := func(c *mxCell) (float64, float64) {
+		x, y := origin(c, 0)
+		return x + c.Geometry.X + c.Geometry.Width/2, y + c.Geometry.Y + c.Geometry.Height/2
+	}
+	// Moves the end p of an edge coming from q from the center of shape c
+	// to its border
+	border := func(p, q [2]float64, c *mxCell) [2]float64 {
+		dx, dy := q[0]-p[0], q[1]-p[1]
+		scale := math.Inf(1)
+		if dx != 0 {
+			scale = c.Geometry.Width / 2 / math.Abs(dx)
+		}
+		if dy != 0 {
+			scale = math.Min(scale, c.Geometry.Height/2/math.Abs(dy))
+		}
+		if math.IsInf(scale, 1) || scale > 1 {
+			return p
+		}
+		return [2]float64{math.Round(p[0] + dx*scale), math.Round(p[1] + dy*scale)}
+	}
+
+	var shapes, edges bytes.Buffer
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	extend := func(x, y float64) {
+		minX, minY, maxX, maxY = math.Min(minX, x), math.Min(minY, y), math.Max(maxX, x), math.Max(maxY, y)
+	}
+	label := func(c *mxCell, x, y float64) {
+		text := strings.TrimSpace(html.UnescapeString(htmlTag.ReplaceAllString(strings.Replace(c.Value, "<br>", " ", -1), "")))
+		if text == "" {
+			return
+		}
+		fmt.Fprintf(&shapes, `<text x="%g" y="%g" text-anchor="middle" dominant-baseline="middle" fill="%s">`, x, y, styleColor(c.Style, "fontColor", "#000"))
+		xml.EscapeText(&shapes, []byte(text))
+		shapes.WriteString("</text>")
+	}
+
+	for i := range model.Cells {
+		c := &model.Cells[i]
+		switch {
+		case c.Vertex == "1":
+			ox, oy := origin(c, 0)
+			x, y, w, h := ox+c.Geometry.X, oy+c.Geometry.Y, c.Geometry.Width, c.Geometry.Height
+			extend(x, y)
+			extend(x+w, y+h)
+			fill, stroke := styleColor(c.Style, "fillColor", "#fff"), styleColor(c.Style, "strokeColor", "#000")
+			switch shape := c.Style; {
+			case strings.HasPrefix(shape, "text") || strings.Contains(shape, ";text;"):
+			case strings.HasPrefix(shape, "ellipse") || styleValue(shape, "shape") == "ellipse":
+				fmt.Fprintf(&shapes, `<ellipse cx="%g" cy="%g" rx="%g" ry="%g" fill="%s" stroke="%s"/>`, x+w/2, y+h/2, w/2, h/2, fill, stroke)
+			case strings.HasPrefix(shape, "rhombus") || styleValue(shape, "shape") == "rhombus":
+				fmt.Fprintf(&shapes, `<polygon points="%g,%g %g,%g %g,%g %g,%g" fill="%s" stroke="%s"/>`, x+w/2, y, x+w, y+h/2, x+w/2, y+h, x, y+h/2, fill, stroke)
+			default:
+				rx := 0.0
+				if styleValue(shape, "rounded") == "1" {
+					rx = math.Min(w, h) / 6
+				}
+				fmt.Fprintf(&shapes, `<rect x="%g" y="%g" width="%g" height="%g" rx="%g" fill="%s" stroke="%s"/>`, x, y, w, h, rx, fill, stroke)
+			}
+			label(c, x+w/2, y+h/2)
+		case c.Edge == "1":
+			var points [][2]float64
+			if s, ok := cells[c.Source]; ok {
+				x, y := center(s)
+				points = append(points, [2]float64{x, y})
+			}
+			for _, p := range c.Geometry.Points {
+				if p.As == "sourcePoint" && len(points) == 0 || p.As == "targetPoint" && c.Target == "" {
+					points = append(points, [2]float64{p.X, p.Y})
+				}
+			}
+			if t, ok := cells[c.Target]; ok {
+				x, y := center(t)
+				points = append(points, [2]float64{x, y})
+			}
+			if len(points) < 2 {
+				continue
+			}
+			if s, ok := cells[c.Source]; ok {
+				points[0] = border(points[0], points[1], s)
+			}
+			if t, ok := cells[c.Target]; ok {
+				n := len(points) - 1
+				points[n] = border(points[n], points[n-1], t)
+			}
+			edges.WriteString(`<polyline points="`)
+			for _, p := range points {
+				extend(p[0], p[1])
+				fmt.Fprintf(&edges, "%g,%g ", p[0], p[1])
+			}
+			fmt.Fprintf(&edges, `" fill="none" stroke="%s" marker-end="url(#arrow)"/>`, styleColor(c.Style, "strokeColor", "#000"))
+			a, b := points[0], points[len(points)-1]
+			label(c, (a[0]+b[0])/2, (a[1]+b[1])/2)
+		}
+	}
+	if math.IsInf(minX, 1) {
+		minX, minY, maxX, maxY = 0, 0, 0, 0
+	}
+
+	var svg bytes.Buffer
+	const margin = 10
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="%g %g %g %g" width="%g" height="%g" font-family="sans-serif" font-size="12">`,
+		minX-margin, minY-margin, maxX-minX+2*margin, maxY-minY+2*margin, maxX-minX+2*margin, maxY-minY+2*margin)
+	svg.WriteString(`<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto"><path d="M0,0 L10,5 L0,10 z"/></marker></defs>`)
+	svg.Write(edges.Bytes()) // below the shapes they connect
+	svg.Write(shapes.Bytes())
+	svg.WriteString("</svg>")
+	return svg.Bytes(), nil
+}
+
+// Serves the SVG preview of an attached drawio diagram
+func (joki *joki) serveDrawio(w http.ResponseWriter, r *http.Request, title, name string) {
+	data, err := ioutil.ReadFile(filepath.Join(joki.attachmentPath(title), name))
+	if err != nil || path.Ext(name) != ".drawio" {
+		http.NotFound(w, r)
+		return
+	}
+	svg, err := drawioSVG(data)
+	if err != nil {
+		http.Error(w, "Could not read the diagram: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(svg)
+}
+
+// Shows images of attached drawio diagrams, ![flow](/files/Page/flow.drawio),
+// by their SVG preview
+func insertDrawings(body []byte) []byte {
+	return imageTag.ReplaceAllFunc(body, func(img []byte) []byte {
+		src := string(imageTag.FindSubmatch(img)[1])
+		if !strings.HasPrefix(src, FILES_PATH) || path.Ext(src) != ".drawio" {
+			return img
+		}
+		return bytes.Replace(img, []byte(`src="`+src+`"`), []byte(`src="`+src+`?svg"`), 1)
+	})
+}
+
+// Serves the drawing editor for the SVG attachment ?file= of a page, which
+// saves the drawing back to it through the upload
+func (joki *joki) drawHandler(w http.ResponseWriter, r *http.Request, title string) {
+	name := r.FormValue("file")
+	if !validFileName.MatchString(name) || path.Ext(name) != ".svg" {
+		http.Error(w, "Drawings are .svg files", http.StatusBadRequest)
+		return
+	}
+	if !joki.exists(title) {
+		http.Error(w, "Save the page before drawing", http.StatusNotFound)
+		return
+	}
+	if !joki.features.enabled(uploadsFeature) {
+		http.Error(w, "The "+uploadsFeature+" feature is turned off", http.StatusForbidden)
+		return
+	}
+	_, err := os.Stat(filepath.Join(joki.attachmentPath(title), name))
+	joki.renderTemplate(w, r, "draw", &DrawPage{Title: title, Name: name, Exists: err == nil})
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"hash/fnv"
+	"html/template"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	shingleSize        = 5
+	duplicateThreshold = 0.5
+)
+
+// Duplicate is a pair of pages with largely the same text
+type Duplicate struct {
+	A          string
+	B          string
+	Similarity float64
+}
+
+// Percent returns the similarity for display
+func (d Duplicate) Percent() int {
+	return int(d.Similarity*100 + 0.5)
+}
+
+// DuplicateReport lists the near-duplicate pages of the wiki
+type DuplicateReport struct {
+	Threshold  float64
+	Duplicates []Duplicate
+}
+
+// ComparePage shows two pages side by side
+type ComparePage struct {
+	A, B         string
+	BodyA, BodyB template.HTML
+	Lines        []DiffLine
+}
+
+// Returns the set of hashed runs of shingleSize words of a text. Short
+// texts are a single shingle so they still compare with each other.
+func shingles(text string) map[uint64]bool {
+	words := tokenize(text)
+	set := make(map[uint64]bool)
+	for i := 0; i == 0 || i+shingleSize <= len(words); i++ {
+		end := i + shingleSize
+		if end > len(words) {
+			end = len(words)
+		}
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:end], " ")))
+		set[h.Sum64()] = true
+	}
+	return set
+}
+
+// Returns the Jaccard similarity of two shingle sets
+func similarity(a, b map[uint64]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for s := range a {
+		if b[s] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// Finds all pairs of pages at least threshold similar, most similar first
+func (joki *joki) findDuplicates(threshold float64) ([]Duplicate, error) {
+	titles, err := joki.listPages()
+	if err != nil {
+		return nil, err
+	}
+	sets := make([]map[uint64]bool, len(titles))
+	for i, title := range titles {
+		p, err := joki.loadPage(title)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = shingles(string(p.Body))
+	}
+
+	var dups []Duplicate
+	for i := range titles {
+		for j := i + 1; j < len(titles); j++ {
+			if sim := similarity(sets[i], sets[j]); sim >= threshold {
+				dups = append(dups, Duplicate{A: titles[i], B: titles[j], Similarity: sim})
+			}
+		}
+	}
+	sort.Slice(dups, func(i, j int) bool { return dups[i].Similarity > dups[j].Similarity })
+	return dups, nil
+}
+
+// Lists near-duplicate pages, the threshold can be set with ?threshold=0.8
+func (joki *joki) duplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	threshold := duplicateThreshold
+	if t, err := strconv.ParseFloat(r.FormValue("threshold"), 64); err == nil && t > 0 && t <= 1 {
+		threshold = t
+	}
+	dups, err := joki.findDuplicates(threshold)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	joki.renderTemplate(w, r, "duplicates", &DuplicateReport{Threshold: threshold, Duplicates: dups})
+}
+
+// Shows the pages ?a= and ?b= side by side to help merging them
+func (joki *joki) compareHandler(w http.ResponseWriter, r *http.Request) {
+	cp := &ComparePage{A: r.FormValue("a"), B: r.FormValue("b")}
+	if !validPageTitle(cp.A) || !validPageTitle(cp.B) {
+		http.Error(w, "Two page titles are needed to compare", http.StatusBadRequest)
+		return
+	}
+	a, err := joki.loadPage(cp.A)
+	if err != nil {
+		http.Error(w, err.Error(), pageErrorStatus(err))
+		return
+	}
+	b, err := joki.loadPage(cp.B)
+	if err != nil {
+		http.Error(w, err.Error(), pageErrorStatus(err))
+		return
+	}
+	cp.BodyA = template.HTML(joki.renderSafe(cp.A, a.Body))
+	cp.BodyB = template.HTML(joki.renderSafe(cp.B, b.Body))
+	cp.Lines = diffLines(string(a.Body), string(b.Body))
+	joki.renderTemplate(w, r, "compare", cp)
+}
+
+// Returns the http status a failed loadPage should be reported with
+func pageErrorStatus(err error) int {
+	if os.IsNotExist(err) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// editLock is held by the user who opened a page in the editor until it
+// expires or the page is saved
+type editLock struct {
+	user  string
+	until time.Time
+}
+
+// LockedPage is shown instead of the editor of a page someone else holds
+// the lock on
+type LockedPage struct {
+	Title string
+	User  string
+	Until time.Time
+}
+
+// editLocks keeps who holds the lock on which page. Locks are kept in
+// memory, a restart releases them all.
+type editLocks struct {
+	sync.Mutex
+	duration time.Duration
+	locks    map[string]editLock
+}
+
+func newEditLocks(duration time.Duration) *editLocks {
+	return &editLocks{duration: duration, locks: make(map[string]editLock)}
+}
+
+// Returns the lock on title, if it has not expired
+func (l *editLocks) holder(title string) (editLock, bool) {
+	l.Lock()
+	defer l.Unlock()
+	lock, ok := l.locks[title]
+	if ok && time.Now().After(lock.until) {
+		delete(l.locks, title)
+		return editLock{}, false
+	}
+	return lock, ok
+}
+
+// Takes or renews the lock on title for user, whoever held it before
+func (l *editLocks) acquire(title, user string) editLock {
+	l.Lock()
+	defer l.Unlock()
+	lock := editLock{user: user, until: time.Now().Add(l.duration)}
+	l.locks[title] = lock
+	return lock
+}
+
+// Releases the lock of user on title. A lock taken over by someone else is
+// kept.
+func (l *editLocks) release(title, user string) {
+	l.Lock()
+	defer l.Unlock()
+	if l.locks[title].user == user {
+		delete(l.locks, title)
+	}
+}
+
+// lockError reports that a change was refused as someone else is editing
+// the page
+type lockError struct {
+	title string
+	lock  editLock
+}
+
+func (e *lockError) Error() string {
+	return e.title + " is locked by " + e.lock.user + " until " + e.lock.until.Format("15:04")
+}
+
+// Returns the lock someone else than the user of the request holds on
+// page title
+func (joki *joki) lockedByOther(r *http.Request, title string) (editLock, bool) {
+	if joki.editLocks == nil {
+		return editLock{}, false
+	}
+	lock, ok := joki.editLocks.holder(title)
+	return lock, ok && lock.user != joki.currentUser(r)
+}
+
+// Returns a lockError if someone else than the user of the request is
+// editing page title, for every change of a page
+func (joki *joki) checkEditLock(r *http.Request, title string) error {
+	if lock, ok := joki.lockedByOther(r, title); ok {
+		return &lockError{title: title, lock: lock}
+	}
+	return nil
+}
+
+// Takes the lock on page title for the editor. If someone else holds it,
+// the locked page is shown instead and false returned, unless the lock is
+// broken by posting force.
+func (joki *joki) lockForEditing(w http.ResponseWriter, r *http.Request, title string) (editLock, bool) {
+	if joki.editLocks == nil {
+		return editLock{}, true
+	}
+	user := joki.currentUser(r)
+	if lock, ok := joki.lockedByOther(r, title); ok {
+		if r.Method != http.MethodPost || r.FormValue("force") == "" {
+			w.WriteHeader(http.StatusLocked)
+			joki.renderTemplate(w, r, "locked", &LockedPage{Title: title, User: lock.user, Until: lock.until})
+			return editLock{}, false
+		}
+		log.Printf("%s broke the edit lock of %s on %s", user, lock.user, title)
+	}
+	return joki.editLocks.acquire(title, user), true
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Matches the site absolute links the templates and the renderer write
+var absoluteURL = regexp.MustCompile(`(href|src)="(/[^"]*)"`)
+
+// Rewrites a site absolute url for a static copy, relative to the output
+// file name. Pages become .html files next to each other.
+func staticURL(file, url string) string {
+	fragment := ""
+	if i := strings.Index(url, "#"); i >= 0 {
+		url, fragment = url[:i], url[i:]
+	}
+	switch {
+	case url == "/":
+		url = frontPageTitle + ".html"
+	case url == "/pages" || url == PAGES_PATH:
+		url = "pages.html"
+	case strings.HasPrefix(url, VIEW_PATH):
+		url = strings.TrimPrefix(url, VIEW_PATH) + ".html"
+	case strings.HasPrefix(url, STATIC_PATH), strings.HasPrefix(url, FILES_PATH):
+		url = url[1:]
+	default:
+		return url + fragment // dynamic pages only exist on the server
+	}
+	return strings.Repeat("../", strings.Count(file, "/")) + url + fragment
+}
+
+// Executes a template and writes it to file below out with its links made
+// relative
+func (joki *joki) exportTemplate(out, file, tmpl string, data interface{}) error {
+	var buf bytes.Buffer
+	if err := joki.templates[tmpl].ExecuteTemplate(&buf, tmpl+".html", data); err != nil {
+		return err
+	}
+	html := absoluteURL.ReplaceAllStringFunc(buf.String(), func(attr string) string {
+		m := absoluteURL.FindStringSubmatch(attr)
+		return m[1] + `="` + staticURL(file, m[2]) + `"`
+	})
+
+	path := filepath.Join(out, filepath.FromSlash(file))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(html), 0644)
+}
+
+// Copies the directory tree src to dst
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, f os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == src {
+			return nil // nothing to copy
+		} else if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if f.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return copyFile(path, target, 0644)
+	})
+}
+
+// Copies the file src to dst, which is created with perm if needed
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Renders every page with the view template into a static html site in out,
+// together with a page list, the stylesheets and all attachments
+func (joki *joki) export(out string) error {
+	joki.exporting = true
+	defer func() { joki.exporting = false }()
+
+	titles, err := joki.listPages()
+	if err != nil {
+		return err
+	}
+	list := PageList{Pages: make([]PageInfo, 0, len(titles))}
+	for _, title := range titles {
+		p, err := joki.loadPage(title)
+		if err != nil {
+			return err
+		}
+		rendered := joki.renderPage(p, "")
+		if rendered.Meta.Draft || len(joki.meta.get(title).Readers) > 0 {
+			continue
+		}
+		if err := joki.exportTemplate(out, title+".html", "view", rendered); err != nil {
+			return err
+		}
+		info, err := joki.pageInfo(title)
+		if err != nil {
+			return err
+		}
+		list.Pages = append(list.Pages, info)
+	}
+	list.Sort = titleColumn
+	list.paginate()
+	if err := joki.exportTemplate(out, "pages.html", "pages", list); err != nil {
+		return err
+	}
+
+	if err := copyAssets("static", filepath.Join(out, STATIC_PATH)); err != nil {
+		return err
+	}
+	if joki.staticDir != "" {
+		if err := copyTree(joki.staticDir, filepath.Join(out, STATIC_PATH)); err != nil {
+			return err
+		}
+	}
+	if err := joki.exportHighlightCSS(filepath.Join(out, HIGHLIGHT_CSS_PATH)); err != nil {
+		return err
+	}
+	if err := copyTree(filepath.Join(joki.dataPath, attachmentsDir), filepath.Join(out, FILES_PATH)); err != nil {
+		return err
+	}
+	log.Printf("Exported %d pages to %s", len(list.Pages), out)
+	return nil
+}
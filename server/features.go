@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Features admins can turn on and off while the wiki runs
+const (
+	commentsFeature     = "comments"
+	uploadsFeature      = "uploads"
+	mathFeature         = "math"
+	coeditingFeature    = "coediting"
+	registrationFeature = "registration"
+	reportsFeature      = "reports"
+)
+
+// allFeatures lists the features in the order /admin/features shows them
+var allFeatures = []Feature{
+	{Name: commentsFeature, Description: "Readers annotate pages with comments"},
+	{Name: uploadsFeature, Description: "Editors attach files to pages"},
+	{Name: mathFeature, Description: "$math$ is rendered as math, if the math markdown extension is enabled"},
+	{Name: coeditingFeature, Description: "Editors see who else is editing a page"},
+	{Name: registrationFeature, Description: "Visitors register accounts, which admins approve in " + REGISTRATIONS_PATH + ". Needs -users and -smtp."},
+	{Name: reportsFeature, Description: "Readers report pages to the admins in " + MODERATION_PATH},
+}
+
+const (
+	defaultFeatures = "comments,uploads,math,coediting"
+	featuresFile    = ".features.json"
+)
+
+// Feature is a part of the wiki that can be turned off
+type Feature struct {
+	Name        string
+	Description string
+	On          bool
+	Set         bool // by an admin rather than -features
+}
+
+// FeaturesPage lists the features for admins to turn on and off
+type FeaturesPage struct {
+	Features []Feature
+}
+
+// featureFlags tells which features are on. -features gives the defaults,
+// what admins set is kept in a file of the data path and wins over them.
+type featureFlags struct {
+	sync.RWMutex
+	file     string
+	defaults map[string]bool
+	set      map[string]bool
+}
+
+// Parses a comma separated list of the features that are on
+func parseFeatures(names string) (map[string]bool, error) {
+	on := make(map[string]bool)
+	for _, f := range allFeatures {
+		on[f.Name] = false
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := on[name]; !ok {
+			return nil, fmt.Errorf("unknown feature \"%s\"", name)
+		}
+		on[name] = true
+	}
+	return on, nil
+}
+
+func newFeatureFlags(defaults map[string]bool) *featureFlags {
+	return &featureFlags{defaults: defaults, set: make(map[string]bool)}
+}
+
+// Loads what admins set from the file in the data path
+func (ff *featureFlags) load(dataPath string) error {
+	ff.Lock()
+	defer ff.Unlock()
+	ff.file = filepath.Join(dataPath, featuresFile)
+	data, err := ioutil.ReadFile(ff.file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &ff.set); err != nil {
+		return fmt.Errorf("%s: %s", ff.file, err)
+	}
+	return nil
+}
+
+func (ff *featureFlags) enabled(name string) bool {
+	ff.RLock()
+	defer ff.RUnlock()
+	if on, ok := ff.set[name]; ok {
+		return on
+	}
+	return ff.defaults[name]
+}
+
+// Returns all features with their state
+func (ff *featureFlags) list() []Feature {
+	ff.RLock()
+	defer ff.RUnlock()
+	features := make([]Feature, len(allFeatures))
+	for i, f := range allFeatures {
+		on, set := ff.set[f.Name]
+		if !set {
+			on = ff.defaults[f.Name]
+		}
+		f.On, f.Set = on, set
+		features[i] = f
+	}
+	return features
+}
+
+// Turns a feature on or off, or back to its default, and writes what
+// admins set to the file
+func (ff *featureFlags) update(name string, on, reset bool) error {
+	ff.Lock()
+	defer ff.Unlock()
+	if _, ok := ff.defaults[name]; !ok {
+		return fmt.Errorf("unknown feature \"%s\"", name)
+	}
+	if reset {
+		delete(ff.set, name)
+	} else {
+		ff.set[name] = on
+	}
+	data, err := json.MarshalIndent(ff.set, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ff.file, data, 0600)
+}
+
+// Wraps handlers of a feature, which are not found while it is off
+func (joki *joki) requireFeature(name string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !joki.features.enabled(name) {
+			http.Error(w, "The "+name+" feature is turned off", http.StatusNotFound)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// Lists the features and turns them on and off
+func (joki *joki) featuresHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		name := r.FormValue("name")
+		on := r.FormValue("on") == "1"
+		reset := r.FormValue("reset") != ""
+		if name == registrationFeature && on && !reset {
+			if err := joki.registrationUnavailable(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := joki.features.update(name, on, reset); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if name == mathFeature {
+			joki.renderCache.purge()
+		}
+		state := "off"
+		if joki.features.enabled(name) {
+			state = "on"
+		}
+		log.Printf("%s turned the %s feature %s", joki.author(r), name, state)
+		http.Redirect(w, r, FEATURES_PATH, http.StatusFound)
+		return
+	}
+	joki.renderTemplate(w, r, "features", &FeaturesPage{Features: joki.features.list()})
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	feedbackFile       = ".feedback.jsonl"
+	maxFeedbackComment = 2000
+	feedbackComments   = 5 // latest comments shown per page in the report
+)
+
+// Feedback is one answer of a reader to "Was this page helpful?"
+type Feedback struct {
+	Page    string    `json:"page"`
+	Helpful bool      `json:"helpful"`
+	Comment string    `json:"comment,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// PageFeedback sums up the feedback of a page
+type PageFeedback struct {
+	Title     string
+	Helpful   int
+	Unhelpful int
+	Comments  []Feedback
+}
+
+// Percent returns the share of readers that found the page helpful
+func (pf *PageFeedback) Percent() int {
+	return 100 * pf.Helpful / (pf.Helpful + pf.Unhelpful)
+}
+
+// Serializes appending to the feedback file
+var feedbackLock sync.Mutex
+
+// Appends feedback as a JSON line to the feedback file of the data path
+func (joki *joki) storeFeedback(f Feedback) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	feedbackLock.Lock()
+	defer feedbackLock.Unlock()
+	out, err := os.OpenFile(filepath.Join(joki.dataPath, feedbackFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(append(data, '\n')); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Reads all feedback and sums it up per page, least helpful pages first
+func (joki *joki) feedbackReport() ([]*PageFeedback, error) {
+	in, err := os.Open(filepath.Join(joki.dataPath, feedbackFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	pages := make(map[string]*PageFeedback)
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		var f Feedback
+		if json.Unmarshal(scanner.Bytes(), &f) != nil {
+			continue // skip lines cut off by a crash
+		}
+		pf := pages[f.Page]
+		if pf == nil {
+			pf = &PageFeedback{Title: f.Page}
+			pages[f.Page] = pf
+		}
+		if f.Helpful {
+			pf.Helpful++
+		} else {
+			pf.Unhelpful++
+		}
+		if f.Comment != "" {
+			pf.Comments = append([]Feedback{f}, pf.Comments...)
+			if len(pf.Comments) > feedbackComments {
+				pf.Comments = pf.Comments[:feedbackComments]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	report := make([]*PageFeedback, 0, len(pages))
+	for _, pf := range pages {
+		report = append(report, pf)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Percent() != report[j].Percent() {
+			return report[i].Percent() < report[j].Percent()
+		}
+		return report[i].Title < report[j].Title
+	})
+	return report, nil
+}
+
+// Records whether a reader found a page helpful
+func (joki *joki) feedbackHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if !joki.feedback {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Feedback requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if !joki.exists(title) {
+		http.NotFound(w, r)
+		return
+	}
+
+	comment := strings.TrimSpace(r.FormValue("comment"))
+	if len(comment) > maxFeedbackComment {
+		comment = comment[:maxFeedbackComment]
+	}
+	f := Feedback{Page: title, Helpful: r.FormValue("helpful") == "yes", Comment: comment, Created: time.Now()}
+	if err := joki.storeFeedback(f); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	message := "Not helpful"
+	if f.Helpful {
+		message = "Helpful"
+	}
+	if f.Comment != "" {
+		message += ": " + f.Comment
+	}
+	joki.notify(r, feedbackEvent, title, "", message)
+	http.Redirect(w, r, VIEW_PATH+title+"?feedback=thanks#feedback", http.StatusFound)
+}
+
+// Shows the feedback of readers per page
+func (joki *joki) feedbackReportHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := joki.feedbackReport()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	joki.renderTemplate(w, r, "feedback", report)
+}
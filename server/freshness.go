@@ -0,0 +1,33 @@
+package server
+
+import "time"
+
+const (
+	freshnessFresh = "fresh"
+	freshnessAging = "aging"
+	freshnessStale = "stale"
+)
+
+// Returns the last modification time of a page
+func (joki *joki) modTime(title string) (time.Time, error) {
+	return joki.store.ModTime(title)
+}
+
+// Classifies a modification time as fresh, aging or stale using the
+// configured thresholds in days
+func (joki *joki) freshness(modTime time.Time) string {
+	age := time.Since(modTime)
+	day := 24 * time.Hour
+	switch {
+	case age < time.Duration(joki.freshDays)*day:
+		return freshnessFresh
+	case age < time.Duration(joki.staleDays)*day:
+		return freshnessAging
+	default:
+		return freshnessStale
+	}
+}
+
+func validFreshness(f string) bool {
+	return f == freshnessFresh || f == freshnessAging || f == freshnessStale
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const anonymousAuthor = "anonymous"
+
+// gitRepo records every change of the data directory as a git commit
+type gitRepo struct {
+	sync.Mutex
+	repo *git.Repository
+}
+
+// Opens the git repository in the data directory or creates a new one
+func openGitRepo(dataPath string) (*gitRepo, error) {
+	repo, err := git.PlainOpen(dataPath)
+	if err == git.ErrRepositoryNotExists {
+		log.Printf("Creating git repository in %s", dataPath)
+		repo, err = git.PlainInit(dataPath, false)
+		if err != nil {
+			return nil, err
+		}
+		g := &gitRepo{repo: repo}
+		// The history, trash and attachment directories duplicate what git
+		// records anyway, unfinished uploads and posters are no content
+		ignored := []string{historyDir, trashDir, attachmentVersionsDir, blobsDir, chunkedUploadsDir, postersDir}
+		err = ioutil.WriteFile(filepath.Join(dataPath, ".gitignore"), []byte(strings.Join(ignored, "/\n")+"/\n"), 0600)
+		if err != nil {
+			return nil, err
+		}
+		return g, g.commit("gowiki", "Initialize wiki", []string{".gitignore"}, nil)
+	} else if err != nil {
+		return nil, err
+	}
+	return &gitRepo{repo: repo}, nil
+}
+
+// Commits the added/changed and removed files, given relative to the
+// data directory
+func (g *gitRepo) commit(author, message string, changed, removed []string) error {
+	g.Lock()
+	defer g.Unlock()
+
+	wt, err := g.repo.Worktree()
+	if err != nil {
+		return err
+	}
+	for _, f := range changed {
+		if _, err := wt.Add(f); err != nil {
+			return err
+		}
+	}
+	for _, f := range removed {
+		if _, err := wt.Remove(f); err != nil {
+			return err
+		}
+	}
+
+	_, err = wt.Commit(message, &git.CommitOptions{
+		Author: &object.Signature{Name: author, When: time.Now()},
+	})
+	return err
+}
+
+// Returns who is responsible for a change: the logged in user or the
+// author given in the edit form
+func (joki *joki) author(r *http.Request) string {
+	if user := joki.currentUser(r); user != "" {
+		return user
+	}
+	if author := r.FormValue("author"); author != "" {
+		return author
+	}
+	return anonymousAuthor
+}
+
+// Records a change of pages in git if the wiki is git backed and notifies
+// about it. The message falls back to defaultMessage if the form did not
+// provide one.
+func (joki *joki) commitChange(r *http.Request, defaultMessage string, changed, removed []string) error {
+	message := r.FormValue("message")
+	if message == "" {
+		message = defaultMessage
+	}
+	joki.notifyChange(r, message, changed, removed)
+	joki.logEdit(r, message, changed, removed)
+	if joki.git == nil {
+		return nil
+	}
+	return joki.git.commit(joki.author(r), message, changed, removed)
+}
+
+func pageFile(title string) string {
+	return title + extension
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+	gast "github.com/yuin/goldmark/ast"
+	gext "github.com/yuin/goldmark/extension"
+	gparser "github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	ghtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// The markdown engines pages can be rendered with
+const (
+	gomarkdownEngine = "gomarkdown"
+	goldmarkEngine   = "goldmark"
+)
+
+// MarkdownPage compares the output of both markdown engines
+type MarkdownPage struct {
+	Engine       string
+	Total        int
+	Differing    []string
+	Title        string
+	BodyA, BodyB template.HTML
+	Lines        []DiffLine
+}
+
+var kindWikiLink = gast.NewNodeKind("WikiLink")
+
+// wikiLink is an interlink like [Title] or a CamelCase word in the goldmark
+// syntax tree
+type wikiLink struct {
+	gast.BaseInline
+	Title string
+	word  bool
+	ref   bool   // an external reference like RFC 9110
+	from  string // the page the link is on
+}
+
+// Key of the title of the rendered page in the parser context, so converters
+// can be shared by all pages
+var fromKey = gparser.NewContextKey()
+
+// Returns the title of the page being parsed
+func parsedPage(pc gparser.Context) string {
+	from, _ := pc.Get(fromKey).(string)
+	return from
+}
+
+func (n *wikiLink) Kind() gast.NodeKind { return kindWikiLink }
+
+func (n *wikiLink) Dump(source []byte, level int) {
+	gast.DumpHelper(n, source, level, map[string]string{"Title": n.Title}, nil)
+}
+
+var wikiLinkRegex = regexp.MustCompile(`^\[` + titlePattern + `\]`)
+var wikiRefRegex = regexp.MustCompile(`^` + refPattern)
+
+// wikiLinkParser takes [Title] and resolvable references like [RFC 9110]
+// before goldmark's link parser sees them, unless they are followed by a link
+// destination or are a defined link reference
+type wikiLinkParser struct{}
+
+func (wikiLinkParser) Trigger() []byte { return []byte{'['} }
+
+func (wikiLinkParser) Parse(parent gast.Node, block text.Reader, pc gparser.Context) gast.Node {
+	line, _ := block.PeekLine()
+	m := wikiLinkRegex.Find(line)
+	ref := false
+	if m == nil {
+		// references need a resolver, others stay text
+		if m = wikiRefRegex.Find(line); m == nil {
+			return nil
+		}
+		if _, ok := externalLink(string(m[1 : len(m)-1])); !ok {
+			return nil
+		}
+		ref = true
+	}
+	label := m[1 : len(m)-1]
+	if rest := line[len(m):]; len(rest) > 0 && (rest[0] == '(' || rest[0] == '[') {
+		return nil
+	}
+	if _, ok := pc.Reference(util.ToLinkReference(label)); ok {
+		return nil
+	}
+	block.Advance(len(m))
+	return &wikiLink{Title: string(label), ref: ref, from: parsedPage(pc)}
+}
+
+var wikiWordRegex = regexp.MustCompile(`^!?` + wikiWordPattern)
+
+// wikiWordParser turns CamelCase words into interlinks, and drops the ! of
+// escaped words like !CamelCase
+type wikiWordParser struct{}
+
+func (wikiWordParser) Trigger() []byte {
+	return []byte("!ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+}
+
+func (wikiWordParser) Parse(parent gast.Node, block text.Reader, pc gparser.Context) gast.Node {
+	if prev := block.PrecendingCharacter(); unicode.IsLetter(prev) || unicode.IsDigit(prev) {
+		return nil
+	}
+	line, seg := block.PeekLine()
+	m := wikiWordRegex.Find(line)
+	if m == nil {
+		return nil
+	}
+	block.Advance(len(m))
+	if m[0] == '!' {
+		return gast.NewTextSegment(text.NewSegment(seg.Start+1, seg.Start+len(m)))
+	}
+	return &wikiLink{Title: string(m), word: true, from: parsedPage(pc)}
+}
+
+// wikiLinkRenderer renders interlinks like gomarkdown does
+type wikiLinkRenderer struct {
+	joki *joki
+}
+
+func (r *wikiLinkRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindWikiLink, r.render)
+}
+
+func (r *wikiLinkRenderer) render(w util.BufWriter, source []byte, n gast.Node, entering bool) (gast.WalkStatus, error) {
+	if !entering {
+		return gast.WalkContinue, nil
+	}
+	link := n.(*wikiLink)
+	if link.word {
+		// words in the text of a link stay words
+		for p := n.Parent(); p != nil; p = p.Parent() {
+			if p.Kind() == gast.KindLink {
+				w.WriteString(link.Title)
+				return gast.WalkContinue, nil
+			}
+		}
+	}
+	if link.ref {
+		html, _ := externalLink(link.Title)
+		w.WriteString(html)
+		return gast.WalkContinue, nil
+	}
+	w.WriteString(r.joki.interlink(link.from, link.Title))
+	return gast.WalkContinue, nil
+}
+
+// Extensions only goldmark knows
+var goldmarkOnly = map[string]bool{"task-lists": true}
+
+var goldmarkExtensions = map[string]goldmark.Extender{
+	"tables":           gext.Table,
+	"autolink":         gext.Linkify,
+	"strikethrough":    gext.Strikethrough,
+	"definition-lists": gext.DefinitionList,
+	"footnotes":        gext.Footnote,
+	"task-lists":       gext.TaskList,
+}
+
+// Renders the markdown of page title to html with goldmark
+func (joki *joki) renderGoldmark(title string, content []byte, ro renderOptions) []byte {
+	pc := gparser.NewContext()
+	pc.Set(fromKey, title)
+	var buf bytes.Buffer
+	if err := joki.goldmarkConverter(ro).Convert(content, &buf, gparser.WithContext(pc)); err != nil {
+		return []byte("<p>" + template.HTMLEscapeString(err.Error()) + "</p>")
+	}
+	return buf.Bytes()
+}
+
+// Returns the goldmark converter for the options. Converters are made once
+// per set of options and shared, they are safe for concurrent use. Raw html
+// is kept like gomarkdown does, it is sanitized afterwards anyway.
+func (joki *joki) goldmarkConverter(ro renderOptions) goldmark.Markdown {
+	key := ro.key()
+	if md, ok := joki.goldmarks.Load(key); ok {
+		return md.(goldmark.Markdown)
+	}
+	names := make([]string, 0, len(ro.extensions))
+	for name := range ro.extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names) // extensions are applied in a stable order
+	var exts []goldmark.Extender
+	for _, name := range names {
+		if ext, ok := goldmarkExtensions[name]; ok {
+			exts = append(exts, ext)
+		}
+	}
+	if ro.smartypants {
+		exts = append(exts, gext.Typographer)
+	}
+	parsers := []util.PrioritizedValue{util.Prioritized(wikiLinkParser{}, 199)}
+	if ro.wikiWords {
+		parsers = append(parsers, util.Prioritized(wikiWordParser{}, 199))
+	}
+	rendererOptions := []renderer.Option{
+		ghtml.WithUnsafe(),
+		renderer.WithNodeRenderers(
+			util.Prioritized(&wikiLinkRenderer{joki}, 500),
+			util.Prioritized(&codeRenderer{joki}, 500))}
+	if ro.hardWraps {
+		rendererOptions = append(rendererOptions, ghtml.WithHardWraps())
+	}
+	md := goldmark.New(
+		goldmark.WithExtensions(exts...),
+		goldmark.WithParserOptions(
+			gparser.WithAutoHeadingID(),
+			gparser.WithInlineParsers(parsers...)),
+		goldmark.WithRendererOptions(rendererOptions...))
+	stored, _ := joki.goldmarks.LoadOrStore(key, md)
+	return stored.(goldmark.Markdown)
+}
+
+var betweenTags = regexp.MustCompile(`>\s+<`)
+
+// Puts every tag of rendered html on its own line, so the output of both
+// engines can be compared regardless of their whitespace
+func normalizeHTML(b []byte) string {
+	s := betweenTags.ReplaceAllString(strings.TrimSpace(string(b)), "><")
+	return strings.Replace(s, "><", ">\n<", -1)
+}
+
+// Lists the pages both markdown engines render differently, or shows the
+// output of both for a page given by title
+func (joki *joki) markdownHandler(w http.ResponseWriter, r *http.Request) {
+	mp := &MarkdownPage{Engine: joki.markdown, Title: r.FormValue("title")}
+
+	if mp.Title != "" {
+		p, err := joki.loadPage(mp.Title)
+		if err != nil {
+			http.Error(w, err.Error(), pageErrorStatus(err))
+			return
+		}
+		a := joki.sanitize(joki.renderWith(gomarkdownEngine, p.Title, p.Body))
+		b := joki.sanitize(joki.renderWith(goldmarkEngine, p.Title, p.Body))
+		mp.BodyA, mp.BodyB = template.HTML(a), template.HTML(b)
+		mp.Lines = diffLines(normalizeHTML(a), normalizeHTML(b))
+		joki.renderTemplate(w, r, "markdown", mp)
+		return
+	}
+
+	titles, err := joki.listPages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	mp.Total = len(titles)
+	for _, title := range titles {
+		p, err := joki.loadPage(title)
+		if err != nil {
+			continue
+		}
+		a := joki.sanitize(joki.renderWith(gomarkdownEngine, p.Title, p.Body))
+		b := joki.sanitize(joki.renderWith(goldmarkEngine, p.Title, p.Body))
+		if normalizeHTML(a) != normalizeHTML(b) {
+			mp.Differing = append(mp.Differing, title)
+		}
+	}
+	joki.renderTemplate(w, r, "markdown", mp)
+}
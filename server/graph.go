@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// GraphNode is a page in the link graph
+type GraphNode struct {
+	ID     string `json:"id"`
+	Exists bool   `json:"exists"`
+}
+
+// GraphEdge is an interlink from one page to another
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Graph is the link graph of the whole wiki
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphPage is the graph view with the filters it offers
+type GraphPage struct {
+	Namespace  string
+	Tag        string
+	Namespaces []string
+	Tags       []string
+}
+
+// Returns the titles of all pages a markdown document links to.
+// Only text nodes are searched, just like insertLinks does while rendering,
+// so [Links] inside code are ignored.
+func (joki *joki) pageLinks(content []byte) []string {
+	var links []string
+	seen := make(map[string]bool)
+	meta, content := frontMatter(content)
+	wikiWords := joki.wikiWordsEnabled(meta)
+	doc := parser.NewWithExtensions(mdExt).Parse(content)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if _, ok := node.(*ast.Text); !ok || !entering {
+			return ast.GoToNext
+		}
+		re := linkRegex
+		if _, inLink := node.GetParent().(*ast.Link); wikiWords && !inLink {
+			re = linkOrWordRegex
+		}
+		for _, m := range re.FindAll(node.AsLeaf().Literal, -1) {
+			if m[0] == '!' || m[0] == '[' && !validTitle.Match(m[1:len(m)-1]) {
+				continue // escaped word or external reference
+			}
+			title := string(bytes.Trim(m, "[]"))
+			if !seen[title] {
+				seen[title] = true
+				links = append(links, title)
+			}
+		}
+		return ast.GoToNext
+	})
+	return links
+}
+
+// Builds the link graph from the link index, of the pages the request may
+// see in namespace ns with tag, or of all of them if ns or tag are empty
+func (joki *joki) buildGraph(r *http.Request, ns, tag string) (*Graph, error) {
+	pages, err := joki.listPages()
+	if err != nil {
+		return nil, err
+	}
+	var selected []string
+	for _, title := range joki.visiblePages(r, pages) {
+		if (ns == "" || strings.HasPrefix(title, ns+"/")) && (tag == "" || joki.meta.tagged(title, tag)) {
+			selected = append(selected, title)
+		}
+	}
+
+	graph := &Graph{Nodes: make([]GraphNode, 0, len(selected)), Edges: []GraphEdge{}}
+	known := make(map[string]bool)
+	for _, title := range selected {
+		known[title] = true
+		graph.Nodes = append(graph.Nodes, GraphNode{ID: title, Exists: true})
+	}
+
+	for _, title := range selected {
+		for _, link := range joki.links.get(title) {
+			link = joki.resolveLink(title, link)
+			if !known[link] && joki.exists(link) {
+				continue // to a page filtered out or the request may not see
+			}
+			if !known[link] {
+				// Dangling links show up as missing pages
+				known[link] = true
+				graph.Nodes = append(graph.Nodes, GraphNode{ID: link})
+			}
+			graph.Edges = append(graph.Edges, GraphEdge{From: title, To: link})
+		}
+	}
+	return graph, nil
+}
+
+// Shows the graph view, filtered by the namespace ns and the tag of the
+// query
+func (joki *joki) graphHandler(w http.ResponseWriter, r *http.Request) {
+	pages, err := joki.listPages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pages = joki.visiblePages(r, pages)
+	page := &GraphPage{
+		Namespace:  strings.Trim(r.FormValue("ns"), "/"),
+		Tag:        strings.ToLower(strings.TrimSpace(r.FormValue("tag"))),
+		Namespaces: namespaces(pages),
+	}
+	seen := make(map[string]bool)
+	for _, title := range pages {
+		for _, tag := range joki.meta.get(title).Tags {
+			if !seen[tag] {
+				seen[tag] = true
+				page.Tags = append(page.Tags, tag)
+			}
+		}
+	}
+	sort.Strings(page.Tags)
+	joki.renderTemplate(w, r, "graph", page)
+}
+
+// Serves the link graph as JSON for the graph view, filtered like it
+func (joki *joki) graphJSONHandler(w http.ResponseWriter, r *http.Request) {
+	ns := strings.Trim(r.FormValue("ns"), "/")
+	tag := strings.ToLower(strings.TrimSpace(r.FormValue("tag")))
+	graph, err := joki.buildGraph(r, ns, tag)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(graph); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
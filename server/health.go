@@ -0,0 +1,116 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+)
+
+const (
+	minPageWords = 50
+	maxPageWords = 5000
+)
+
+// The checks every page is scored on
+const (
+	checkHeadings = "Has headings"
+	checkFresh    = "Not stale"
+	checkLinks    = "No broken links"
+	checkLength   = "Reasonable length"
+	checkOwner    = "Has owner"
+)
+
+var healthChecks = []string{checkHeadings, checkFresh, checkLinks, checkLength, checkOwner}
+
+// PageHealth is the quality score of a single page
+type PageHealth struct {
+	Title       string
+	Score       int // percentage of passed checks
+	Failed      []string
+	BrokenLinks []string
+	Words       int
+}
+
+// CheckSummary counts the pages failing a check
+type CheckSummary struct {
+	Check  string
+	Failed int
+}
+
+// HealthReport aggregates the scores of all pages
+type HealthReport struct {
+	Score  int
+	Checks []CheckSummary
+	Pages  []PageHealth
+}
+
+// Scores a page on the health checks
+func (joki *joki) pageHealth(title string) (PageHealth, error) {
+	h := PageHealth{Title: title}
+	p, err := joki.loadPage(title)
+	if err != nil {
+		return h, err
+	}
+	modTime, err := joki.modTime(title)
+	if err != nil {
+		return h, err
+	}
+
+	for _, link := range joki.pageLinks(p.Body) {
+		if target := joki.resolveLink(title, link); !joki.exists(target) {
+			h.BrokenLinks = append(h.BrokenLinks, target)
+		}
+	}
+	h.Words = len(tokenize(string(p.Body)))
+
+	passed := map[string]bool{
+		checkHeadings: len(pageHeadings(p.Body)) > 0,
+		checkFresh:    joki.freshness(modTime) != freshnessStale,
+		checkLinks:    len(h.BrokenLinks) == 0,
+		checkLength:   h.Words >= minPageWords && h.Words <= maxPageWords,
+		checkOwner:    pageMeta(p.Body).Owner != "",
+	}
+	for _, check := range healthChecks {
+		if !passed[check] {
+			h.Failed = append(h.Failed, check)
+		}
+	}
+	h.Score = 100 * (len(healthChecks) - len(h.Failed)) / len(healthChecks)
+	return h, nil
+}
+
+// Shows the documentation health of the wiki, worst pages first
+func (joki *joki) healthHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := joki.listPages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	titles = joki.visiblePages(r, titles)
+
+	report := &HealthReport{}
+	failed := make(map[string]int)
+	total := 0
+	for _, title := range titles {
+		h, err := joki.pageHealth(title)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, check := range h.Failed {
+			failed[check]++
+		}
+		total += h.Score
+		report.Pages = append(report.Pages, h)
+	}
+	if len(titles) > 0 {
+		report.Score = total / len(titles)
+	}
+	for _, check := range healthChecks {
+		report.Checks = append(report.Checks, CheckSummary{Check: check, Failed: failed[check]})
+	}
+	sort.SliceStable(report.Pages, func(i, j int) bool {
+		return report.Pages[i].Score < report.Pages[j].Score
+	})
+
+	joki.renderTemplate(w, r, "health", report)
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/gomarkdown/markdown/ast"
+	mdhtml "github.com/gomarkdown/markdown/html"
+	gast "github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// Fenced code blocks are highlighted on the server by chroma. Tokens only get
+// class names like "k" for keywords, the colors come from the stylesheet of
+// the code style, so the sanitizer does not need to allow inline styles.
+
+const noHighlighting = "none"
+
+var codeFormatter = chromahtml.New(chromahtml.WithClasses(true), chromahtml.PreventSurroundingPre(true))
+
+// Matches the class names chroma gives to tokens
+var chromaClasses = func() *regexp.Regexp {
+	names := make([]string, 0, len(chroma.StandardTypes))
+	for _, name := range chroma.StandardTypes {
+		if name != "" {
+			names = append(names, regexp.QuoteMeta(name))
+		}
+	}
+	sort.Strings(names)
+	return regexp.MustCompile("^(?:" + strings.Join(names, "|") + ")$")
+}()
+
+var chromaPre = regexp.MustCompile("^chroma$")
+
+// Returns the language of a fenced code block from its info string
+func codeLanguage(info string) string {
+	if fields := strings.Fields(info); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// Returns the html of a code block in lang, which is highlighted unless the
+// language is unknown or highlighting is disabled
+func (joki *joki) codeBlock(lang string, code []byte) []byte {
+	class := ""
+	if langTags.MatchString("language-" + lang) {
+		class = ` class="language-` + lang + `"`
+	}
+	if highlighted, ok := joki.highlight(lang, code); ok {
+		return []byte(`<pre class="chroma"><code` + class + `>` + string(highlighted) + "</code></pre>\n")
+	}
+	return []byte("<pre><code" + class + ">" + html.EscapeString(string(code)) + "</code></pre>\n")
+}
+
+// Highlights code in lang as html with chroma
+func (joki *joki) highlight(lang string, code []byte) ([]byte, bool) {
+	if joki.codeStyle == noHighlighting || lang == "" {
+		return nil, false
+	}
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		return nil, false
+	}
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, string(code))
+	if err != nil {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	if err := codeFormatter.Format(&buf, styles.Get(joki.codeStyle), tokens); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// Wraps a gomarkdown render hook so fenced code blocks are highlighted
+func (joki *joki) highlightCode(next mdhtml.RenderNodeFunc) mdhtml.RenderNodeFunc {
+	return func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+		code, ok := node.(*ast.CodeBlock)
+		if !ok || !code.IsFenced {
+			return next(w, node, entering)
+		}
+		w.Write(joki.codeBlock(codeLanguage(string(code.Info)), code.Literal))
+		return ast.GoToNext, true
+	}
+}
+
+// codeRenderer highlights fenced code blocks rendered by goldmark
+type codeRenderer struct {
+	joki *joki
+}
+
+func (r *codeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(gast.KindFencedCodeBlock, r.render)
+}
+
+func (r *codeRenderer) render(w util.BufWriter, source []byte, n gast.Node, entering bool) (gast.WalkStatus, error) {
+	if !entering {
+		return gast.WalkContinue, nil
+	}
+	block := n.(*gast.FencedCodeBlock)
+	var code bytes.Buffer
+	lines := block.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(source))
+	}
+	w.Write(r.joki.codeBlock(codeLanguage(string(block.Language(source))), code.Bytes()))
+	return gast.WalkContinue, nil
+}
+
+// Writes the stylesheet of the code style
+func (joki *joki) writeHighlightCSS(w io.Writer) error {
+	if joki.codeStyle == noHighlighting {
+		return nil
+	}
+	return codeFormatter.WriteCSS(w, styles.Get(joki.codeStyle))
+}
+
+// Serves the stylesheet of the code style
+func (joki *joki) highlightCSSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	if err := joki.writeHighlightCSS(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Writes the stylesheet of the code style to file for a static copy
+func (joki *joki) exportHighlightCSS(file string) error {
+	var buf bytes.Buffer
+	if err := joki.writeHighlightCSS(&buf); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, buf.Bytes(), 0644)
+}
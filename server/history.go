@@ -0,0 +1,198 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	historyDir         = ".history"
+	revisionTimeFormat = "20060102T150405.000000000Z"
+	currentRevision    = "current"
+)
+
+var validRevision = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}\.[0-9]{9}Z$`)
+
+// Revision is a prior version of a page
+type Revision struct {
+	ID   string
+	Time time.Time
+	Size int64
+}
+
+// HistoryPage lists the revisions of a page
+type HistoryPage struct {
+	Title     string
+	Revisions []Revision
+}
+
+// DiffPage shows the differences between two versions of a page
+type DiffPage struct {
+	Title string
+	A     string
+	B     string
+	Lines []DiffLine
+}
+
+func (joki *joki) historyPath(title string) string {
+	return filepath.Join(joki.dataPath, historyDir, title)
+}
+
+// Stores the current version of a page in its history before it gets
+// overwritten. The revision is named after the time it was last saved.
+func (joki *joki) archive(title string) error {
+	modTime, err := joki.store.ModTime(title)
+	if os.IsNotExist(err) {
+		return nil // nothing to archive for new pages
+	} else if err != nil {
+		return err
+	}
+
+	body, err := joki.store.Load(title)
+	if err != nil {
+		return err
+	}
+
+	dir := joki.historyPath(title)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	revFile := filepath.Join(dir, modTime.UTC().Format(revisionTimeFormat)+extension)
+	if _, err := os.Stat(revFile); err == nil {
+		return nil // already archived
+	}
+	return ioutil.WriteFile(revFile, body, 0600)
+}
+
+// Moves the history of a page along with a renamed page
+func (joki *joki) moveHistory(oldTitle, newTitle string) error {
+	return moveDir(joki.historyPath(oldTitle), joki.historyPath(newTitle))
+}
+
+// Lists the revisions of a page, newest first
+func (joki *joki) revisions(title string) ([]Revision, error) {
+	files, err := ioutil.ReadDir(joki.historyPath(title))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	revs := make([]Revision, 0, len(files))
+	for _, f := range files {
+		id := f.Name()[:len(f.Name())-len(filepath.Ext(f.Name()))]
+		t, err := time.Parse(revisionTimeFormat, id)
+		if f.IsDir() || err != nil {
+			continue
+		}
+		revs = append(revs, Revision{ID: id, Time: t, Size: f.Size()})
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Time.After(revs[j].Time) })
+	return revs, nil
+}
+
+// Loads the content of a revision, or the current page for "current"
+func (joki *joki) loadRevision(title, id string) ([]byte, error) {
+	if id == currentRevision {
+		p, err := joki.loadPage(title)
+		if err != nil {
+			return nil, err
+		}
+		return p.Body, nil
+	}
+	if !validRevision.MatchString(id) {
+		return nil, fmt.Errorf("revision \"%s\" is invalid", id)
+	}
+	return ioutil.ReadFile(filepath.Join(joki.historyPath(title), id+extension))
+}
+
+// Lists the revisions of a page
+func (joki *joki) historyHandler(w http.ResponseWriter, r *http.Request, title string) {
+	revs, err := joki.revisions(title)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	joki.renderTemplate(w, r, "history", &HistoryPage{Title: title, Revisions: revs})
+}
+
+// Shows a line diff between the revisions a and b of a page
+func (joki *joki) diffHandler(w http.ResponseWriter, r *http.Request, title string) {
+	a, b := r.FormValue("a"), r.FormValue("b")
+	if b == "" {
+		b = currentRevision
+	}
+
+	aBody, err := joki.loadRevision(title, a)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	bBody, err := joki.loadRevision(title, b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	joki.renderTemplate(w, r, "diff", &DiffPage{
+		Title: title,
+		A:     a,
+		B:     b,
+		Lines: diffLines(string(aBody), string(bBody)),
+	})
+}
+
+// Restores a revision by saving it as the current version
+func (joki *joki) revertHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Reverting requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := joki.loadRevision(title, r.FormValue("rev"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if joki.meta.get(title).Generated {
+		http.Error(w, generatedError(title), http.StatusForbidden)
+		return
+	}
+	if err := joki.checkEditLock(r, title); err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+	message := "Revert " + title + " to " + r.FormValue("rev")
+	if held, err := joki.holdForModeration(r, HeldEdit{Title: title, Body: string(body), Message: message}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if held {
+		http.Redirect(w, r, VIEW_PATH+title, http.StatusFound)
+		return
+	}
+	if err := joki.throttle(r, title); err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+
+	if _, err := joki.storePage(title, body, joki.currentUser(r)); err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+	log.Printf("Reverted %s to revision %s", title, r.FormValue("rev"))
+	err = joki.commitChange(r, message, []string{pageFile(title)}, nil)
+	if err != nil {
+		http.Error(w, "Page reverted but not committed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, VIEW_PATH+title, http.StatusFound)
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const holdsFile = ".holds.json"
+
+// Hold is a legal hold placed on a page by an admin. A page on hold and
+// its history cannot be changed, renamed or deleted until it is released.
+type Hold struct {
+	Title  string    `json:"title"`
+	Reason string    `json:"reason"`
+	By     string    `json:"by"`
+	Since  time.Time `json:"since"`
+}
+
+// HoldsPage lists the pages on hold
+type HoldsPage struct {
+	Holds []Hold
+	Error string
+}
+
+// holdError reports that a change was refused because of a hold
+type holdError struct {
+	title string
+}
+
+func (e *holdError) Error() string {
+	return e.title + " is on legal hold and cannot be changed"
+}
+
+// holdStore keeps the holds in memory and in a file of the data path
+type holdStore struct {
+	sync.RWMutex
+	file  string
+	holds map[string]Hold
+}
+
+func loadHolds(dataPath string) (*holdStore, error) {
+	s := &holdStore{file: filepath.Join(dataPath, holdsFile), holds: make(map[string]Hold)}
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var holds []Hold
+	if err := json.Unmarshal(data, &holds); err != nil {
+		return nil, fmt.Errorf("%s: %s", s.file, err)
+	}
+	for _, h := range holds {
+		s.holds[h.Title] = h
+	}
+	return s, nil
+}
+
+// Returns the holds ordered by title
+func (s *holdStore) list() []Hold {
+	s.RLock()
+	defer s.RUnlock()
+	holds := make([]Hold, 0, len(s.holds))
+	for _, h := range s.holds {
+		holds = append(holds, h)
+	}
+	sort.Slice(holds, func(i, j int) bool { return holds[i].Title < holds[j].Title })
+	return holds
+}
+
+func (s *holdStore) held(title string) bool {
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.holds[title]
+	return ok
+}
+
+// Returns an error if title is on hold
+func (s *holdStore) check(title string) error {
+	if s.held(title) {
+		return &holdError{title}
+	}
+	return nil
+}
+
+// Places or releases a hold and writes all holds to the file
+func (s *holdStore) update(fn func(holds map[string]Hold)) error {
+	s.Lock()
+	defer s.Unlock()
+	fn(s.holds)
+	holds := make([]Hold, 0, len(s.holds))
+	for _, h := range s.holds {
+		holds = append(holds, h)
+	}
+	data, err := json.MarshalIndent(holds, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.file, data, 0600)
+}
+
+// Lists the pages on hold and places or releases holds
+func (joki *joki) holdsHandler(w http.ResponseWriter, r *http.Request) {
+	hp := &HoldsPage{}
+	if r.Method == http.MethodPost {
+		title := strings.TrimSpace(r.FormValue("title"))
+		user := joki.author(r)
+		var err error
+		action := "placed"
+		switch {
+		case r.FormValue("release") != "":
+			action = "released"
+			err = joki.holds.update(func(holds map[string]Hold) { delete(holds, title) })
+		case !validPageTitle(title):
+			hp.Error = "Title name is invalid: " + title
+		default:
+			hold := Hold{Title: title, Reason: strings.TrimSpace(r.FormValue("reason")), By: user, Since: time.Now()}
+			err = joki.holds.update(func(holds map[string]Hold) { holds[title] = hold })
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if hp.Error == "" {
+			log.Printf("%s %s the legal hold on %s", user, action, title)
+			http.Redirect(w, r, HOLDS_PATH, http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	hp.Holds = joki.holds.list()
+	joki.renderTemplate(w, r, "holds", hp)
+}
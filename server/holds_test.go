@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// Returns a multipart form uploading a file name with content
+func uploadForm(t *testing.T, name, content string) (string, string) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return form.FormDataContentType(), body.String()
+}
+
+func TestHolds(t *testing.T) {
+	tw := newTestWiki(t, nil)
+	tw.store("Held", "# Held")
+	tw.store("Free", "# Free")
+	if w := tw.request(http.MethodPost, HOLDS_PATH, "bob", url.Values{"title": {"Held"}, "reason": {"Case 42"}}); w.Code != http.StatusFound {
+		t.Fatalf("placing a hold: status %d", w.Code)
+	}
+	if w := tw.request(http.MethodPost, HOLDS_PATH, "ann", url.Values{"title": {"Free"}}); w.Code != http.StatusForbidden {
+		t.Errorf("placing a hold as a user: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	uploadType, upload := uploadForm(t, "notes.txt", "notes")
+
+	tests := []struct {
+		what         string
+		send         func(title string) int
+		held, others int
+	}{
+		{"saving", func(title string) int {
+			return tw.save(title, "# Changed", "ann").Code
+		}, http.StatusLocked, http.StatusFound},
+		{"saving through the API", func(title string) int {
+			return tw.send(http.MethodPut, API_PAGES_PATH+"/"+title, "ann", "text/markdown", "# Changed").Code
+		}, http.StatusLocked, http.StatusOK},
+		{"uploading", func(title string) int {
+			return tw.send(http.MethodPost, UPLOAD_PATH+title, "ann", uploadType, upload).Code
+		}, http.StatusLocked, http.StatusFound},
+		{"deleting through the API", func(title string) int {
+			return tw.request(http.MethodDelete, API_PAGES_PATH+"/"+title, "ann", nil).Code
+		}, http.StatusLocked, http.StatusNoContent},
+		{"deleting", func(title string) int {
+			if !tw.joki.exists(title) {
+				tw.store(title, "# Back") // deleted through the API
+			}
+			return tw.request(http.MethodPost, DELETE_PATH+title, "ann", url.Values{"Confirmed": {"True"}}).Code
+		}, http.StatusLocked, http.StatusFound},
+	}
+	for _, tt := range tests {
+		if status := tt.send("Held"); status != tt.held {
+			t.Errorf("%s a held page: status %d, want %d", tt.what, status, tt.held)
+		}
+		if status := tt.send("Free"); status != tt.others {
+			t.Errorf("%s another page: status %d, want %d", tt.what, status, tt.others)
+		}
+	}
+	if tw.body("Held") != "# Held" {
+		t.Errorf("the held page changed to %q", tw.body("Held"))
+	}
+	if _, err := tw.joki.storePage("Held", []byte("# Stored"), ""); err == nil {
+		t.Error("storing a held page succeeded")
+	}
+
+	if w := tw.request(http.MethodPost, HOLDS_PATH, "bob", url.Values{"title": {"Held"}, "release": {"1"}}); w.Code != http.StatusFound {
+		t.Fatalf("releasing the hold: status %d", w.Code)
+	}
+	if w := tw.save("Held", "# Changed", "ann"); w.Code != http.StatusFound || tw.body("Held") != "# Changed" {
+		t.Errorf("saving a released page: status %d, body %q", w.Code, tw.body("Held"))
+	}
+}
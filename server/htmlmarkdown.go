@@ -0,0 +1,280 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Elements that start a block of their own in markdown
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"div": true, "dl": true, "footer": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true,
+	"main": true, "nav": true, "ol": true, "p": true, "pre": true,
+	"section": true, "table": true, "ul": true,
+}
+
+// Elements whose content is never pasted
+var skippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "template": true, "title": true,
+}
+
+var markdownSpecial = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`)
+var blankLines = regexp.MustCompile(`\n{3,}`)
+var whitespace = regexp.MustCompile(`\s+`)
+
+// Converts rich html as pasted from Confluence, Google Docs or web pages to
+// markdown. Formatting markdown has no syntax for is dropped.
+func htmlToMarkdown(r io.Reader) (string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", err
+	}
+	return nodeMarkdown(doc), nil
+}
+
+// Converts the content of an html node to markdown
+func nodeMarkdown(n *html.Node) string {
+	md := strings.Join(markdownBlocks(n), "\n\n")
+	return strings.TrimSpace(blankLines.ReplaceAllString(md, "\n\n"))
+}
+
+// Returns whether n contains an element starting a block
+func hasBlock(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (blockElements[c.Data] || hasBlock(c)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Converts the children of n to markdown blocks. Inline content between
+// blocks becomes a paragraph; inline elements wrapping blocks, like the <b>
+// Google Docs puts around everything, are looked through.
+func markdownBlocks(n *html.Node) []string {
+	var blocks []string
+	var para strings.Builder
+	flush := func() {
+		if text := strings.TrimSpace(para.String()); text != "" {
+			blocks = append(blocks, text)
+		}
+		para.Reset()
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.ElementNode && skippedElements[c.Data]:
+		case c.Type == html.ElementNode && blockElements[c.Data]:
+			flush()
+			if block := markdownBlock(c); block != "" {
+				blocks = append(blocks, block)
+			}
+		case c.Type == html.ElementNode && hasBlock(c):
+			flush()
+			blocks = append(blocks, markdownBlocks(c)...)
+		default:
+			para.WriteString(markdownInline(c))
+		}
+	}
+	flush()
+	return blocks
+}
+
+// Converts a block element to markdown
+func markdownBlock(n *html.Node) string {
+	switch n.Data {
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level := int(n.Data[1] - '0')
+		return strings.Repeat("#", level) + " " + strings.TrimSpace(inlineChildren(n))
+	case "blockquote":
+		lines := strings.Split(strings.Join(markdownBlocks(n), "\n\n"), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("> "+line, " ")
+		}
+		return strings.Join(lines, "\n")
+	case "pre":
+		code := strings.TrimRight(rawText(n), "\n")
+		return "```\n" + code + "\n```"
+	case "ul", "ol":
+		return markdownList(n)
+	case "li":
+		return "- " + indent(strings.Join(markdownBlocks(n), "\n"), "  ")
+	case "table":
+		return strings.TrimSpace(markdownTable(tableRows(n)))
+	case "hr":
+		return "---"
+	}
+	return strings.Join(markdownBlocks(n), "\n\n")
+}
+
+// Converts a list with its nested lists
+func markdownList(n *html.Node) string {
+	number := 1
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+		number = start
+	}
+	var items []string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data != "li" {
+			continue
+		}
+		marker := "- "
+		if n.Data == "ol" {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		content := strings.Join(markdownBlocks(c), "\n")
+		items = append(items, marker+indent(content, strings.Repeat(" ", len(marker))))
+	}
+	return strings.Join(items, "\n")
+}
+
+// Indents all lines but the first, for the content of list items
+func indent(text, prefix string) string {
+	return strings.Replace(text, "\n", "\n"+prefix, -1)
+}
+
+// Converts inline content to markdown
+func markdownInline(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return markdownSpecial.Replace(whitespace.ReplaceAllString(n.Data, " "))
+	}
+	if n.Type != html.ElementNode || skippedElements[n.Data] {
+		return ""
+	}
+
+	switch n.Data {
+	case "br":
+		return "\\\n"
+	case "img":
+		return "![" + markdownSpecial.Replace(attr(n, "alt")) + "](" + attr(n, "src") + ")"
+	case "code", "kbd", "samp":
+		return "`" + strings.Join(strings.Fields(rawText(n)), " ") + "`"
+	case "a":
+		text, href := inlineChildren(n), attr(n, "href")
+		if href == "" || strings.HasPrefix(href, "javascript:") {
+			return text
+		}
+		return emphasize("[", strings.TrimSpace(text), "]("+href+")", text)
+	case "strong", "b":
+		if fontWeight(n) == "normal" {
+			return inlineChildren(n)
+		}
+		return emphasize("**", strings.TrimSpace(inlineChildren(n)), "**", inlineChildren(n))
+	case "em", "i", "cite":
+		return emphasize("*", strings.TrimSpace(inlineChildren(n)), "*", inlineChildren(n))
+	case "s", "del", "strike":
+		return emphasize("~~", strings.TrimSpace(inlineChildren(n)), "~~", inlineChildren(n))
+	case "span":
+		// Google Docs formats with styles only
+		text := inlineChildren(n)
+		style := strings.Replace(strings.ToLower(attr(n, "style")), " ", "", -1)
+		if strings.Contains(style, "line-through") {
+			text = emphasize("~~", strings.TrimSpace(text), "~~", text)
+		}
+		if strings.Contains(style, "font-style:italic") {
+			text = emphasize("*", strings.TrimSpace(text), "*", text)
+		}
+		if fontWeight(n) == "bold" {
+			text = emphasize("**", strings.TrimSpace(text), "**", text)
+		}
+		return text
+	}
+	if blockElements[n.Data] {
+		return " " + inlineChildren(n) + " "
+	}
+	return inlineChildren(n)
+}
+
+func inlineChildren(n *html.Node) string {
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text.WriteString(markdownInline(c))
+	}
+	return text.String()
+}
+
+// Wraps trimmed text in markup, keeping the whitespace of the untrimmed
+// original outside of it, as "** bold**" is no emphasis in markdown
+func emphasize(open, trimmed, close, original string) string {
+	if trimmed == "" {
+		return original
+	}
+	lead := original[:len(original)-len(strings.TrimLeft(original, " \n"))]
+	trail := original[len(strings.TrimRight(original, " \n")):]
+	return lead + open + trimmed + close + trail
+}
+
+// Returns "bold", "normal" or "" depending on the font-weight style
+func fontWeight(n *html.Node) string {
+	style := strings.Replace(strings.ToLower(attr(n, "style")), " ", "", -1)
+	for _, bold := range []string{"font-weight:bold", "font-weight:600", "font-weight:700", "font-weight:800", "font-weight:900"} {
+		if strings.Contains(style, bold) {
+			return "bold"
+		}
+	}
+	if strings.Contains(style, "font-weight:normal") || strings.Contains(style, "font-weight:400") {
+		return "normal"
+	}
+	return ""
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// Returns the text of a node with its whitespace, for code
+func rawText(n *html.Node) string {
+	var text strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			text.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.Data == "br":
+			text.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return text.String()
+}
+
+// Converts pasted html to markdown
+func (joki *joki) convertHTMLHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Converting requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	md, err := htmlToMarkdown(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if md == "" {
+		http.Error(w, "No content found", http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	io.WriteString(w, md)
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// What to do when an imported page has the title of an existing one
+const (
+	skipCollisions      = "skip"
+	overwriteCollisions = "overwrite"
+	renameCollisions    = "rename"
+)
+
+// The results of importing a file
+const (
+	resultImported    = "imported"
+	resultOverwritten = "overwritten"
+	resultRenamed     = "renamed"
+	resultSkipped     = "skipped"
+	resultFailed      = "failed"
+)
+
+// importFile is a markdown file of a zip or directory to import
+type importFile struct {
+	name string // slash separated path in the zip or directory
+	open func() (io.ReadCloser, error)
+}
+
+// ImportEntry is the result of importing a file
+type ImportEntry struct {
+	File   string
+	Title  string
+	Result string
+	Reason string
+}
+
+// ImportPage is the import form and the summary of an import
+type ImportPage struct {
+	Source     string
+	Namespace  string
+	Collisions string
+	Entries    []ImportEntry
+	Counts     map[string]int
+	Error      string
+}
+
+// Lists the files of a zip, except hidden ones and the resource forks macOS
+// adds
+func zipImportFiles(z *zip.Reader) []importFile {
+	var files []importFile
+	for _, f := range z.File {
+		f := f
+		if strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(path.Base(f.Name), ".") {
+			continue
+		}
+		files = append(files, importFile{name: f.Name, open: func() (io.ReadCloser, error) { return f.Open() }})
+	}
+	return files
+}
+
+// Lists the files below a directory, except hidden directories
+func dirImportFiles(dir string) ([]importFile, error) {
+	var files []importFile
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, importFile{name: filepath.ToSlash(rel), open: func() (io.ReadCloser, error) { return os.Open(p) }})
+		return nil
+	})
+	return files, err
+}
+
+// Returns the title of a file like "notes/Meeting notes.md" below namespace
+// ns, with names that are no valid titles turned into CamelCase, or "" if
+// there is none
+func fileTitle(ns, name string) string {
+	segments := strings.Split(strings.TrimSuffix(name, path.Ext(name)), "/")
+	if ns != "" {
+		segments = append([]string{ns}, segments...)
+	}
+	for i, s := range segments {
+		if !validTitle.MatchString(s) {
+			segments[i] = camelCase(s)
+		}
+	}
+	title := strings.Join(segments, "/")
+	if !validPageTitle(title) {
+		return ""
+	}
+	return title
+}
+
+// Imports the markdown files below namespace ns. Pages with the title of an
+// existing page or of another imported file are skipped, overwritten or
+// numbered, depending on collisions. The changed files are returned for
+// committing them.
+func (joki *joki) importFiles(files []importFile, ns, collisions string) ([]ImportEntry, []string) {
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	var entries []ImportEntry
+	var changed []string
+	taken := make(map[string]bool)
+	for _, f := range files {
+		if ext := path.Ext(f.name); ext != extension && ext != ".markdown" {
+			continue
+		}
+		e := ImportEntry{File: f.name, Title: fileTitle(ns, f.name), Result: resultImported}
+		if e.Title == "" {
+			e.Result, e.Reason = resultSkipped, "no valid title"
+			entries = append(entries, e)
+			continue
+		}
+		if taken[e.Title] || joki.exists(e.Title) {
+			switch collisions {
+			case overwriteCollisions:
+				e.Result = resultOverwritten
+			case renameCollisions:
+				t := e.Title
+				for i := 2; taken[t] || joki.exists(t); i++ {
+					t = e.Title + strconv.Itoa(i)
+				}
+				e.Title, e.Result = t, resultRenamed
+			default:
+				e.Result, e.Reason = resultSkipped, "page exists"
+				entries = append(entries, e)
+				continue
+			}
+		}
+		taken[e.Title] = true
+
+		body, err := readImportFile(f)
+		if err == nil {
+			_, err = joki.storePage(e.Title, body, "")
+		}
+		if err != nil {
+			e.Result, e.Reason = resultFailed, err.Error()
+		} else {
+			changed = append(changed, pageFile(e.Title))
+		}
+		entries = append(entries, e)
+	}
+	return entries, changed
+}
+
+func readImportFile(f importFile) ([]byte, error) {
+	r, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	body, err := ioutil.ReadAll(r)
+	return []byte(strings.Replace(string(body), "\r", "", -1)), err
+}
+
+// Counts the entries by result
+func importCounts(entries []ImportEntry) map[string]int {
+	counts := make(map[string]int)
+	for _, e := range entries {
+		counts[e.Result]++
+	}
+	return counts
+}
+
+func validCollisions(collisions string) bool {
+	return collisions == skipCollisions || collisions == overwriteCollisions || collisions == renameCollisions
+}
+
+// Imports the markdown files of a zip or a directory, for -import
+func (joki *joki) importCommand(source, ns, collisions string) error {
+	if ns != "" && !validPageTitle(ns) {
+		return fmt.Errorf("namespace \"%s\" is invalid", ns)
+	}
+	if !validCollisions(collisions) {
+		return fmt.Errorf("unknown collision handling \"%s\"", collisions)
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	var files []importFile
+	if info.IsDir() {
+		if files, err = dirImportFiles(source); err != nil {
+			return err
+		}
+	} else {
+		z, err := zip.OpenReader(source)
+		if err != nil {
+			return err
+		}
+		defer z.Close()
+		files = zipImportFiles(&z.Reader)
+	}
+
+	entries, changed := joki.importFiles(files, ns, collisions)
+	for _, e := range entries {
+		if e.Reason != "" {
+			fmt.Printf("%s %s: %s\n", e.File, e.Result, e.Reason)
+		} else {
+			fmt.Printf("%s %s as %s\n", e.File, e.Result, e.Title)
+		}
+	}
+	c := importCounts(entries)
+	log.Printf("Imported %d pages from %s, %d overwritten, %d renamed, %d skipped, %d failed",
+		c[resultImported]+c[resultOverwritten]+c[resultRenamed], source, c[resultOverwritten], c[resultRenamed], c[resultSkipped], c[resultFailed])
+	if joki.git != nil && len(changed) > 0 {
+		return joki.git.commit("gowiki", "Import "+filepath.Base(source), changed, nil)
+	}
+	return nil
+}
+
+// Shows the import form and imports an uploaded zip or a directory on the
+// server
+func (joki *joki) importHandler(w http.ResponseWriter, r *http.Request) {
+	ip := &ImportPage{Collisions: skipCollisions}
+	if r.Method != http.MethodPost {
+		joki.renderTemplate(w, r, "import", ip)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	ip.Source = strings.TrimSpace(r.FormValue("dir"))
+	ip.Namespace = strings.TrimSpace(r.FormValue("namespace"))
+	ip.Collisions = r.FormValue("collisions")
+	var files []importFile
+	var err error
+	switch {
+	case ip.Namespace != "" && !validPageTitle(ip.Namespace):
+		ip.Error = "Namespace is invalid: " + ip.Namespace
+	case !validCollisions(ip.Collisions):
+		ip.Error = "Unknown collision handling: " + ip.Collisions
+	case ip.Source != "":
+		if files, err = dirImportFiles(ip.Source); err != nil {
+			ip.Error = err.Error()
+		}
+	default:
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			ip.Error = "Choose a zip file or a directory to import"
+			break
+		}
+		defer file.Close()
+		z, err := zip.NewReader(file, header.Size)
+		if err != nil {
+			ip.Error = header.Filename + ": " + err.Error()
+			break
+		}
+		ip.Source = header.Filename
+		files = zipImportFiles(z)
+	}
+	if ip.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		joki.renderTemplate(w, r, "import", ip)
+		return
+	}
+
+	entries, changed := joki.importFiles(files, ip.Namespace, ip.Collisions)
+	ip.Entries, ip.Counts = entries, importCounts(entries)
+	if len(changed) > 0 {
+		if err := joki.commitChange(r, "Import "+path.Base(ip.Source), changed, nil); err != nil {
+			http.Error(w, "Pages imported but not committed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	joki.renderTemplate(w, r, "import", ip)
+}
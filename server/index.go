@@ -0,0 +1,51 @@
+package server
+
+import "log"
+
+// Builds the suggestion, search, link, task and metadata indexes from all
+// pages
+func (joki *joki) buildIndexes() {
+	pages, err := joki.listPages()
+	if err != nil {
+		log.Printf("Could not build indexes: %s", err)
+		return
+	}
+	for _, title := range pages {
+		joki.reindex(title)
+	}
+}
+
+// Updates the indexes after a page was saved
+func (joki *joki) reindex(title string) {
+	p, err := joki.loadPage(title)
+	if err != nil {
+		joki.unindex(title)
+		return
+	}
+	joki.suggest.set(title, pageHeadings(p.Body))
+	if err := joki.search.Set(title, p.Body); err != nil {
+		log.Printf("Could not index %s for search: %s", title, err)
+	}
+	joki.links.set(title, joki.pageLinks(p.Body))
+	joki.tasks.set(title, pageTasks(title, p.Body))
+	joki.meta.set(title, pageMeta(p.Body))
+	if ns := configNamespace(title); ns != "" {
+		joki.meta.setConfig(ns, namespaceConfig(p.Body))
+	}
+}
+
+// Removes a deleted or renamed page from the indexes. Links to it render
+// differently now, so the render cache is purged as well.
+func (joki *joki) unindex(title string) {
+	joki.renderCache.purge()
+	joki.suggest.remove(title)
+	if err := joki.search.Remove(title); err != nil {
+		log.Printf("Could not remove %s from the search index: %s", title, err)
+	}
+	joki.links.remove(title)
+	joki.tasks.remove(title)
+	joki.meta.remove(title)
+	if ns := configNamespace(title); ns != "" {
+		joki.meta.removeConfig(ns)
+	}
+}
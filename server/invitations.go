@@ -0,0 +1,256 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles of the accounts invitations create
+const (
+	editorRole = "editor"
+	adminRole  = "admin"
+)
+
+const (
+	invitationsFile       = ".invitations.json"
+	maxInvitationDays     = 90
+	defaultInvitationDays = 7
+)
+
+// Invitation is a link an admin hands out to create one account
+type Invitation struct {
+	Token   string    `json:"token"`
+	Role    string    `json:"role"`
+	Note    string    `json:"note,omitempty"` // who it is for
+	By      string    `json:"by"`
+	Expires time.Time `json:"expires"`
+	URL     string    `json:"-"`
+}
+
+// InvitationsPage lists the open invitations to admins
+type InvitationsPage struct {
+	Invitations []Invitation
+	Created     string // the URL of the invitation just created
+	Days        int    // the invitations are valid for by default
+	MaxDays     int
+	Error       string
+}
+
+// InvitePage is the form to accept an invitation
+type InvitePage struct {
+	Token string
+	Role  string
+	Name  string
+	Error string
+}
+
+// invitationStore keeps the open invitations and the admins created by
+// invitations, which are not in -admins, in a file of the data path
+type invitationStore struct {
+	sync.Mutex
+	file        string
+	Invitations map[string]Invitation `json:"invitations"` // by token
+	Admins      []string              `json:"admins"`
+}
+
+func loadInvitations(dataPath string) (*invitationStore, error) {
+	s := &invitationStore{file: filepath.Join(dataPath, invitationsFile), Invitations: make(map[string]Invitation)}
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("%s: %s", s.file, err)
+	}
+	if s.Invitations == nil {
+		s.Invitations = make(map[string]Invitation)
+	}
+	return s, nil
+}
+
+// Returns the invitations that did not expire, the oldest first
+func (s *invitationStore) list() []Invitation {
+	s.Lock()
+	defer s.Unlock()
+	invitations := make([]Invitation, 0, len(s.Invitations))
+	for _, inv := range s.Invitations {
+		if time.Now().Before(inv.Expires) {
+			invitations = append(invitations, inv)
+		}
+	}
+	sort.Slice(invitations, func(i, j int) bool { return invitations[i].Expires.Before(invitations[j].Expires) })
+	return invitations
+}
+
+// Returns the invitation of token unless it expired
+func (s *invitationStore) get(token string) (Invitation, bool) {
+	s.Lock()
+	defer s.Unlock()
+	inv, ok := s.Invitations[token]
+	return inv, ok && time.Now().Before(inv.Expires)
+}
+
+// Changes the store and writes it to the file, dropping the expired
+// invitations. fn returns an error to leave it unchanged.
+func (s *invitationStore) update(fn func(s *invitationStore) error) error {
+	s.Lock()
+	defer s.Unlock()
+	for token, inv := range s.Invitations {
+		if !time.Now().Before(inv.Expires) {
+			delete(s.Invitations, token)
+		}
+	}
+	if err := fn(s); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.file, data, 0600)
+}
+
+// Returns the link accepting an invitation
+func inviteURL(r *http.Request, token string) string {
+	return baseURL(r) + INVITE_PATH + "?token=" + url.QueryEscape(token)
+}
+
+// Lists the invitations, creates and revokes them
+func (joki *joki) invitationsHandler(w http.ResponseWriter, r *http.Request) {
+	page := &InvitationsPage{Days: defaultInvitationDays, MaxDays: maxInvitationDays}
+	if r.Method == http.MethodPost {
+		var err error
+		if token := r.FormValue("revoke"); token != "" {
+			err = joki.invitations.update(func(s *invitationStore) error {
+				delete(s.Invitations, token)
+				return nil
+			})
+			if err == nil {
+				log.Printf("%s revoked an invitation", joki.author(r))
+				http.Redirect(w, r, INVITATIONS_PATH, http.StatusFound)
+				return
+			}
+		} else if page.Created, err = joki.invite(r); err == nil {
+			log.Printf("%s invited a new %s", joki.author(r), r.FormValue("role"))
+		}
+		if err != nil {
+			page.Error = err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+	page.Invitations = joki.invitations.list()
+	for i := range page.Invitations {
+		page.Invitations[i].URL = inviteURL(r, page.Invitations[i].Token)
+	}
+	joki.renderTemplate(w, r, "invitations", page)
+}
+
+// Creates the invitation of the form in r and returns its link
+func (joki *joki) invite(r *http.Request) (string, error) {
+	if joki.usersFile == "" {
+		return "", fmt.Errorf("invitations need -users")
+	}
+	role := r.FormValue("role")
+	if role != editorRole && role != adminRole {
+		return "", fmt.Errorf("unknown role %s", role)
+	}
+	days, err := strconv.Atoi(r.FormValue("days"))
+	if err != nil || days < 1 || days > maxInvitationDays {
+		return "", fmt.Errorf("invitations are valid for 1 to %d days", maxInvitationDays)
+	}
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	inv := Invitation{
+		Token:   hex.EncodeToString(token),
+		Role:    role,
+		Note:    r.FormValue("note"),
+		By:      joki.currentUser(r),
+		Expires: time.Now().Add(time.Duration(days) * 24 * time.Hour),
+	}
+	err = joki.invitations.update(func(s *invitationStore) error {
+		s.Invitations[inv.Token] = inv
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return inviteURL(r, inv.Token), nil
+}
+
+// Creates the account of an invitation and logs its user in
+func (joki *joki) inviteHandler(w http.ResponseWriter, r *http.Request) {
+	page := &InvitePage{Token: r.FormValue("token")}
+	inv, ok := joki.invitations.get(page.Token)
+	if !ok {
+		http.Error(w, "The invitation is invalid or expired", http.StatusNotFound)
+		return
+	}
+	page.Role = inv.Role
+	if r.Method != http.MethodPost {
+		joki.renderTemplate(w, r, "invite", page)
+		return
+	}
+
+	page.Name = r.FormValue("name")
+	password := r.FormValue("password")
+	if !validUserName.MatchString(page.Name) {
+		page.Error = "Names are up to 32 letters, digits, dots, dashes and underscores"
+	} else if len(password) < minPasswordLength {
+		page.Error = fmt.Sprintf("Passwords have at least %d characters", minPasswordLength)
+	}
+	var hash []byte
+	if page.Error == "" {
+		var err error
+		if hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = joki.invitations.update(func(s *invitationStore) error {
+			// Another request may have used it meanwhile
+			if _, ok := s.Invitations[inv.Token]; !ok {
+				return fmt.Errorf("the invitation was used already")
+			}
+			if err := joki.createUser(page.Name, hash, inv.Role == adminRole); err != nil {
+				return err
+			}
+			if inv.Role == adminRole {
+				s.Admins = append(s.Admins, page.Name)
+			}
+			delete(s.Invitations, inv.Token)
+			return nil
+		})
+		if err != nil {
+			page.Error = err.Error()
+		}
+	}
+	if page.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		joki.renderTemplate(w, r, "invite", page)
+		return
+	}
+
+	log.Printf("%s joined as %s, invited by %s", page.Name, inv.Role, inv.By)
+	if err := joki.startSession(w, r, page.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
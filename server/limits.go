@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// limiter runs at most as many requests at once as it has slots. Further
+// requests wait in a queue of limited length for a slot to free up; once the
+// queue is full or they waited too long they are answered with 503, so heavy
+// requests cannot pile up and starve the others.
+type limiter struct {
+	slots   chan struct{}
+	waiting int32
+	queue   int32
+	timeout time.Duration
+	owed    int32 // slots abandoned renders still wait for
+}
+
+// Returns a limiter for n requests at once, or nil for no limit
+func newLimiter(n, queue int, timeout time.Duration) *limiter {
+	if n <= 0 {
+		return nil
+	}
+	return &limiter{slots: make(chan struct{}, n), queue: int32(queue), timeout: timeout}
+}
+
+// Waits for a slot and returns false if there is none in time
+func (l *limiter) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if atomic.AddInt32(&l.waiting, 1) > l.queue {
+		atomic.AddInt32(&l.waiting, -1)
+		return false
+	}
+	defer atomic.AddInt32(&l.waiting, -1)
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+	return false
+}
+
+// Wraps a handler so it runs within the limits, a nil limiter lets all
+// requests through
+func (l *limiter) limit(fn http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return fn
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !l.acquire(r) {
+			retry := int(l.timeout / time.Second)
+			if retry < 1 {
+				retry = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			http.Error(w, "The wiki is busy, please try again in a moment", http.StatusServiceUnavailable)
+			return
+		}
+		defer l.release()
+		fn(w, r)
+	}
+}
+
+// Frees the slot of a request, or hands it to a render that outlived its
+// request
+func (l *limiter) release() {
+	for {
+		owed := atomic.LoadInt32(&l.owed)
+		if owed == 0 {
+			<-l.slots
+			return
+		}
+		if atomic.CompareAndSwapInt32(&l.owed, owed, owed-1) {
+			return
+		}
+	}
+}
+
+// Keeps a slot for a render that was abandoned but keeps running, until
+// finished is closed. The slot is the next one a request frees, usually
+// that of the request that gave up on the render, so renders nobody waits
+// for still count against the limit.
+func (l *limiter) keep(finished <-chan struct{}) {
+	if l == nil {
+		return
+	}
+	atomic.AddInt32(&l.owed, 1)
+	go func() {
+		<-finished
+		for {
+			owed := atomic.LoadInt32(&l.owed)
+			if owed == 0 {
+				<-l.slots // it got one
+				return
+			}
+			if atomic.CompareAndSwapInt32(&l.owed, owed, owed-1) {
+				return
+			}
+		}
+	}()
+}
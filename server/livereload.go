@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// watchers notifies the browsers viewing a page when it changes
+type watchers struct {
+	sync.Mutex
+	pages map[string]map[chan struct{}]bool
+}
+
+func newWatchers() *watchers {
+	return &watchers{pages: make(map[string]map[chan struct{}]bool)}
+}
+
+func (ws *watchers) watch(title string) chan struct{} {
+	ws.Lock()
+	defer ws.Unlock()
+	if ws.pages[title] == nil {
+		ws.pages[title] = make(map[chan struct{}]bool)
+	}
+	c := make(chan struct{}, 1)
+	ws.pages[title][c] = true
+	return c
+}
+
+func (ws *watchers) unwatch(title string, c chan struct{}) {
+	ws.Lock()
+	defer ws.Unlock()
+	delete(ws.pages[title], c)
+	if len(ws.pages[title]) == 0 {
+		delete(ws.pages, title)
+	}
+}
+
+// Tells the watchers of a page that it changed. Watchers that were not told
+// yet about an earlier change are not told twice.
+func (ws *watchers) notify(title string) {
+	ws.Lock()
+	defer ws.Unlock()
+	for c := range ws.pages[title] {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Sends "changed" over a WebSocket whenever the page given by the title
+// parameter is saved, so viewers can reload it
+func (joki *joki) liveReloadHandler(w http.ResponseWriter, r *http.Request) {
+	title := r.FormValue("title")
+	if !validPageTitle(title) {
+		http.Error(w, "Title name is invalid: "+title, http.StatusBadRequest)
+		return
+	}
+	if !joki.canRead(r, title) || joki.hiddenDraft(r, title) {
+		http.Error(w, "You may not access "+title, http.StatusForbidden)
+		return
+	}
+	websocket.Handler(func(conn *websocket.Conn) {
+		defer conn.Close()
+		changed := joki.watchers.watch(title)
+		defer joki.watchers.unwatch(title, changed)
+
+		// the browser sends nothing, reading only notices it is gone
+		closed := make(chan struct{})
+		go func() {
+			var msg string
+			for websocket.Message.Receive(conn, &msg) == nil {
+			}
+			close(closed)
+		}()
+		for {
+			select {
+			case <-changed:
+				if websocket.Message.Send(conn, "changed") != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	}).ServeHTTP(w, r)
+}
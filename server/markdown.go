@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// The markdown extensions that can be enabled per wiki. Not every engine
+// knows every extension, unknown ones are skipped.
+var gomarkdownExtensions = map[string]parser.Extensions{
+	"tables":           parser.Tables,
+	"autolink":         parser.Autolink,
+	"strikethrough":    parser.Strikethrough,
+	"definition-lists": parser.DefinitionLists,
+	"footnotes":        parser.Footnotes,
+	"math":             parser.MathJax,
+	"super-subscript":  parser.SuperSubscript,
+}
+
+// Extensions without a name are always enabled for gomarkdown, they are
+// close to what CommonMark does anyway
+const commonExt parser.Extensions = parser.FencedCode | parser.SpaceHeadings |
+	parser.NoEmptyLineBeforeBlock | parser.HeadingIDs | parser.AutoHeadingIDs |
+	parser.BackslashLineBreak
+
+const defaultExtensions = "tables,autolink,strikethrough,definition-lists,footnotes,math,super-subscript"
+
+// Parses a comma separated list of extension names
+func parseExtensions(names string) (map[string]bool, error) {
+	extensions := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := gomarkdownExtensions[name]; !ok && !goldmarkOnly[name] {
+			return nil, fmt.Errorf("unknown markdown extension \"%s\"", name)
+		}
+		extensions[name] = true
+	}
+	return extensions, nil
+}
+
+// Returns the gomarkdown parser extensions for the options
+func gomarkdownExt(ro renderOptions) parser.Extensions {
+	ext := commonExt
+	for name := range ro.extensions {
+		ext |= gomarkdownExtensions[name]
+	}
+	if ro.hardWraps {
+		ext |= parser.HardLineBreak
+	}
+	return ext
+}
+
+// renderOptions are the settings a page is rendered with
+type renderOptions struct {
+	extensions  map[string]bool
+	wikiWords   bool
+	smartypants bool // smart quotes, dashes and ellipses
+	hardWraps   bool // single newlines are line breaks
+}
+
+// Returns a string that differs for different options
+func (ro renderOptions) key() string {
+	names := make([]string, 0, len(ro.extensions))
+	for name, on := range ro.extensions {
+		if on {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("%s|%t|%t|%t", strings.Join(names, ","), ro.wikiWords, ro.smartypants, ro.hardWraps)
+}
+
+// Returns the options of the wiki for a page, which its front matter may
+// override
+func (joki *joki) renderOptions(meta map[string]string) renderOptions {
+	extensions := joki.extensions
+	if extensions[mathFeature] && !joki.features.enabled(mathFeature) {
+		extensions = make(map[string]bool)
+		for name, on := range joki.extensions {
+			extensions[name] = on && name != mathFeature
+		}
+	}
+	return renderOptions{
+		extensions:  extensions,
+		wikiWords:   joki.wikiWordsEnabled(meta),
+		smartypants: metaBool(meta, "smartypants", joki.smartypants),
+		hardWraps:   metaBool(meta, "hardwraps", joki.hardWraps),
+	}
+}
+
+// Returns whether a page is rendered as strict CommonMark, which the front
+// matter of the page may override
+func (joki *joki) strictCommonMark(meta map[string]string) bool {
+	return metaBool(meta, "commonmark", joki.commonmark)
+}
+
+// Returns whether CamelCase words on a page are links
+func (joki *joki) wikiWordsEnabled(meta map[string]string) bool {
+	return metaBool(meta, "wikiwords", joki.wikiWords)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Posters of attached videos are kept in this directory of the data path, as
+// <name>.jpg in the directory of their page
+const postersDir = ".posters"
+
+const posterTimeout = 30 * time.Second
+
+// An image as both markdown engines render it
+var imageTag = regexp.MustCompile(`<img src="([^"]+)" alt="([^"]*)"[^>]*>`)
+
+// The posters players may show
+var posterURL = regexp.MustCompile(`^/files/[^?]+\?poster$`)
+
+// Returns "audio" or "video" if the file at url is one by its name, ""
+// otherwise
+func mediaKind(url string) string {
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		url = url[:i]
+	}
+	kind := strings.SplitN(mime.TypeByExtension(path.Ext(url)), "/", 2)[0]
+	if kind == "audio" || kind == "video" {
+		return kind
+	}
+	return ""
+}
+
+// Embeds what images of attachments refer to that browsers do not show as
+// images
+func (joki *joki) embedMedia(body []byte) []byte {
+	return joki.insertPlayers(insertDrawings(body))
+}
+
+// Turns images of audio and video files, ![a talk](talk.mp4), into HTML5
+// players. Attached videos get a poster if -ffmpeg is given.
+func (joki *joki) insertPlayers(body []byte) []byte {
+	return imageTag.ReplaceAllFunc(body, func(img []byte) []byte {
+		m := imageTag.FindSubmatch(img)
+		src, alt := string(m[1]), string(m[2])
+		kind := mediaKind(html.UnescapeString(src))
+		if kind == "" {
+			return img
+		}
+		player := "<" + kind + ` controls preload="metadata" src="` + src + `"`
+		if kind == "video" && joki.ffmpeg != "" && strings.HasPrefix(src, FILES_PATH) && !strings.Contains(src, "?") {
+			player += ` poster="` + src + `?poster"`
+		}
+		if alt == "" {
+			alt = src
+		}
+		return []byte(player + `><a href="` + src + `">` + alt + "</a></" + kind + ">")
+	})
+}
+
+// Serves the poster of an attached video, a frame ffmpeg picks from it that
+// is made again when the video changes
+func (joki *joki) servePoster(w http.ResponseWriter, r *http.Request, title, name string) {
+	video := filepath.Join(joki.attachmentPath(title), name)
+	info, err := os.Stat(video)
+	if err != nil || joki.ffmpeg == "" || mediaKind(name) != "video" {
+		http.NotFound(w, r)
+		return
+	}
+	poster := filepath.Join(joki.dataPath, postersDir, title, name+".jpg")
+	if p, err := os.Stat(poster); err != nil || p.ModTime().Before(info.ModTime()) {
+		if err := joki.makePoster(video, poster); err != nil {
+			log.Printf("Could not make the poster of %s for %s: %s", name, title, err)
+			http.NotFound(w, r)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeFile(w, r, poster)
+}
+
+// Lets ffmpeg write a representative frame of video to poster
+func (joki *joki) makePoster(video, poster string) error {
+	if err := os.MkdirAll(filepath.Dir(poster), 0700); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), posterTimeout)
+	defer cancel()
+	tmp := poster + ".tmp.jpg"
+	defer os.Remove(tmp)
+	out, err := exec.CommandContext(ctx, joki.ffmpeg, "-v", "error", "-y", "-i", video,
+		"-vf", "thumbnail,scale=640:-2", "-frames:v", "1", tmp).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.Rename(tmp, poster)
+}
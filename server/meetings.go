@@ -0,0 +1,135 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A page becomes a meeting series by having a Template subpage. Notes are
+// created below the series page and named after their date, e.g.
+// Meetings/Weekly/20191024.
+const meetingTemplate = "Template"
+
+const defaultMeetingTemplate = `# {{.Series}} {{.Date}}
+
+Attendees: {{.Attendees}}
+
+Previous: [{{.Previous}}]
+
+## Agenda
+
+## Notes
+
+## Action items
+`
+
+var (
+	meetingNote      = regexp.MustCompile(`^[0-9]{8}[a-z]?$`)
+	meetingAttendees = regexp.MustCompile(`(?im)^\s*attendees:[ \t]*(.*)$`)
+)
+
+// Returns whether title is a meeting series
+func (joki *joki) isMeetingSeries(title string) bool {
+	return joki.exists(title + "/" + meetingTemplate)
+}
+
+// Lists the notes of a meeting series, oldest first
+func (joki *joki) meetingNotes(series string) ([]string, error) {
+	titles, err := joki.listPages()
+	if err != nil {
+		return nil, err
+	}
+	var notes []string
+	for _, title := range titles {
+		if namespace(title) == series && meetingNote.MatchString(title[len(series)+1:]) {
+			notes = append(notes, title)
+		}
+	}
+	sort.Strings(notes)
+	return notes, nil
+}
+
+// Returns a free title for a note of today, adding a letter for further
+// meetings on the same day
+func (joki *joki) newMeetingTitle(series string, day time.Time) string {
+	title := series + "/" + day.Format("20060102")
+	for suffix := 'b'; joki.exists(title) && suffix <= 'z'; suffix++ {
+		title = series + "/" + day.Format("20060102") + string(suffix)
+	}
+	return title
+}
+
+// Creates a meeting note from the template of the series, with the
+// attendees of the last note filled in, and links it from the series page
+func (joki *joki) meetingHandler(w http.ResponseWriter, r *http.Request, series string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Creating a meeting note requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	index, err := joki.loadPage(series)
+	if err != nil {
+		http.Error(w, err.Error(), pageErrorStatus(err))
+		return
+	}
+	notes, err := joki.meetingNotes(series)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	title := joki.newMeetingTitle(series, now)
+	if joki.exists(title) {
+		http.Error(w, "Too many meetings today: "+title, http.StatusConflict)
+		return
+	}
+	vars := map[string]string{"Series": series, "Date": now.Format("2006-01-02"), "Attendees": "", "Previous": ""}
+	if len(notes) > 0 {
+		last := notes[len(notes)-1]
+		vars["Previous"] = last
+		if p, err := joki.loadPage(last); err == nil {
+			if m := meetingAttendees.FindSubmatch(p.Body); m != nil {
+				vars["Attendees"] = strings.TrimSpace(string(m[1]))
+			}
+		}
+	}
+
+	source := []byte(defaultMeetingTemplate)
+	if tp, err := joki.loadPage(series + "/" + meetingTemplate); err == nil {
+		source = tp.Body
+	}
+	body, err := instantiate(source, vars)
+	if err != nil {
+		http.Error(w, "Meeting template: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := joki.checkEditLock(r, series); err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+	if err := joki.throttle(r, title); err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+	if _, err := joki.storePage(title, body, joki.currentUser(r)); err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+
+	// Link the note from the series page
+	indexBody := strings.TrimRight(string(index.Body), "\n") + "\n- [" + title + "]\n"
+	if _, err := joki.storePage(series, []byte(indexBody), joki.currentUser(r)); err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+	err = joki.commitChange(r, "Create meeting note "+title, []string{pageFile(title), pageFile(series)}, nil)
+	if err != nil {
+		http.Error(w, "Meeting note saved but not committed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, EDIT_PATH+title, http.StatusFound)
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// PageMeta is what a page tells about itself in its front matter
+type PageMeta struct {
+	Tags      []string
+	Author    string
+	Owner     string // who keeps the page up to date
+	Date      string
+	Draft     bool              // hidden from everyone but its author and admins
+	Generated bool              // published by CI through the API, not edited in the wiki
+	Readers   []string          // users and @groups that may read the page, empty for all
+	Editors   []string          // users and @groups that may change the page, empty for all readers
+	Fields    map[string]string // all of the front matter, for the query directive
+}
+
+// Splits the front matter off a page. Front matter is a small subset of
+// YAML between two "---" lines at the very start of the page: "key: value"
+// lines, where a value may be a list like [a, b] or "- item" lines below
+// the key. Lists are returned joined with commas.
+//
+//	---
+//	tags: [howto, linux]
+//	author: ann
+//	draft: true
+//	---
+func frontMatter(body []byte) (map[string]string, []byte) {
+	const fence = "---\n"
+	if !bytes.HasPrefix(body, []byte(fence)) {
+		return nil, body
+	}
+	end := bytes.Index(body[len(fence):], []byte("\n"+fence))
+	if end < 0 && bytes.HasSuffix(body, []byte("\n---")) {
+		end = len(body) - len(fence) - len("\n---")
+		body = append(body[:len(body):len(body)], '\n')
+	}
+	if end < 0 {
+		return nil, body
+	}
+
+	meta := make(map[string]string)
+	key := ""
+	for _, line := range strings.Split(string(body[len(fence):len(fence)+end]), "\n") {
+		if item := strings.TrimSpace(line); strings.HasPrefix(item, "- ") && key != "" {
+			if meta[key] != "" {
+				meta[key] += ","
+			}
+			meta[key] += yamlScalar(item[2:])
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return nil, body // not front matter but a horizontal rule
+		}
+		key = strings.TrimSpace(kv[0])
+		meta[key] = yamlValue(strings.TrimSpace(kv[1]))
+	}
+	return meta, body[len(fence)+end+1+len(fence):]
+}
+
+// Returns a value with flow lists like [a, "b"] joined by commas
+func yamlValue(value string) string {
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		return yamlScalar(value)
+	}
+	var items []string
+	for _, item := range strings.Split(value[1:len(value)-1], ",") {
+		if item = yamlScalar(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// Removes the quotes around a value
+func yamlScalar(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// Returns a boolean setting from the front matter, or def if it is not set
+func metaBool(meta map[string]string, key string, def bool) bool {
+	switch meta[key] {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return def
+}
+
+// Reads the metadata of a page from its body
+func pageMeta(body []byte) PageMeta {
+	meta, _ := frontMatter(body)
+	pm := PageMeta{Author: meta["author"], Owner: meta["owner"], Date: meta["date"], Draft: metaBool(meta, "draft", false), Generated: metaBool(meta, "generated", false),
+		Readers: metaList(meta, "readers"), Editors: metaList(meta, "editors"), Fields: meta}
+	for _, tag := range metaList(meta, "tags") {
+		pm.Tags = append(pm.Tags, strings.ToLower(tag))
+	}
+	return pm
+}
+
+// Sets key to value in the front matter of body, which is added if the page
+// has none. An empty value removes key, and the front matter with its last
+// key.
+func setMeta(body []byte, key, value string) []byte {
+	const fence = "---\n"
+	var header []string
+	if meta, rest := frontMatter(body); meta != nil {
+		text := strings.TrimPrefix(string(body), fence)
+		header = strings.Split(text[:strings.Index(text, "\n---")], "\n")
+		body = rest
+	}
+	var lines []string
+	inKey := false
+	for _, line := range header {
+		if item := strings.TrimSpace(line); inKey && strings.HasPrefix(item, "- ") {
+			continue
+		}
+		inKey = strings.TrimSpace(strings.SplitN(line, ":", 2)[0]) == key
+		if !inKey {
+			lines = append(lines, line)
+		}
+	}
+	if value != "" {
+		lines = append(lines, key+": "+value)
+	}
+	if len(lines) == 0 {
+		return body
+	}
+	return append([]byte(fence+strings.Join(lines, "\n")+"\n"+fence), body...)
+}
+
+// Marks body as a draft of user, or publishes it, as the checkbox of the
+// edit form says. A draft keeps the author it names.
+func markDraft(body string, draft bool, user string) string {
+	if !draft {
+		return string(setMeta([]byte(body), "draft", ""))
+	}
+	b := []byte(body)
+	if !pageMeta(b).Draft {
+		b = setMeta(b, "draft", "true")
+	}
+	if user != "" && pageMeta(b).Author == "" {
+		b = setMeta(b, "author", user)
+	}
+	return string(b)
+}
+
+// Returns a list from the front matter without empty items
+func metaList(meta map[string]string, key string) []string {
+	var list []string
+	for _, item := range strings.Split(meta[key], ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// metaIndex keeps the metadata of all pages, so lists can be filtered
+// without loading every page, and the settings of the namespaces
+type metaIndex struct {
+	sync.RWMutex
+	meta    map[string]PageMeta
+	configs map[string]NamespaceConfig // namespace -> settings
+}
+
+func newMetaIndex() *metaIndex {
+	return &metaIndex{meta: make(map[string]PageMeta), configs: make(map[string]NamespaceConfig)}
+}
+
+func (idx *metaIndex) set(title string, meta PageMeta) {
+	idx.Lock()
+	defer idx.Unlock()
+	idx.meta[title] = meta
+}
+
+func (idx *metaIndex) remove(title string) {
+	idx.Lock()
+	defer idx.Unlock()
+	delete(idx.meta, title)
+}
+
+// Returns the metadata of page title, with the readers and editors of its
+// namespace if it lists none
+func (idx *metaIndex) get(title string) PageMeta {
+	idx.RLock()
+	defer idx.RUnlock()
+	return idx.namespaceConfigLocked(title).apply(idx.meta[title])
+}
+
+// Returns whether page title has tag or a tag nested in it, ops/network is
+// tagged ops as well
+func (idx *metaIndex) tagged(title, tag string) bool {
+	for _, t := range idx.get(title).Tags {
+		if t == tag || strings.HasPrefix(t, tag+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns tag and the tags it is nested in, ops/network/dns, ops/network
+// and ops
+func tagPath(tag string) []string {
+	tags := []string{tag}
+	for i := len(tag) - 1; i > 0; i-- {
+		if tag[i] == '/' {
+			tags = append(tags, tag[:i])
+		}
+	}
+	return tags
+}
+
+// Returns whether user may see a draft with meta: its author and admins
+// may, a draft naming no author is shared by all logged in users. Without
+// logins everyone may see drafts. A read only wiki is public, so it shows no
+// drafts at all.
+func (joki *joki) canSeeDraft(user string, meta PageMeta) bool {
+	switch {
+	case joki.readonly:
+		return false
+	case !joki.authEnabled():
+		return true
+	case user == "":
+		return false
+	}
+	return meta.Author == "" || meta.Author == user || joki.adminUser(user)
+}
+
+// Returns whether page title is a draft the request may not see
+func (joki *joki) hiddenDraft(r *http.Request, title string) bool {
+	meta := joki.meta.get(title)
+	return meta.Draft && !joki.canSeeDraft(joki.currentUser(r), meta)
+}
+
+// Returns whether page title is listed for the request of user: drafts only
+// for those who may see them, restricted pages only for their readers
+func (joki *joki) visible(title, user string) bool {
+	return joki.visibleMeta(user, joki.meta.get(title))
+}
+
+// Returns whether a page with meta is listed for user
+func (joki *joki) visibleMeta(user string, meta PageMeta) bool {
+	return (!meta.Draft || joki.canSeeDraft(user, meta)) && joki.readable(user, meta)
+}
+
+// Leaves the drafts and the pages the request may not read out of titles
+func (joki *joki) visiblePages(r *http.Request, titles []string) []string {
+	user := joki.currentUser(r)
+	visible := make([]string, 0, len(titles))
+	for _, title := range titles {
+		if joki.visible(title, user) {
+			visible = append(visible, title)
+		}
+	}
+	return visible
+}
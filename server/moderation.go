@@ -0,0 +1,501 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	moderationFile = ".moderation.json"
+	editLogFile    = ".edits.log" // one JSON line per change
+	revertTimeForm = "2006-01-02T15:04"
+	errQuarantined = "Changes of your account need the approval of a moderator"
+)
+
+// HeldEdit is an edit of a quarantined user waiting for a moderator
+type HeldEdit struct {
+	ID       string    `json:"id"`
+	Title    string    `json:"title"`
+	NewTitle string    `json:"newTitle,omitempty"` // if the edit renames the page
+	Body     string    `json:"body"`
+	Delete   bool      `json:"delete,omitempty"`
+	API      bool      `json:"api,omitempty"` // made through the API, which may write generated pages
+	Base     string    `json:"base"`          // the version of the page it was made on
+	User     string    `json:"user"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+	Conflict bool      `json:"-"` // the page changed since
+}
+
+// ModerationPage lists the quarantined users and their held edits, the
+// reports of readers and the outcome of reverting the edits of a user
+type ModerationPage struct {
+	Quarantined []string
+	Held        []HeldEdit
+	Reports     []Report
+	Reverted    []string
+	Deleted     []string // as the user created them
+	Skipped     []string // with the reason
+	Error       string
+}
+
+// editLogEntry records who changed which pages, for reverting all edits of
+// a user
+type editLogEntry struct {
+	Time    time.Time `json:"time"`
+	Author  string    `json:"author"`
+	Pages   []string  `json:"pages,omitempty"`
+	Removed []string  `json:"removed,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// moderationStore keeps the quarantined users, their held edits and the open
+// reports in a file of the data path, and the log of all edits next to it
+type moderationStore struct {
+	sync.Mutex
+	file        string
+	logFile     string
+	Quarantined []string   `json:"quarantined"`
+	Held        []HeldEdit `json:"held"`
+	Reports     []Report   `json:"reports"`
+}
+
+func loadModeration(dataPath string) (*moderationStore, error) {
+	s := &moderationStore{file: filepath.Join(dataPath, moderationFile), logFile: filepath.Join(dataPath, editLogFile)}
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("%s: %s", s.file, err)
+	}
+	return s, nil
+}
+
+// Returns whether the edits of user are held for moderation
+func (s *moderationStore) quarantined(user string) bool {
+	s.Lock()
+	defer s.Unlock()
+	return containsString(s.Quarantined, user)
+}
+
+// Returns the quarantined users, the held edits and the open reports, the
+// oldest first
+func (s *moderationStore) list() ([]string, []HeldEdit, []Report) {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.Quarantined...), append([]HeldEdit(nil), s.Held...), append([]Report(nil), s.Reports...)
+}
+
+// Changes the store and writes it to the file. fn returns an error to leave
+// it unchanged.
+func (s *moderationStore) update(fn func(s *moderationStore) error) error {
+	s.Lock()
+	defer s.Unlock()
+	if err := fn(s); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.file, data, 0600)
+}
+
+// Takes the held edit id out of the store
+func (s *moderationStore) take(id string) (HeldEdit, error) {
+	var edit HeldEdit
+	err := s.update(func(s *moderationStore) error {
+		for i, held := range s.Held {
+			if held.ID == id {
+				edit = held
+				s.Held = append(s.Held[:i], s.Held[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("no held edit %s", id)
+	})
+	return edit, err
+}
+
+// Appends a change to the edit log
+func (s *moderationStore) logEdit(entry editLogEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	f, err := os.OpenFile(s.logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Returns the edit log, the oldest change first
+func (s *moderationStore) edits() ([]editLogEntry, error) {
+	s.Lock()
+	defer s.Unlock()
+	f, err := os.Open(s.logFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []editLogEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry editLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s: %s", s.logFile, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Returns list without s
+func removeString(list []string, s string) []string {
+	kept := list[:0]
+	for _, item := range list {
+		if item != s {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// Returns the titles of the page files among files, leaving out attachments
+func pageTitles(files []string) []string {
+	var titles []string
+	for _, file := range files {
+		if strings.HasSuffix(file, extension) && !strings.HasPrefix(file, attachmentsDir+"/") {
+			titles = append(titles, strings.TrimSuffix(file, extension))
+		}
+	}
+	return titles
+}
+
+// Records a change of the request in the edit log
+func (joki *joki) logEdit(r *http.Request, message string, changed, removed []string) {
+	entry := editLogEntry{Time: time.Now(), Author: joki.author(r), Pages: pageTitles(changed), Removed: pageTitles(removed), Message: message}
+	if len(entry.Pages) == 0 && len(entry.Removed) == 0 {
+		return
+	}
+	if err := joki.moderation.logEdit(entry); err != nil {
+		log.Printf("Could not log the change of %s: %s", entry.Author, err)
+	}
+}
+
+// Returns whether the user of r is quarantined
+func (joki *joki) quarantined(r *http.Request) bool {
+	user := joki.currentUser(r)
+	return user != "" && joki.moderation.quarantined(user)
+}
+
+// Wraps handlers whose changes cannot be held for moderation, like those
+// of several pages or of attachments, so quarantined users are refused
+func (joki *joki) requireUnquarantined(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if joki.quarantined(r) {
+			http.Error(w, errQuarantined, http.StatusForbidden)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// Holds a change of a single page for moderation if the user of r is
+// quarantined. The user is not told, the change just does not show up.
+func (joki *joki) holdForModeration(r *http.Request, edit HeldEdit) (bool, error) {
+	if !joki.quarantined(r) {
+		return false, nil
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return false, err
+	}
+	edit.ID, edit.User, edit.Time = hex.EncodeToString(id), joki.currentUser(r), time.Now()
+	edit.Base = joki.currentVersion(edit.Title)
+	err := joki.moderation.update(func(s *moderationStore) error {
+		s.Held = append(s.Held, edit)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	log.Printf("Held the change of %s by quarantined %s", edit.Title, edit.User)
+	return true, nil
+}
+
+// Makes a held edit live, unless the page changed since it was held. The
+// edit was made on the old version, storing it would undo the newer change.
+func (joki *joki) approve(r *http.Request, edit HeldEdit) error {
+	if joki.currentVersion(edit.Title) != edit.Base {
+		return fmt.Errorf("%s changed since the edit was held, reject it and redo it by hand", edit.Title)
+	}
+	if err := joki.checkEditLock(r, edit.Title); err != nil {
+		return err
+	}
+	if !edit.Delete && !edit.API && joki.meta.get(edit.Title).Generated {
+		return errors.New(generatedError(edit.Title))
+	}
+	message := edit.Message + " by " + edit.User
+	if edit.Delete {
+		if err := joki.removePage(edit.Title); err != nil {
+			return err
+		}
+		return joki.commitChange(r, message, nil, []string{pageFile(edit.Title)})
+	}
+	renamed := edit.NewTitle != "" && edit.NewTitle != edit.Title
+	if renamed {
+		if err := joki.holds.check(edit.NewTitle); err != nil {
+			return err
+		}
+		if joki.exists(edit.NewTitle) {
+			return fmt.Errorf("%s exists by now", edit.NewTitle)
+		}
+	}
+	p, err := joki.storePage(edit.Title, []byte(edit.Body), edit.User)
+	if err != nil {
+		return err
+	}
+	if !renamed {
+		return joki.commitChange(r, message, []string{pageFile(edit.Title)}, nil)
+	}
+	if err := joki.movePage(p, edit.NewTitle); err != nil {
+		return err
+	}
+	return joki.commitChange(r, message, []string{pageFile(edit.NewTitle)}, []string{pageFile(edit.Title)})
+}
+
+// Reverts the pages whose latest edits user made between from and to, to
+// the version before those edits. Pages created by them are deleted, pages
+// others changed since are left alone.
+func (joki *joki) revertEdits(r *http.Request, user string, from, to time.Time) (reverted, deleted, skipped []string, err error) {
+	entries, err := joki.moderation.edits()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Counts the edits of user at the end of the log of every page
+	type run struct {
+		edits   int
+		removed bool // by user, which the trash restores
+		ended   bool // by a change of someone else
+		listed  bool // in titles, as user changed the page
+	}
+	runs := make(map[string]*run)
+	var titles []string
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		byUser := e.Author == user && !e.Time.Before(from) && !e.Time.After(to)
+		for _, title := range append(append([]string(nil), e.Pages...), e.Removed...) {
+			pr := runs[title]
+			if pr == nil {
+				pr = &run{}
+				runs[title] = pr
+			}
+			if !byUser {
+				pr.ended = true
+				continue
+			}
+			if !pr.ended {
+				pr.edits++
+				pr.removed = pr.removed || containsString(e.Removed, title)
+			}
+			if !pr.listed {
+				pr.listed = true
+				titles = append(titles, title)
+			}
+		}
+	}
+
+	var changed, removed []string
+	for _, title := range titles {
+		run := runs[title]
+		if run.edits == 0 {
+			skipped = append(skipped, title+": changed by others since")
+			continue
+		}
+		if run.removed {
+			skipped = append(skipped, title+": deleted, restore it from the trash")
+			continue
+		}
+		if err := joki.checkEditLock(r, title); err != nil {
+			skipped = append(skipped, err.Error())
+			continue
+		}
+		revs, err := joki.revisions(title)
+		if err != nil {
+			return reverted, deleted, skipped, err
+		}
+		if run.edits > len(revs) {
+			if err := joki.removePage(title); err != nil {
+				skipped = append(skipped, title+": "+err.Error())
+				continue
+			}
+			removed = append(removed, pageFile(title))
+			deleted = append(deleted, title)
+			continue
+		}
+		body, err := joki.loadRevision(title, revs[run.edits-1].ID)
+		if err == nil {
+			_, err = joki.storePage(title, body, "")
+		}
+		if err != nil {
+			skipped = append(skipped, title+": "+err.Error())
+			continue
+		}
+		changed = append(changed, pageFile(title))
+		reverted = append(reverted, title)
+	}
+	if len(changed) > 0 || len(removed) > 0 {
+		err = joki.commitChange(r, "Revert the edits of "+user, changed, removed)
+	}
+	return reverted, deleted, skipped, err
+}
+
+// Parses the time of a datetime-local field, def if it is empty
+func parseRevertTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	return time.ParseInLocation(revertTimeForm, value, time.Local)
+}
+
+// Quarantines and releases users, approves or rejects their held edits,
+// dismisses reports and reverts the edits of a user
+func (joki *joki) moderationHandler(w http.ResponseWriter, r *http.Request) {
+	page := &ModerationPage{}
+	if r.Method == http.MethodPost {
+		if err := joki.moderate(w, r, page); err != nil {
+			page.Error = err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+		} else if r.FormValue("action") != "revert" {
+			http.Redirect(w, r, MODERATION_PATH, http.StatusFound)
+			return
+		}
+	}
+	page.Quarantined, page.Held, page.Reports = joki.moderation.list()
+	for i := range page.Held {
+		page.Held[i].Conflict = joki.currentVersion(page.Held[i].Title) != page.Held[i].Base
+	}
+	joki.renderTemplate(w, r, "moderation", page)
+}
+
+// Carries out the action of the moderation form
+func (joki *joki) moderate(w http.ResponseWriter, r *http.Request, page *ModerationPage) error {
+	user, id := strings.TrimSpace(r.FormValue("user")), r.FormValue("id")
+	switch action := r.FormValue("action"); action {
+	case "quarantine":
+		if !joki.authEnabled() {
+			return fmt.Errorf("quarantine needs -users or -user")
+		}
+		if _, ok := joki.userHash(user); !ok {
+			return fmt.Errorf("unknown user %s", user)
+		}
+		err := joki.moderation.update(func(s *moderationStore) error {
+			if !containsString(s.Quarantined, user) {
+				s.Quarantined = append(s.Quarantined, user)
+			}
+			return nil
+		})
+		if err == nil {
+			log.Printf("%s quarantined %s", joki.author(r), user)
+		}
+		return err
+
+	case "release":
+		err := joki.moderation.update(func(s *moderationStore) error {
+			s.Quarantined = removeString(s.Quarantined, user)
+			return nil
+		})
+		if err == nil {
+			log.Printf("%s released %s from quarantine", joki.author(r), user)
+		}
+		return err
+
+	case "approve":
+		_, held, _ := joki.moderation.list()
+		for _, edit := range held {
+			if edit.ID != id {
+				continue
+			}
+			if err := joki.approve(r, edit); err != nil {
+				return err
+			}
+			log.Printf("%s approved the edit of %s by %s", joki.author(r), edit.Title, edit.User)
+			_, err := joki.moderation.take(id)
+			return err
+		}
+		return fmt.Errorf("no held edit %s", id)
+
+	case "reject":
+		edit, err := joki.moderation.take(id)
+		if err == nil {
+			log.Printf("%s rejected the edit of %s by %s", joki.author(r), edit.Title, edit.User)
+		}
+		return err
+
+	case "dismiss":
+		var report Report
+		err := joki.moderation.update(func(s *moderationStore) error {
+			for i, open := range s.Reports {
+				if open.ID == id {
+					report = open
+					s.Reports = append(s.Reports[:i], s.Reports[i+1:]...)
+					return nil
+				}
+			}
+			return fmt.Errorf("no report %s", id)
+		})
+		if err == nil {
+			log.Printf("%s dismissed the report of %s by %s", joki.author(r), report.Page, report.Reporter)
+		}
+		return err
+
+	case "revert":
+		from, err := parseRevertTime(r.FormValue("from"), time.Time{})
+		if err != nil {
+			return err
+		}
+		to, err := parseRevertTime(r.FormValue("to"), time.Now())
+		if err != nil {
+			return err
+		}
+		if user == "" {
+			return fmt.Errorf("whose edits?")
+		}
+		page.Reverted, page.Deleted, page.Skipped, err = joki.revertEdits(r, user, from, to)
+		log.Printf("%s reverted %d and deleted %d pages of %s", joki.author(r), len(page.Reverted), len(page.Deleted), user)
+		if page.Reverted == nil && page.Deleted == nil && page.Skipped == nil && err == nil {
+			page.Skipped = []string{"No pages to revert"}
+		}
+		return err
+
+	default:
+		return fmt.Errorf("unknown action %s", action)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// Quarantines user as the admin of tw
+func quarantine(t *testing.T, tw *testWiki, user string) {
+	t.Helper()
+	if w := tw.request(http.MethodPost, MODERATION_PATH, "bob", url.Values{"action": {"quarantine"}, "user": {user}}); w.Code != http.StatusFound {
+		t.Fatalf("quarantining %s: status %d", user, w.Code)
+	}
+}
+
+// Returns the only edit held in tw
+func heldEdit(t *testing.T, tw *testWiki) HeldEdit {
+	t.Helper()
+	_, held, _ := tw.joki.moderation.list()
+	if len(held) != 1 {
+		t.Fatalf("%d edits are held, want 1", len(held))
+	}
+	return held[0]
+}
+
+func TestModeration(t *testing.T) {
+	tests := []struct {
+		action string
+		body   string // of Home afterwards
+	}{
+		{"approve", "# Spam?"},
+		{"reject", "# Home"},
+	}
+	for _, tt := range tests {
+		tw := newTestWiki(t, nil)
+		tw.store("Home", "# Home")
+		quarantine(t, tw, "ann")
+
+		if w := tw.save("Home", "# Spam?", "ann"); w.Code != http.StatusFound {
+			t.Fatalf("saving while quarantined: status %d", w.Code)
+		}
+		if tw.body("Home") != "# Home" {
+			t.Fatalf("the edit of a quarantined user went live: %q", tw.body("Home"))
+		}
+		edit := heldEdit(t, tw)
+		if edit.User != "ann" || edit.Title != "Home" {
+			t.Errorf("held %+v", edit)
+		}
+
+		form := url.Values{"action": {tt.action}, "id": {edit.ID}}
+		if w := tw.request(http.MethodPost, MODERATION_PATH, "ann", form); w.Code != http.StatusForbidden {
+			t.Errorf("%s as the quarantined user: status %d, want %d", tt.action, w.Code, http.StatusForbidden)
+		}
+		if w := tw.request(http.MethodPost, MODERATION_PATH, "bob", form); w.Code != http.StatusFound {
+			t.Fatalf("%s: status %d", tt.action, w.Code)
+		}
+		if got := tw.body("Home"); got != tt.body {
+			t.Errorf("after %s Home is %q, want %q", tt.action, got, tt.body)
+		}
+		if _, held, _ := tw.joki.moderation.list(); len(held) != 0 {
+			t.Errorf("after %s %d edits are still held", tt.action, len(held))
+		}
+	}
+}
+
+func TestModerationConflict(t *testing.T) {
+	tw := newTestWiki(t, nil)
+	tw.store("Home", "# Home")
+	quarantine(t, tw, "ann")
+	tw.save("Home", "# Spam?", "ann")
+	edit := heldEdit(t, tw)
+
+	tw.store("Home", "# Home, edited since")
+	w := tw.request(http.MethodPost, MODERATION_PATH, "bob", url.Values{"action": {"approve"}, "id": {edit.ID}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("approving an edit of a page changed since: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if tw.body("Home") != "# Home, edited since" {
+		t.Errorf("the stale edit overwrote Home: %q", tw.body("Home"))
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	tw := newTestWiki(t, nil)
+	tw.store("Home", "# Home")
+	quarantine(t, tw, "ann")
+	uploadType, upload := uploadForm(t, "notes.txt", "notes")
+
+	tests := []struct {
+		what   string
+		user   string
+		send   func(user string) int
+		status int
+	}{
+		{"uploading", "ann", func(user string) int {
+			return tw.send(http.MethodPost, UPLOAD_PATH+"Home", user, uploadType, upload).Code
+		}, http.StatusForbidden},
+		{"uploading", "cy", func(user string) int {
+			return tw.send(http.MethodPost, UPLOAD_PATH+"Home", user, uploadType, upload).Code
+		}, http.StatusFound},
+		{"deleting", "ann", func(user string) int {
+			return tw.request(http.MethodPost, DELETE_PATH+"Home", user, url.Values{"Confirmed": {"True"}}).Code
+		}, http.StatusFound},
+		{"saving through the API", "ann", func(user string) int {
+			return tw.send(http.MethodPut, API_PAGES_PATH+"/Home", user, "text/markdown", "# API").Code
+		}, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		if status := tt.send(tt.user); status != tt.status {
+			t.Errorf("%s as %s: status %d, want %d", tt.what, tt.user, status, tt.status)
+		}
+	}
+	if !tw.joki.exists("Home") || tw.body("Home") != "# Home" {
+		t.Errorf("the changes of the quarantined user went live: %q", tw.body("Home"))
+	}
+	if _, held, _ := tw.joki.moderation.list(); len(held) != 2 {
+		t.Errorf("%d edits are held, want the deletion and the API save", len(held))
+	}
+
+	if w := tw.request(http.MethodPost, MODERATION_PATH, "bob", url.Values{"action": {"release"}, "user": {"ann"}}); w.Code != http.StatusFound {
+		t.Fatalf("releasing: status %d", w.Code)
+	}
+	if w := tw.save("Home", "# Released", "ann"); w.Code != http.StatusFound || tw.body("Home") != "# Released" {
+		t.Errorf("saving after the release: status %d, body %q", w.Code, tw.body("Home"))
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Mounted wikis left at this -redis-prefix get one of their own
+const defaultRedisPrefix = "gowiki:"
+
+// Matches the site absolute links in html, but not //host/ ones
+var siteLink = regexp.MustCompile(`\b(href|src|action|formaction)="/([^/"][^"]*)?"`)
+
+// mountedWiki is a wiki served for requests to its host, below its prefix
+type mountedWiki struct {
+	host, prefix string
+	handler      http.Handler
+}
+
+func (wiki *mountedWiki) matches(r *http.Request) bool {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if wiki.host != "" && !strings.EqualFold(wiki.host, host) {
+		return false
+	}
+	return wiki.prefix == "" || r.URL.Path == wiki.prefix || strings.HasPrefix(r.URL.Path, wiki.prefix+"/")
+}
+
+// wikiRouter serves each request by the first wiki matching it, or the wiki
+// of the command line
+type wikiRouter struct {
+	wikis []*mountedWiki
+	main  http.Handler
+}
+
+func (router *wikiRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, wiki := range router.wikis {
+		if !wiki.matches(r) {
+			continue
+		}
+		if wiki.prefix == "" {
+			wiki.handler.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == wiki.prefix {
+			http.Redirect(w, r, wiki.prefix+"/", http.StatusFound)
+			return
+		}
+		pw := &prefixWriter{ResponseWriter: w, prefix: wiki.prefix}
+		wiki.handler.ServeHTTP(pw, r.WithContext(context.WithValue(r.Context(), prefixKey{}, wiki.prefix)))
+		pw.finish()
+		return
+	}
+	router.main.ServeHTTP(w, r)
+}
+
+// Opens the wikis configured in c.Wikis and returns a handler serving them
+// next to main, which handler serves as configured by c
+func mountWikis(main *joki, handler http.Handler, c Config, renders, exports *limiter) (http.Handler, error) {
+	wikis := c.Wikis
+	if len(wikis) == 0 {
+		return handler, nil
+	}
+	router := &wikiRouter{main: handler}
+	paths := map[string]string{filepath.Clean(main.dataPath): "the main wiki"}
+	// Wikis sharing a Redis would share logins and rendered pages
+	redisKeys := make(map[string]string)
+	if c.Redis != "" {
+		redisKeys[c.Redis+" "+c.RedisPrefix] = "the main wiki"
+	}
+	for i := range wikis {
+		c := &wikis[i]
+		name := c.Host + c.Prefix
+		if c.Host == "" && c.Prefix == "" {
+			return nil, fmt.Errorf("wiki at %s needs a host or a prefix", c.Path)
+		}
+		if c.Prefix != "" && (!strings.HasPrefix(c.Prefix, "/") || strings.HasSuffix(c.Prefix, "/")) {
+			return nil, fmt.Errorf("prefix %s must start but not end with /", c.Prefix)
+		}
+		path := filepath.Clean(c.Path)
+		if other, ok := paths[path]; ok {
+			return nil, fmt.Errorf("wiki at %s has the same path as %s", name, other)
+		}
+		paths[path] = "the wiki at " + name
+		if c.Redis != "" {
+			if c.RedisPrefix == defaultRedisPrefix {
+				c.RedisPrefix = defaultRedisPrefix + name + ":"
+			}
+			key := c.Redis + " " + c.RedisPrefix
+			if other, ok := redisKeys[key]; ok {
+				return nil, fmt.Errorf("wiki at %s has the same redis-prefix %s as %s", name, c.RedisPrefix, other)
+			}
+			redisKeys[key] = "the wiki at " + name
+		}
+
+		wiki, err := newJoki(c)
+		if err != nil {
+			return nil, fmt.Errorf("wiki at %s: %s", name, err)
+		}
+		if err := wiki.open(c); err != nil {
+			return nil, fmt.Errorf("wiki at %s: %s", name, err)
+		}
+		mounted := &mountedWiki{host: c.Host, prefix: c.Prefix, handler: wiki.handler(renders, exports)}
+		if c.Prefix != "" {
+			mounted.handler = http.StripPrefix(c.Prefix, mounted.handler)
+		}
+		router.wikis = append(router.wikis, mounted)
+		log.Printf("Serving the wiki in %s at %s", wiki.dataPath, name)
+	}
+	return router, nil
+}
+
+type prefixKey struct{}
+
+// Returns the prefix the wiki answering r is served below, "" for none
+func wikiPrefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(prefixKey{}).(string)
+	return prefix
+}
+
+// prefixWriter moves the redirects, cookies and site absolute links a wiki
+// writes below the prefix it is served at. Html is buffered until finish to
+// rewrite its links, like exportTemplate does for a static copy.
+type prefixWriter struct {
+	http.ResponseWriter
+	prefix      string
+	wroteHeader bool
+	status      int
+	html        *bytes.Buffer
+}
+
+func (pw *prefixWriter) WriteHeader(status int) {
+	if pw.wroteHeader {
+		return
+	}
+	pw.wroteHeader = true
+	header := pw.Header()
+	if loc := header.Get("Location"); strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+		header.Set("Location", pw.prefix+loc)
+	}
+	for i, cookie := range header["Set-Cookie"] {
+		header["Set-Cookie"][i] = strings.Replace(cookie, "; Path=/", "; Path="+pw.prefix+"/", 1)
+	}
+	if strings.HasPrefix(header.Get("Content-Type"), "text/html") {
+		header.Del("Content-Length")
+		pw.status, pw.html = status, &bytes.Buffer{}
+		return
+	}
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+func (pw *prefixWriter) Write(b []byte) (int, error) {
+	if !pw.wroteHeader {
+		if pw.Header().Get("Content-Type") == "" {
+			pw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		pw.WriteHeader(http.StatusOK)
+	}
+	if pw.html != nil {
+		return pw.html.Write(b)
+	}
+	return pw.ResponseWriter.Write(b)
+}
+
+// Writes the buffered html with its links moved below the prefix
+func (pw *prefixWriter) finish() {
+	if pw.html == nil {
+		return
+	}
+	pw.ResponseWriter.WriteHeader(pw.status)
+	pw.ResponseWriter.Write(siteLink.ReplaceAll(pw.html.Bytes(), []byte(`$1="`+pw.prefix+`/$2"`)))
+}
+
+// Lets the live reload WebSocket take over the connection
+func (pw *prefixWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := pw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection cannot be hijacked")
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A title is one or more names separated by slashes, e.g. Projects/GoWiki.
+// Every name but the last is a namespace, stored as a directory.
+const titlePattern = `[a-zA-Z0-9]+(?:/[a-zA-Z0-9]+)*`
+
+// Breadcrumb is one level of the namespace path shown above a page
+type Breadcrumb struct {
+	Name  string
+	Title string
+}
+
+// Returns whether title may be used for a page. The attachments
+// directory is reserved and cannot be used as a namespace.
+func validPageTitle(title string) bool {
+	return validTitle.MatchString(title) && !strings.HasPrefix(title, attachmentsDir+"/")
+}
+
+// Returns the namespace of a title, "" for top level pages
+func namespace(title string) string {
+	if i := strings.LastIndex(title, "/"); i >= 0 {
+		return title[:i]
+	}
+	return ""
+}
+
+// Returns the path from the top level down to title
+func breadcrumbs(title string) []Breadcrumb {
+	names := strings.Split(title, "/")
+	crumbs := make([]Breadcrumb, len(names))
+	for i, name := range names {
+		crumbs[i] = Breadcrumb{Name: name, Title: strings.Join(names[:i+1], "/")}
+	}
+	return crumbs
+}
+
+// Resolves an interlink written on page from. Like in vimwiki links are
+// relative to the namespace of the page; if no such page exists but a page
+// with the link as full title does, that one is used instead.
+func (joki *joki) resolveLink(from, link string) string {
+	ns := namespace(from)
+	if ns == "" {
+		return link
+	}
+	relative := ns + "/" + link
+	if !joki.exists(relative) && joki.exists(link) {
+		return link
+	}
+	return relative
+}
+
+// Moves the files of a page's history or attachments directory to the one of
+// its new title. Subdirectories belong to pages in the namespace of the old
+// title and stay where they are.
+func moveDir(oldDir, newDir string) error {
+	files, err := ioutil.ReadDir(oldDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(newDir, 0700); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if err := os.Rename(filepath.Join(oldDir, f.Name()), filepath.Join(newDir, f.Name())); err != nil {
+			return err
+		}
+	}
+	os.Remove(oldDir) // only succeeds once empty
+	return nil
+}
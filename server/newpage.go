@@ -0,0 +1,87 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Boilerplate for new pages is kept as markdown files in this directory of
+// the data path. It is not a valid title, so templates never show up as pages.
+const pageTemplatesDir = "_templates"
+
+// NewPage is the editor for a page that does not exist yet
+type NewPage struct {
+	Title     string
+	Templates []string
+	Template  string
+	Body      string
+	Draft     bool
+	Error     string
+}
+
+// Shows the editor for a new page, pre-filled from the ?template= if given
+// or else the one of its namespace, and tagged as its namespace requires
+func (joki *joki) newPageEditor(w http.ResponseWriter, r *http.Request, title string) {
+	np := &NewPage{Title: title, Template: r.FormValue("template")}
+	var err error
+	if np.Templates, err = joki.pageTemplates(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, chosen := r.Form["template"]; !chosen {
+		np.Template = joki.meta.namespaceConfig(title).Template
+	}
+	var body []byte
+	if np.Template != "" {
+		body, err = joki.pageFromTemplate(np.Template, title)
+		if err != nil {
+			np.Error = "Template " + np.Template + ": " + err.Error()
+		}
+	}
+	body = joki.addRequiredTags(title, body)
+	np.Body = string(body)
+	np.Draft = pageMeta(body).Draft
+	joki.renderTemplate(w, r, "new", np)
+}
+
+// Lists the names of the page templates
+func (joki *joki) pageTemplates() ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(joki.dataPath, pageTemplatesDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), extension)
+		if !f.IsDir() && strings.HasSuffix(f.Name(), extension) && validFileName.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Fills in a page template for the page title. Besides {{.Date}} the
+// template can use {{.Title}}, {{.Name}} and {{.Namespace}}.
+func (joki *joki) pageFromTemplate(name, title string) ([]byte, error) {
+	if !validFileName.MatchString(name) {
+		return nil, os.ErrNotExist
+	}
+	source, err := ioutil.ReadFile(filepath.Join(joki.dataPath, pageTemplatesDir, name+extension))
+	if err != nil {
+		return nil, err
+	}
+	return instantiate(source, map[string]string{
+		"Date":      time.Now().Format("2006-01-02"),
+		"Title":     title,
+		"Name":      title[strings.LastIndex(title, "/")+1:],
+		"Namespace": namespace(title),
+	})
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Events on pages that notifications are sent for
+const (
+	changedEvent  = "changed"
+	deletedEvent  = "deleted"
+	feedbackEvent = "feedback"
+	reportEvent   = "report"
+	digestEvent   = "digest"
+	allEvents     = "*"
+)
+
+// Notification tells about an event on a page of the wiki
+type Notification struct {
+	Wiki    string `json:"wiki"`
+	Event   string `json:"event"`
+	Page    string `json:"page"`
+	User    string `json:"user,omitempty"` // who caused the event
+	Message string `json:"message"`        // summary of the change or the feedback
+	URL     string `json:"url"`            // of the page
+}
+
+// Subject returns a line telling what happened
+func (n Notification) Subject() string {
+	if n.Event == feedbackEvent {
+		return "[" + n.Wiki + "] Feedback on " + n.Page
+	}
+	if n.Event == reportEvent {
+		return "[" + n.Wiki + "] Report on " + n.Page
+	}
+	if n.Event == digestEvent {
+		return "[" + n.Wiki + "] Changes of the week"
+	}
+	return "[" + n.Wiki + "] " + n.User + " " + n.Event + " " + n.Page
+}
+
+// Text returns the message followed by the link to the page
+func (n Notification) Text() string {
+	if n.Message == "" || n.URL == "" {
+		return n.Message + n.URL
+	}
+	return n.Message + "\n\n" + n.URL
+}
+
+// Transport delivers notifications to a target, which is an address, a URL
+// or a room depending on the transport
+type Transport interface {
+	Send(target string, n Notification) error
+}
+
+// notifyRule sends the events selected by an event name, * or a user name
+// to target with a transport
+type notifyRule struct {
+	selector  string
+	transport string
+	target    string
+}
+
+// notifier sends the notifications of a wiki as its rules say
+type notifier struct {
+	transports map[string]Transport
+	rules      []notifyRule
+}
+
+// Loads rules from a file with one "event or user: transport target" per
+// line. Empty lines and lines starting with # are ignored.
+//
+//	changed: webhook https://chat.example.com/hooks/wiki
+//	ann: email ann@example.com
+func loadNotifier(fileName string, transports map[string]Transport) (*notifier, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	nt := &notifier{transports: transports}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%s:%d: expected event or user: transport target", fileName, line)
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected event or user: transport target", fileName, line)
+		}
+		if _, ok := transports[fields[0]]; !ok {
+			return nil, fmt.Errorf("%s:%d: %s", fileName, line, transportError(fields[0]))
+		}
+		nt.rules = append(nt.rules, notifyRule{selector: strings.TrimSpace(parts[0]), transport: fields[0], target: fields[1]})
+	}
+	return nt, scanner.Err()
+}
+
+// Returns the targets n is sent to: those of the rules for its event and
+// those of the author of the page, unless the author caused the event or the
+// page was reported
+func (nt *notifier) targets(n Notification, author string) []notifyRule {
+	var targets []notifyRule
+	seen := make(map[notifyRule]bool)
+	for _, rule := range nt.rules {
+		selected := rule.selector == n.Event || rule.selector == allEvents ||
+			(rule.selector == author && author != n.User && n.Event != reportEvent)
+		target := notifyRule{transport: rule.transport, target: rule.target}
+		if selected && !seen[target] {
+			seen[target] = true
+			targets = append(targets, rule)
+		}
+	}
+	return targets
+}
+
+// Sends a notification about event on page title in the background. Drafts
+// are not told about, not even to their author.
+func (joki *joki) notify(r *http.Request, event, title, user, message string) {
+	meta := joki.meta.get(title)
+	if joki.notifier == nil || meta.Draft {
+		return
+	}
+	n := Notification{Wiki: joki.wikiName, Event: event, Page: title, User: user, Message: message,
+		URL: baseURL(r) + VIEW_PATH + title}
+	for _, rule := range joki.notifier.targets(n, meta.Author) {
+		go func(rule notifyRule) {
+			if err := joki.notifier.transports[rule.transport].Send(rule.target, n); err != nil {
+				log.Printf("Could not send %s notification to %s: %s", rule.transport, rule.target, err)
+			}
+		}(rule)
+	}
+}
+
+// Notifies about the pages among the changed and removed files of a change
+func (joki *joki) notifyChange(r *http.Request, message string, changed, removed []string) {
+	if joki.notifier == nil {
+		return
+	}
+	author := joki.author(r)
+	for event, files := range map[string][]string{changedEvent: changed, deletedEvent: removed} {
+		for _, file := range files {
+			if strings.HasSuffix(file, extension) && !strings.HasPrefix(file, attachmentsDir+"/") {
+				joki.notify(r, event, strings.TrimSuffix(file, extension), author, message)
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Notion appends the id of a page to its file name, "Roadmap 1f0c...9a.md"
+var notionID = regexp.MustCompile(` [0-9a-f]{32}$`)
+
+// Matches markdown links and images with their destination
+var markdownLink = regexp.MustCompile(`(!?\[[^\]]*\])\(([^)\s]+)\)`)
+
+// Matches the "Key: Value" lines Notion writes for the properties of a
+// database row
+var notionProperty = regexp.MustCompile(`^([^:\s][^:]*): (.*)$`)
+
+// notionImport maps the files of a Notion export to wiki titles
+type notionImport struct {
+	ns     string
+	files  map[string]*zip.File
+	titles map[string]string // path without extension -> title
+	taken  map[string]bool
+}
+
+// Returns the name of a page from its file or directory name
+func notionName(file string) string {
+	return notionID.ReplaceAllString(strings.TrimSuffix(file, path.Ext(file)), "")
+}
+
+// Returns the title of the page or database at p, a path of the export
+// without extension. Directories hold the subpages of the page with the
+// same name, so "Roadmap id/Q1 id" is Roadmap/Q1.
+func (n *notionImport) title(p string) string {
+	if t, ok := n.titles[p]; ok {
+		return t
+	}
+	parent := n.ns
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		parent = n.title(p[:i])
+	}
+	t := importTitle(parent, notionName(path.Base(p)), n.taken)
+	n.titles[p] = t
+	return t
+}
+
+// Points the links of a page written at file to the wiki pages and copies
+// the images and files it links to into its attachments. Returns the page
+// and the attachment files written.
+func (joki *joki) fixNotionLinks(n *notionImport, file, title string, body []byte) ([]byte, []string, error) {
+	var changed []string
+	var err error
+	body = markdownLink.ReplaceAllFunc(body, func(link []byte) []byte {
+		m := markdownLink.FindSubmatch(link)
+		dest := string(m[2])
+		if strings.Contains(dest, "://") || strings.HasPrefix(dest, "#") || strings.HasPrefix(dest, "mailto:") {
+			return link
+		}
+		target, uerr := url.PathUnescape(dest)
+		if uerr != nil {
+			return link
+		}
+		target = path.Join(path.Dir(file), target)
+
+		switch ext := path.Ext(target); {
+		case ext == ".md" || ext == ".csv":
+			if _, ok := n.files[target]; ok {
+				return []byte(string(m[1]) + "(" + VIEW_PATH + n.title(strings.TrimSuffix(target, ext)) + ")")
+			}
+		case n.files[target] != nil:
+			name := attachmentName(target)
+			if aerr := joki.importAttachment(title, name, n.files[target]); aerr != nil {
+				err = aerr
+				return link
+			}
+			changed = append(changed, attachmentFile(title, name))
+			return []byte(string(m[1]) + "(" + FILES_PATH + title + "/" + name + ")")
+		}
+		log.Printf("%s: %s is missing from the export", file, target)
+		return link
+	})
+	return body, changed, err
+}
+
+// Turns the property lines below the heading of a database row into front
+// matter, so "Tags: a, b" becomes tags and the rest can be read by scripts
+func notionFrontMatter(body []byte) []byte {
+	lines := strings.Split(string(body), "\n")
+	start := 0
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "# ") {
+		start = 1
+	}
+	for start < len(lines) && strings.TrimSpace(lines[start]) == "" {
+		start++
+	}
+	end := start
+	for end < len(lines) && notionProperty.MatchString(lines[end]) {
+		end++
+	}
+	if end == start {
+		return body
+	}
+
+	var fm strings.Builder
+	fm.WriteString("---\n")
+	for _, line := range lines[start:end] {
+		m := notionProperty.FindStringSubmatch(line)
+		key := strings.Replace(strings.ToLower(strings.TrimSpace(m[1])), " ", "-", -1)
+		value := strings.TrimSpace(m[2])
+		if key == "tags" {
+			value = "[" + value + "]"
+		}
+		fm.WriteString(key + ": " + value + "\n")
+	}
+	fm.WriteString("---\n")
+	if heading := strings.TrimSpace(strings.Join(lines[:start], "\n")); heading != "" {
+		fm.WriteString(heading + "\n\n")
+	}
+	return []byte(fm.String() + strings.TrimLeft(strings.Join(lines[end:], "\n"), "\n"))
+}
+
+// Renders a database as a table of its rows, linking the rows that have a
+// page of their own
+func (n *notionImport) databasePage(p string, f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	cr := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	cr.FieldsPerRecord = -1
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	// The first column names the rows, like the pages in the directory
+	// of the database
+	rowPages := make(map[string]string)
+	for file := range n.files {
+		if path.Dir(file) == p && path.Ext(file) == ".md" {
+			rowPages[notionName(path.Base(file))] = n.title(strings.TrimSuffix(file, ".md"))
+		}
+	}
+	for i, row := range rows {
+		if i > 0 && len(row) > 0 && rowPages[row[0]] != "" {
+			row[0] = "[" + rowPages[row[0]] + "]"
+		}
+	}
+	return []byte("# " + notionName(path.Base(p)) + "\n\n" + markdownTable(rows)), nil
+}
+
+// Imports a Notion workspace exported as "Markdown & CSV" into namespace ns.
+// Subpages nest below their page, databases become pages with a table of
+// their rows and each row a page with its properties as front matter.
+func (joki *joki) importNotion(zipFile, ns string) error {
+	if ns != "" && !validPageTitle(ns) {
+		return fmt.Errorf("namespace \"%s\" is invalid", ns)
+	}
+	z, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+
+	n := &notionImport{ns: ns, files: make(map[string]*zip.File),
+		titles: make(map[string]string), taken: make(map[string]bool)}
+	var names []string
+	for _, f := range z.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		if path.Ext(f.Name) == ".zip" {
+			return errors.New("the export contains " + f.Name + ", unpack it and import that")
+		}
+		n.files[f.Name] = f
+		names = append(names, f.Name)
+	}
+	sort.Strings(names) // pages are titled in a stable order
+
+	var changed []string
+	imported := 0
+	for _, name := range names {
+		ext := path.Ext(name)
+		p := strings.TrimSuffix(name, ext)
+		var body []byte
+		switch {
+		case ext == ".csv" && !strings.HasSuffix(p, "_all"):
+			// Notion writes every database twice, _all has hidden columns too
+			if body, err = n.databasePage(p, n.files[name]); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+		case ext == ".md":
+			r, err := n.files[name].Open()
+			if err != nil {
+				return err
+			}
+			body, err = ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+		default:
+			continue
+		}
+
+		title := n.title(p)
+		body, files, err := joki.fixNotionLinks(n, name, title, body)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		changed = append(changed, files...)
+		if db := path.Dir(p); ext == ".md" && (n.files[db+".csv"] != nil || n.files[db+"_all.csv"] != nil) {
+			body = notionFrontMatter(body)
+		}
+		if joki.exists(title) {
+			log.Printf("Overwriting %s, its current version is kept in the history", title)
+		}
+		if _, err := joki.storePage(title, body, ""); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		changed = append(changed, pageFile(title))
+		imported++
+	}
+	log.Printf("Imported %d pages from %s", imported, zipFile)
+
+	if joki.git != nil {
+		return joki.git.commit("gowiki", "Import "+filepath.Base(zipFile), changed, nil)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The settings of a namespace are the front matter of its page below this
+// one, Namespaces/Projects for the pages in Projects. Only admins may change
+// them, they grant access to the pages.
+//
+//	---
+//	template: HowTo
+//	required-tags: [project]
+//	default-readers: [@staff]
+//	default-editors: [ann]
+//	accent: "#8e44ad"
+//	---
+const namespaceConfigs = "Namespaces"
+
+// Colors the pages of a namespace may be marked with, #rgb, #rrggbb or a name
+var accentColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3}|#[0-9a-fA-F]{6}|[a-zA-Z]+)$`)
+
+// NamespaceConfig holds the defaults of the pages in a namespace
+type NamespaceConfig struct {
+	Template     string   // of _templates/ new pages start from
+	RequiredTags []string // every page must be tagged with
+	Readers      []string // of the pages listing none themselves
+	Editors      []string // of the pages listing none themselves
+	Accent       string   // color the pages are marked with
+}
+
+// Returns the namespace page title holds the settings of, or ""
+func configNamespace(title string) string {
+	if ns := strings.TrimPrefix(title, namespaceConfigs+"/"); ns != title {
+		return ns
+	}
+	return ""
+}
+
+// Reads the settings of a namespace from the body of its page. An invalid
+// accent is left out.
+func namespaceConfig(body []byte) NamespaceConfig {
+	meta, _ := frontMatter(body)
+	c := NamespaceConfig{Template: meta["template"], Readers: metaList(meta, "default-readers"), Editors: metaList(meta, "default-editors")}
+	for _, tag := range metaList(meta, "required-tags") {
+		c.RequiredTags = append(c.RequiredTags, strings.ToLower(tag))
+	}
+	if accentColor.MatchString(meta["accent"]) {
+		c.Accent = meta["accent"]
+	}
+	return c
+}
+
+// Returns the settings of the namespaces of title, the innermost namespace
+// setting a value wins
+func (idx *metaIndex) namespaceConfig(title string) NamespaceConfig {
+	idx.RLock()
+	defer idx.RUnlock()
+	return idx.namespaceConfigLocked(title)
+}
+
+func (idx *metaIndex) namespaceConfigLocked(title string) NamespaceConfig {
+	var c NamespaceConfig
+	for ns := namespace(title); ns != ""; ns = namespace(ns) {
+		outer := idx.configs[ns]
+		if c.Template == "" {
+			c.Template = outer.Template
+		}
+		if c.RequiredTags == nil {
+			c.RequiredTags = outer.RequiredTags
+		}
+		if c.Readers == nil {
+			c.Readers = outer.Readers
+		}
+		if c.Editors == nil {
+			c.Editors = outer.Editors
+		}
+		if c.Accent == "" {
+			c.Accent = outer.Accent
+		}
+	}
+	return c
+}
+
+// Returns meta with the readers and editors of the namespace if it lists
+// none
+func (c NamespaceConfig) apply(meta PageMeta) PageMeta {
+	if meta.Readers == nil {
+		meta.Readers = c.Readers
+	}
+	if meta.Editors == nil {
+		meta.Editors = c.Editors
+	}
+	return meta
+}
+
+func (idx *metaIndex) setConfig(ns string, c NamespaceConfig) {
+	idx.Lock()
+	defer idx.Unlock()
+	idx.configs[ns] = c
+}
+
+func (idx *metaIndex) removeConfig(ns string) {
+	idx.Lock()
+	defer idx.Unlock()
+	delete(idx.configs, ns)
+}
+
+// Returns an error naming the tags the namespace of title requires that
+// body lacks
+func (joki *joki) checkRequiredTags(title string, body []byte) error {
+	tags := pageMeta(body).Tags
+	var missing []string
+	for _, tag := range joki.meta.namespaceConfig(title).RequiredTags {
+		if !containsString(tags, tag) {
+			missing = append(missing, tag)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("pages in %s must be tagged %s", namespace(title), strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Adds the tags the namespace of title requires to body
+func (joki *joki) addRequiredTags(title string, body []byte) []byte {
+	tags := pageMeta(body).Tags
+	missing := false
+	for _, tag := range joki.meta.namespaceConfig(title).RequiredTags {
+		if !containsString(tags, tag) {
+			tags, missing = append(tags, tag), true
+		}
+	}
+	if !missing {
+		return body
+	}
+	return setMeta(body, "tags", "["+strings.Join(tags, ", ")+"]")
+}
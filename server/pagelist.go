@@ -0,0 +1,215 @@
+package server
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Titles on a page of the list of pages
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// Columns the list of pages can be sorted by
+const (
+	titleColumn    = "title"
+	modifiedColumn = "modified"
+	sizeColumn     = "size"
+	authorColumn   = "author"
+)
+
+func validColumn(column string) bool {
+	switch column {
+	case titleColumn, modifiedColumn, sizeColumn, authorColumn:
+		return true
+	}
+	return false
+}
+
+// Returns the title, size and metadata of a page for the list of pages
+func (joki *joki) pageInfo(title string) (PageInfo, error) {
+	modTime, err := joki.modTime(title)
+	if err != nil {
+		return PageInfo{}, err
+	}
+	meta := joki.meta.get(title)
+	return PageInfo{
+		Title:     title,
+		Modified:  modTime,
+		Size:      joki.pageSize(title),
+		Freshness: joki.freshness(modTime),
+		Tags:      meta.Tags,
+		Author:    meta.Author,
+		Draft:     meta.Draft,
+	}, nil
+}
+
+// Sorts pages by column. Ties, like pages of the same author, stay sorted
+// by title.
+func sortPages(pages []PageInfo, column string, desc bool) {
+	sort.Slice(pages, func(i, j int) bool {
+		a, b := pages[i], pages[j]
+		if desc && column != titleColumn {
+			a, b = b, a
+		}
+		switch column {
+		case titleColumn:
+			if desc {
+				return a.Title > b.Title
+			}
+		case modifiedColumn:
+			if !a.Modified.Equal(b.Modified) {
+				return a.Modified.Before(b.Modified)
+			}
+		case sizeColumn:
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case authorColumn:
+			if x, y := strings.ToLower(a.Author), strings.ToLower(b.Author); x != y {
+				return x < y
+			}
+		}
+		return pages[i].Title < pages[j].Title
+	})
+}
+
+// Returns whether title is in the namespace and contains the query of the
+// list, ignoring case
+func (l *PageList) matches(title string) bool {
+	if l.Namespace != "" && !strings.HasPrefix(title, l.Namespace+"/") {
+		return false
+	}
+	return strings.Contains(strings.ToLower(title), strings.ToLower(l.Query))
+}
+
+// Keeps only the titles of the page Number of the list. Pages beyond the
+// last one are empty.
+func (l *PageList) paginate() {
+	if l.Number == 0 {
+		l.Number = 1
+	}
+	if l.Limit == 0 {
+		l.Limit = len(l.Pages)
+	}
+	l.Total = len(l.Pages)
+	l.Count = 1
+	if l.Limit > 0 {
+		l.Count = (l.Total + l.Limit - 1) / l.Limit
+	}
+	start := (l.Number - 1) * l.Limit
+	if start > l.Total {
+		start = l.Total
+	}
+	end := start + l.Limit
+	if end > l.Total {
+		end = l.Total
+	}
+	l.Pages = l.Pages[start:end]
+}
+
+// Returns the namespaces of titles and their parents, sorted
+func namespaces(titles []string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, title := range titles {
+		for ns := namespace(title); ns != "" && !seen[ns]; ns = namespace(ns) {
+			seen[ns] = true
+			names = append(names, ns)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the path of the list, for the pages with a tag or all
+func (l PageList) Path() string {
+	if l.Tag != "" {
+		return TAG_PATH + l.Tag
+	}
+	return PAGES_PATH
+}
+
+// Returns the URL of the list with the parameters set by change
+func (l PageList) link(change func(query url.Values)) string {
+	query := url.Values{}
+	if l.Sort != titleColumn {
+		query.Set("sort", l.Sort)
+	}
+	if l.Desc {
+		query.Set("order", "desc")
+	}
+	for key, value := range map[string]string{"freshness": l.Freshness, "ns": l.Namespace, "q": l.Query} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if l.Limit != defaultListLimit {
+		query.Set("limit", strconv.Itoa(l.Limit))
+	}
+	if l.Number > 1 {
+		query.Set("page", strconv.Itoa(l.Number))
+	}
+	change(query)
+	if len(query) == 0 {
+		return l.Path()
+	}
+	return l.Path() + "?" + query.Encode()
+}
+
+// Returns the URL of the list sorted by column, in reverse when it is
+// sorted by it already
+func (l PageList) SortURL(column string) string {
+	return l.link(func(query url.Values) {
+		query.Set("sort", column)
+		query.Del("order")
+		if column == l.Sort && !l.Desc {
+			query.Set("order", "desc")
+		}
+		query.Del("page")
+	})
+}
+
+// Returns the URL of the list of fresh, aging or stale pages, all for ""
+func (l PageList) FreshnessURL(freshness string) string {
+	return l.link(func(query url.Values) {
+		query.Del("freshness")
+		if freshness != "" {
+			query.Set("freshness", freshness)
+		}
+		query.Del("page")
+	})
+}
+
+// Returns the URL of page n of the list
+func (l PageList) PageURL(n int) string {
+	return l.link(func(query url.Values) {
+		query.Del("page")
+		if n > 1 {
+			query.Set("page", strconv.Itoa(n))
+		}
+	})
+}
+
+// Previous and Next return the numbers of the neighbouring pages of the list
+func (l PageList) Previous() int { return l.Number - 1 }
+func (l PageList) Next() int     { return l.Number + 1 }
+
+// Returns the URL of the list as JSON
+func (l PageList) JSONURL() string {
+	return l.link(func(query url.Values) { query.Set("format", "json") })
+}
+
+// Returns the arrow telling the list is sorted by column
+func (l PageList) Arrow(column string) string {
+	switch {
+	case column != l.Sort:
+		return ""
+	case l.Desc:
+		return " ▼"
+	}
+	return " ▲"
+}
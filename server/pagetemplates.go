@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// apiInstantiate is the JSON body accepted to create a page from a template
+type apiInstantiate struct {
+	Title   string            `json:"title"`
+	Vars    map[string]string `json:"vars"`
+	Message string            `json:"message"`
+}
+
+// Fills in the {{.Name}} placeholders of a template page. Date defaults to
+// today so release notes and meeting pages get it without asking.
+func instantiate(source []byte, vars map[string]string) ([]byte, error) {
+	data := map[string]string{"Date": time.Now().Format("2006-01-02")}
+	for k, v := range vars {
+		data[k] = v
+	}
+	tmpl, err := template.New("page").Option("missingkey=error").Parse(string(source))
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Creates a new page from the template page in the path,
+// e.g. POST /api/v1/templates/Templates/Release
+func (joki *joki) apiTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	if !joki.apiCanWrite(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "only POST is allowed")
+		return
+	}
+
+	source := strings.TrimPrefix(r.URL.Path, API_TEMPLATES_PATH+"/")
+	tp, err := joki.loadPage(source)
+	if !validPageTitle(source) || os.IsNotExist(err) {
+		writeJSONError(w, http.StatusNotFound, "no such template: "+source)
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !joki.apiCanReadPage(w, r, source) {
+		return
+	}
+
+	var req apiInstantiate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if !validPageTitle(req.Title) {
+		writeJSONError(w, http.StatusBadRequest, "title is invalid: "+req.Title)
+		return
+	}
+	if !joki.apiCanEditPage(w, r, req.Title) {
+		return
+	}
+	if joki.exists(req.Title) {
+		writeJSONError(w, http.StatusConflict, "page already exists: "+req.Title)
+		return
+	}
+	if err := joki.checkEditLock(r, req.Title); err != nil {
+		writeJSONError(w, changeStatus(err), err.Error())
+		return
+	}
+
+	body, err := instantiate(tp.Body, req.Vars)
+	if err == nil {
+		err = joki.checkRequiredTags(req.Title, body)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	message := req.Message
+	if message == "" {
+		message = "Create " + req.Title + " from " + source
+	}
+	if held, err := joki.holdForModeration(r, HeldEdit{Title: req.Title, Body: string(body), Message: message, API: true}); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	} else if held {
+		writeJSON(w, http.StatusAccepted, APIPage{Title: req.Title, Body: string(body), Modified: time.Now()})
+		return
+	}
+	if err := joki.throttle(r, req.Title); err != nil {
+		setRetryAfter(w, err)
+		writeJSONError(w, changeStatus(err), err.Error())
+		return
+	}
+	if _, err := joki.storePage(req.Title, body, joki.currentUser(r)); err != nil {
+		writeJSONError(w, changeStatus(err), err.Error())
+		return
+	}
+	if err := joki.commitChange(r, message, []string{pageFile(req.Title)}, nil); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "page saved but not committed: "+err.Error())
+		return
+	}
+
+	modTime, _ := joki.modTime(req.Title)
+	w.Header().Set("Location", API_PAGES_PATH+"/"+req.Title)
+	writeJSON(w, http.StatusCreated, APIPage{Title: req.Title, Body: string(body), Modified: modTime})
+}
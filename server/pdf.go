@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// PrintPage is a page rendered standalone for printing or PDF conversion
+type PrintPage struct {
+	Title    string
+	WikiName string
+	Modified time.Time
+	Body     template.HTML
+}
+
+// Converts a page to PDF by piping a self-contained html rendering of it
+// through the -pdf-command, which must read html on stdin and write the PDF
+// to stdout like "wkhtmltopdf --quiet - -" does.
+func (joki *joki) pdfHandler(w http.ResponseWriter, r *http.Request, title string) {
+	p, err := joki.loadPage(title)
+	if err != nil {
+		http.Error(w, err.Error(), pageErrorStatus(err))
+		return
+	}
+	args := strings.Fields(joki.pdfCommand)
+	if len(args) == 0 {
+		http.Error(w, "PDF export is disabled, start the wiki with -pdf-command", http.StatusNotImplemented)
+		return
+	}
+
+	page := &PrintPage{
+		Title:    title,
+		WikiName: joki.wikiName,
+		Body:     template.HTML(joki.renderSafe(title, p.Body)),
+	}
+	page.Modified, _ = joki.modTime(title)
+	var html bytes.Buffer
+	if err := joki.templates["print"].ExecuteTemplate(&html, "print.html", page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var pdf, stderr bytes.Buffer
+	cmd := exec.CommandContext(r.Context(), args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = &html, &pdf, &stderr
+	if err := cmd.Run(); err != nil {
+		http.Error(w, "Converting to PDF failed: "+err.Error()+"\n"+stderr.String(), http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.Replace(title, "/", "-", -1) + ".pdf"
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="`+name+`"`)
+	w.Write(pdf.Bytes())
+}
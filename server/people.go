@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Pages below this namespace are profiles of people, with their name, team
+// and contact in the front matter
+//
+//	---
+//	name: Ann Smith
+//	team: Platform
+//	contact: ann@example.com
+//	---
+const peopleNamespace = "People"
+
+// Profile is the profile page of a person
+type Profile struct {
+	Title   string
+	Name    string
+	Team    string
+	Contact string
+}
+
+// Team is a team with its members
+type Team struct {
+	Name    string
+	Members []Profile
+}
+
+// PeoplePage is the people directory, or the page of one team
+type PeoplePage struct {
+	Team  string // the team shown, "" for all of them
+	Teams []Team
+}
+
+// Returns whether page title is a profile
+func isProfile(title string) bool {
+	return strings.HasPrefix(title, peopleNamespace+"/")
+}
+
+// Reads the profile of page title. A profile naming nobody is named after
+// its page.
+func profile(title string, meta PageMeta) Profile {
+	p := Profile{Title: title, Name: meta.Fields["name"], Team: meta.Fields["team"], Contact: meta.Fields["contact"]}
+	if p.Name == "" {
+		p.Name = path.Base(title)
+	}
+	return p
+}
+
+// Lists the profiles user may see grouped by team, the teams and their
+// members sorted by name and those without a team last
+func (joki *joki) teams(user string) ([]Team, error) {
+	titles, err := joki.listPages()
+	if err != nil {
+		return nil, err
+	}
+	byTeam := make(map[string][]Profile)
+	for _, title := range titles {
+		if !isProfile(title) || !joki.visible(title, user) {
+			continue
+		}
+		p := profile(title, joki.meta.get(title))
+		byTeam[p.Team] = append(byTeam[p.Team], p)
+	}
+	teams := make([]Team, 0, len(byTeam))
+	for name, members := range byTeam {
+		sort.Slice(members, func(i, j int) bool { return strings.ToLower(members[i].Name) < strings.ToLower(members[j].Name) })
+		teams = append(teams, Team{Name: name, Members: members})
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		if (teams[i].Name == "") != (teams[j].Name == "") {
+			return teams[j].Name == ""
+		}
+		return strings.ToLower(teams[i].Name) < strings.ToLower(teams[j].Name)
+	})
+	return teams, nil
+}
+
+// Serves the people directory at /people, generated from the profiles, and
+// the page of a team at /people/<team>
+func (joki *joki) peopleHandler(w http.ResponseWriter, r *http.Request) {
+	teams, err := joki.teams(joki.currentUser(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page := &PeoplePage{Teams: teams}
+	if name := strings.TrimPrefix(r.URL.Path, PEOPLE_PATH+"/"); name != r.URL.Path && name != "" {
+		page.Teams = nil
+		for _, t := range teams {
+			if strings.EqualFold(t.Name, name) {
+				page.Team, page.Teams = t.Name, []Team{t}
+			}
+		}
+		if page.Teams == nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	joki.renderTemplate(w, r, "people", page)
+}
+
+// Returns the path of the page of a team
+func teamPath(team string) string {
+	return PEOPLE_PATH + "/" + url.PathEscape(team)
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Editors send a heartbeat this often while the edit page is open, and are
+// forgotten when it stops for presenceTimeout
+const (
+	presenceInterval = 20 * time.Second
+	presenceTimeout  = 45 * time.Second
+)
+
+// editor is a browser tab editing a page
+type editor struct {
+	name string
+	seen time.Time
+}
+
+// presence tracks who is editing which page, so others can be warned before
+// their edits conflict
+type presence struct {
+	sync.Mutex
+	pages map[string]map[string]editor // title -> tab id -> editor
+}
+
+func newPresence() *presence {
+	return &presence{pages: make(map[string]map[string]editor)}
+}
+
+// Records a heartbeat of the tab id of user name editing title
+func (p *presence) beat(title, id, name string) {
+	p.Lock()
+	defer p.Unlock()
+	if p.pages[title] == nil {
+		p.pages[title] = make(map[string]editor)
+	}
+	p.pages[title][id] = editor{name: name, seen: time.Now()}
+}
+
+// Forgets a tab that stopped editing
+func (p *presence) leave(title, id string) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.pages[title], id)
+	if len(p.pages[title]) == 0 {
+		delete(p.pages, title)
+	}
+}
+
+// Returns the names of the users editing title in other tabs than id
+func (p *presence) editors(title, id string) []string {
+	p.Lock()
+	defer p.Unlock()
+	limit := time.Now().Add(-presenceTimeout)
+	seen := make(map[string]bool)
+	names := []string{}
+	for tab, e := range p.pages[title] {
+		if e.seen.Before(limit) {
+			delete(p.pages[title], tab)
+			continue
+		}
+		if tab != id && !seen[e.name] {
+			seen[e.name] = true
+			names = append(names, e.name)
+		}
+	}
+	if len(p.pages[title]) == 0 {
+		delete(p.pages, title)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Records that a tab edits a page and answers with the others editing it:
+// POST /api/v1/presence/<title> with id=<tab id>, and leave=1 once the tab
+// stops editing
+func (joki *joki) presenceHandler(w http.ResponseWriter, r *http.Request) {
+	title := strings.TrimPrefix(r.URL.Path, PRESENCE_PATH+"/")
+	if !validPageTitle(title) {
+		writeJSONError(w, http.StatusNotFound, "no such page: "+title)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "only POST is allowed")
+		return
+	}
+	if !joki.apiCanWrite(w, r) || !joki.apiCanEditPage(w, r, title) {
+		return
+	}
+	id := r.FormValue("id")
+	if id == "" || len(id) > 64 {
+		writeJSONError(w, http.StatusBadRequest, "a heartbeat needs the id of the tab")
+		return
+	}
+	if r.FormValue("leave") != "" {
+		joki.presence.leave(title, id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	joki.presence.beat(title, id, joki.author(r))
+	writeJSON(w, http.StatusOK, struct {
+		Editors  []string `json:"editors"`
+		Interval int      `json:"interval"` // seconds until the next heartbeat
+	}{joki.presence.editors(title, id), int(presenceInterval / time.Second)})
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Renders the posted "body" like viewHandler would and returns the html
+// fragment. Links are resolved relative to the "title" field, so pages in a
+// namespace preview correctly before they are saved.
+func (joki *joki) previewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Preview requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAPIBodySize)
+	body := strings.Replace(r.FormValue("body"), "\r", "", -1)
+	title := r.FormValue("title")
+	if !validPageTitle(title) {
+		title = ""
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(joki.renderSafe(title, []byte(body)))
+}
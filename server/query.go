@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A paragraph of only {{query key=value ... fields=a,b}} in a rendered page
+var queryDirective = regexp.MustCompile(`<p>\s*\{\{\s*query\b([^}]*)\}\}\s*</p>`)
+
+// key=value or key="value with spaces"
+var queryArg = regexp.MustCompile(`([a-z0-9-]+)=(?:"([^"]*)"|(\S+))`)
+
+// pageQuery selects pages by their front matter for the query directive
+type pageQuery struct {
+	namespace string            // title prefix, "" for all pages
+	tag       string            // a tag the pages have, or one nested in it
+	where     map[string]string // front matter key -> value
+	fields    []string          // shown for every page
+	sort      string            // field to sort by, the title if empty
+}
+
+// Parses the arguments of a query directive. The rendering of quotes is
+// undone first, typographic ones included.
+func parseQuery(args string) pageQuery {
+	args = html.UnescapeString(args)
+	args = strings.NewReplacer("“", `"`, "”", `"`, "„", `"`).Replace(args)
+	q := pageQuery{where: make(map[string]string)}
+	for _, m := range queryArg.FindAllStringSubmatch(args, -1) {
+		key, value := m[1], m[2]+m[3]
+		switch key {
+		case "namespace":
+			q.namespace = strings.Trim(value, "/")
+		case "tag":
+			q.tag = strings.ToLower(value)
+		case "fields":
+			q.fields = metaList(map[string]string{key: value}, key)
+		case "sort":
+			q.sort = value
+		default:
+			q.where[key] = value
+		}
+	}
+	return q
+}
+
+// Returns whether a list field of the front matter, like tags: [a, b], or a
+// single value is value, ignoring case
+func fieldMatches(field, value string) bool {
+	for _, item := range strings.Split(field, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the pages user may see that match q
+func (joki *joki) runQuery(q pageQuery, user string) []string {
+	titles, err := joki.listPages()
+	if err != nil {
+		return nil
+	}
+	var matches []string
+	for _, title := range titles {
+		if q.namespace != "" && !strings.HasPrefix(title, q.namespace+"/") {
+			continue
+		}
+		if q.tag != "" && !joki.meta.tagged(title, q.tag) || !joki.visible(title, user) {
+			continue
+		}
+		fields := joki.meta.get(title).Fields
+		matched := true
+		for key, value := range q.where {
+			if !fieldMatches(fields[key], value) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, title)
+		}
+	}
+	if q.sort != "" {
+		sort.SliceStable(matches, func(i, j int) bool {
+			return strings.ToLower(joki.meta.get(matches[i]).Fields[q.sort]) < strings.ToLower(joki.meta.get(matches[j]).Fields[q.sort])
+		})
+	}
+	return matches
+}
+
+// Replaces the query directives of a rendered page with a table of the pages
+// user may see that match them: {{query namespace=People team=Platform
+// fields=name,contact}} lists the pages below People whose front matter says
+// team: Platform, with their name and contact. They are expanded for every
+// reader, after the page was rendered and sanitized, so the values are
+// escaped here.
+func (joki *joki) expandQueries(body []byte, user string) []byte {
+	if !queryDirective.Match(body) {
+		return body
+	}
+	return queryDirective.ReplaceAllFunc(body, func(directive []byte) []byte {
+		q := parseQuery(string(queryDirective.FindSubmatch(directive)[1]))
+		titles := joki.runQuery(q, user)
+		if len(titles) == 0 {
+			return []byte("<p><em>No pages match.</em></p>")
+		}
+		var table bytes.Buffer
+		table.WriteString(`<table class="table is-narrow"><thead><tr><th>Page</th>`)
+		for _, f := range q.fields {
+			table.WriteString("<th>" + html.EscapeString(f) + "</th>")
+		}
+		table.WriteString("</tr></thead><tbody>")
+		for _, title := range titles {
+			fields := joki.meta.get(title).Fields
+			table.WriteString(`<tr><td><a href="` + VIEW_PATH + title + `">` + title + "</a></td>")
+			for _, f := range q.fields {
+				table.WriteString("<td>" + html.EscapeString(fields[f]) + "</td>")
+			}
+			table.WriteString("</tr>")
+		}
+		table.WriteString("</tbody></table>")
+		return table.Bytes()
+	})
+}
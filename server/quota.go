@@ -0,0 +1,281 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const usageFile = ".usage.json"
+
+// PageUsage is the disk usage of a single page
+type PageUsage struct {
+	Title   string
+	Page    int64
+	History int64
+}
+
+// Total returns the size of the page and its history
+func (u PageUsage) Total() int64 {
+	return u.Page + u.History
+}
+
+// UserUsage is how much a user stored
+type UserUsage struct {
+	User string
+	Used int64
+}
+
+// UsageReport shows how much space the wiki uses
+type UsageReport struct {
+	Used      int64
+	Quota     int64
+	PageQuota int64
+	UserQuota int64
+	Pages     []PageUsage
+	Users     []UserUsage
+}
+
+// usageStore adds up the bytes of the versions and files every logged in
+// user stored. Versions replaced by others stay in the history, so nothing
+// is taken off again.
+type usageStore struct {
+	sync.Mutex
+	file string
+	used map[string]int64
+}
+
+func loadUsage(dataPath string) (*usageStore, error) {
+	s := &usageStore{file: filepath.Join(dataPath, usageFile), used: make(map[string]int64)}
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.used); err != nil {
+		return nil, fmt.Errorf("%s: %s", s.file, err)
+	}
+	return s, nil
+}
+
+// Returns how many bytes user stored
+func (s *usageStore) get(user string) int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.used[user]
+}
+
+// Records that user stored size more bytes
+func (s *usageStore) add(user string, size int64) error {
+	if user == "" {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.used[user] += size
+	data, err := json.MarshalIndent(s.used, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.file, data, 0600)
+}
+
+// Returns the users who stored anything, most first
+func (s *usageStore) list() []UserUsage {
+	s.Lock()
+	defer s.Unlock()
+	list := make([]UserUsage, 0, len(s.used))
+	for user, used := range s.used {
+		list = append(list, UserUsage{User: user, Used: used})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Used > list[j].Used })
+	return list
+}
+
+// Parses sizes like 512, 20K, 100M or 1G into bytes
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(s), "B"))
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("size \"%s\" is invalid", s)
+	}
+	return n * multiplier, nil
+}
+
+// Formats a size in bytes for humans
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// Returns the size of all files below dir. Hard links, like those of
+// attachments to their content, count once.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	seen := make(map[int64][]os.FileInfo) // size -> files
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		for _, other := range seen[info.Size()] {
+			if os.SameFile(info, other) {
+				return nil
+			}
+		}
+		seen[info.Size()] = append(seen[info.Size()], info)
+		size += info.Size()
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return size, err
+}
+
+// quotaError reports that a change would exceed the quota
+type quotaError struct {
+	msg string
+}
+
+func (e *quotaError) Error() string {
+	return e.msg
+}
+
+// Returns the size of a page or 0 if it does not exist
+func (joki *joki) pageSize(title string) int64 {
+	body, err := joki.store.Load(title)
+	if err != nil {
+		return 0
+	}
+	return int64(len(body))
+}
+
+// Returns the size of the files attached to a page, without those of the
+// pages in its namespace
+func (joki *joki) attachmentsSize(title string) int64 {
+	files, err := ioutil.ReadDir(joki.attachmentPath(title))
+	if err != nil {
+		return 0
+	}
+	var size int64
+	for _, f := range files {
+		if !f.IsDir() {
+			size += f.Size()
+		}
+	}
+	return size
+}
+
+// Refuses to save a page for user if the wiki, the page with its
+// attachments or what user stored would grow beyond their quotas
+func (joki *joki) checkQuota(title string, newSize int, user string) error {
+	if err := joki.checkQuotaGrowth(int64(newSize)-joki.pageSize(title), title); err != nil {
+		return err
+	}
+	if err := joki.checkPageQuota(title, int64(newSize)+joki.attachmentsSize(title), title); err != nil {
+		return err
+	}
+	return joki.checkUserQuota(user, int64(newSize), title)
+}
+
+// Refuses to store what if page title with its attachments would take size
+// bytes, more than the page quota
+func (joki *joki) checkPageQuota(title string, size int64, what string) error {
+	if joki.pageQuota > 0 && size > joki.pageQuota {
+		return &quotaError{fmt.Sprintf("saving %s would exceed the quota of %s for %s with its attachments",
+			what, formatSize(joki.pageQuota), title)}
+	}
+	return nil
+}
+
+// Refuses to store what if user would store more than the user quota with
+// it. Admins and users who are not logged in have no quota.
+func (joki *joki) checkUserQuota(user string, growth int64, what string) error {
+	if joki.userQuota <= 0 || user == "" || joki.adminUser(user) {
+		return nil
+	}
+	if used := joki.usage.get(user); used+growth > joki.userQuota {
+		return &quotaError{fmt.Sprintf("saving %s would exceed your quota of %s, you stored %s already",
+			what, formatSize(joki.userQuota), formatSize(used))}
+	}
+	return nil
+}
+
+// Refuses to store what if the wiki would grow by growth bytes beyond its quota
+func (joki *joki) checkQuotaGrowth(growth int64, what string) error {
+	if joki.quota <= 0 {
+		return nil
+	}
+	used, err := dirSize(joki.dataPath)
+	if err != nil {
+		return err
+	}
+	if used+growth > joki.quota {
+		return &quotaError{fmt.Sprintf("saving %s would exceed the wiki quota of %s, %s are already used",
+			what, formatSize(joki.quota), formatSize(used))}
+	}
+	return nil
+}
+
+// Lists the space used by every page and its history
+func (joki *joki) usageHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := joki.listPages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report := &UsageReport{Quota: joki.quota, PageQuota: joki.pageQuota, UserQuota: joki.userQuota, Users: joki.usage.list()}
+	if report.Used, err = dirSize(joki.dataPath); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, title := range titles {
+		usage := PageUsage{Title: title, Page: joki.pageSize(title)}
+		// Summed from the revisions as the history of Foo also holds Foo/Bar's
+		revs, err := joki.revisions(title)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, rev := range revs {
+			usage.History += rev.Size
+		}
+		report.Pages = append(report.Pages, usage)
+	}
+	sort.Slice(report.Pages, func(i, j int) bool {
+		return report.Pages[i].Total() > report.Pages[j].Total()
+	})
+
+	joki.renderTemplate(w, r, "usage", report)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pages changed this long ago get half the recency boost
+const recencyHalfLife = 30 * 24 * time.Hour
+
+// searchRanking weighs the results of every search backend beyond how well
+// their text matches the query
+type searchRanking struct {
+	title      float64            // added for every query term in the title
+	recency    float64            // added for a page changed just now, less for older ones
+	tag        float64            // added for every query term the page is tagged with
+	namespaces map[string]float64 // multiplies the score of the pages in a namespace
+}
+
+// Returns the ranking of the settings in c
+func newSearchRanking(c *Config) (*searchRanking, error) {
+	ranking := &searchRanking{
+		title:      c.SearchTitleBoost,
+		recency:    c.SearchRecencyBoost,
+		tag:        c.SearchTagBoost,
+		namespaces: make(map[string]float64),
+	}
+	for _, weight := range strings.Split(c.SearchNamespaceWeights, ",") {
+		if weight = strings.TrimSpace(weight); weight == "" {
+			continue
+		}
+		parts := strings.SplitN(weight, "=", 2)
+		ns := strings.Trim(strings.TrimSpace(parts[0]), "/")
+		if len(parts) != 2 || !validPageTitle(ns) {
+			return nil, fmt.Errorf("namespace weight \"%s\" is invalid, use Namespace=1.5", weight)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("namespace weight \"%s\" is invalid, use Namespace=1.5", weight)
+		}
+		ranking.namespaces[ns] = w
+	}
+	return ranking, nil
+}
+
+// Returns the weight of the innermost namespace of title that has one
+func (s *searchRanking) namespaceWeight(title string) (string, float64, bool) {
+	for ns := namespace(title); ns != ""; ns = namespace(ns) {
+		if w, ok := s.namespaces[ns]; ok {
+			return ns, w, true
+		}
+	}
+	return "", 1, false
+}
+
+// Ranks results of query anew, best first, and explains every score
+func (joki *joki) rank(results []SearchResult, query string) {
+	terms := tokenize(query)
+	s := joki.ranking
+	for i := range results {
+		result := &results[i]
+		result.Explain = []string{fmt.Sprintf("text matches %.2f", result.Score)}
+		lowerTitle := strings.ToLower(result.Title)
+		tags := joki.meta.get(result.Title).Tags
+		for _, term := range terms {
+			if s.title != 0 && strings.Contains(lowerTitle, term) {
+				result.Score += s.title
+				result.Explain = append(result.Explain, fmt.Sprintf("%s in the title +%.2f", term, s.title))
+			}
+			if s.tag != 0 && containsString(tags, term) {
+				result.Score += s.tag
+				result.Explain = append(result.Explain, fmt.Sprintf("tagged %s +%.2f", term, s.tag))
+			}
+		}
+		if s.recency != 0 {
+			if modTime, err := joki.modTime(result.Title); err == nil {
+				boost := s.recency * math.Pow(0.5, float64(time.Since(modTime))/float64(recencyHalfLife))
+				result.Score += boost
+				result.Explain = append(result.Explain, fmt.Sprintf("changed %s ago +%.2f",
+					time.Since(modTime).Round(time.Hour), boost))
+			}
+		}
+		if ns, w, ok := s.namespaceWeight(result.Title); ok {
+			result.Score *= w
+			result.Explain = append(result.Explain, fmt.Sprintf("in %s ×%.2f", ns, w))
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Title < results[j].Title
+	})
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Serves the markdown of a page as it is stored, for scripts, external
+// editors and mirrors. The ETag and Last-Modified headers let them skip
+// unchanged pages.
+func (joki *joki) rawHandler(w http.ResponseWriter, r *http.Request, title string) {
+	span := startSpan(r, "load page", attribute.String("page.title", title))
+	p, err := joki.loadPage(title)
+	span.End()
+	if err != nil {
+		http.Error(w, err.Error(), pageErrorStatus(err))
+		return
+	}
+	modTime, _ := joki.modTime(title)
+	name := path.Base(title) + extension
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("ETag", `"`+p.Version()+`"`)
+	http.ServeContent(w, r, name, modTime, bytes.NewReader(p.Body))
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	registrationsFile    = ".registrations.json"
+	verificationLifetime = 2 * 24 * time.Hour // unverified registrations are dropped after it
+	minPasswordLength    = 8
+)
+
+var validUserName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,31}$`)
+
+// Registration is an account a visitor asked for. It waits for the
+// visitor to follow the link mailed to them and then for an admin to
+// approve it.
+type Registration struct {
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Hash     string    `json:"hash"`
+	Token    string    `json:"token"` // of the verification link
+	Verified bool      `json:"verified"`
+	Created  time.Time `json:"created"`
+}
+
+// RegisterPage is the form to register and tells what happens next
+type RegisterPage struct {
+	Name     string
+	Email    string
+	Error    string
+	Sent     bool // the mail with the verification link
+	Verified bool
+}
+
+// RegistrationsPage lists the registrations for admins to approve
+type RegistrationsPage struct {
+	Registrations []Registration
+	Error         string
+}
+
+// registrationStore keeps the registrations in memory and in a file of the
+// data path
+type registrationStore struct {
+	sync.Mutex
+	file    string
+	pending map[string]Registration // by name
+}
+
+func loadRegistrations(dataPath string) (*registrationStore, error) {
+	s := &registrationStore{file: filepath.Join(dataPath, registrationsFile), pending: make(map[string]Registration)}
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var registrations []Registration
+	if err := json.Unmarshal(data, &registrations); err != nil {
+		return nil, fmt.Errorf("%s: %s", s.file, err)
+	}
+	for _, reg := range registrations {
+		s.pending[reg.Name] = reg
+	}
+	return s, nil
+}
+
+// Drops the registrations that were not verified in time. The caller holds
+// the lock.
+func (s *registrationStore) expire() {
+	limit := time.Now().Add(-verificationLifetime)
+	for name, reg := range s.pending {
+		if !reg.Verified && reg.Created.Before(limit) {
+			delete(s.pending, name)
+		}
+	}
+}
+
+// Returns the registrations, the verified ones first, oldest first
+func (s *registrationStore) list() []Registration {
+	s.Lock()
+	defer s.Unlock()
+	s.expire()
+	registrations := make([]Registration, 0, len(s.pending))
+	for _, reg := range s.pending {
+		registrations = append(registrations, reg)
+	}
+	sort.Slice(registrations, func(i, j int) bool {
+		a, b := registrations[i], registrations[j]
+		if a.Verified != b.Verified {
+			return a.Verified
+		}
+		return a.Created.Before(b.Created)
+	})
+	return registrations
+}
+
+// Changes the registrations and writes them all to the file. fn returns an
+// error to leave them unchanged.
+func (s *registrationStore) update(fn func(pending map[string]Registration) error) error {
+	s.Lock()
+	defer s.Unlock()
+	s.expire()
+	if err := fn(s.pending); err != nil {
+		return err
+	}
+	registrations := make([]Registration, 0, len(s.pending))
+	for _, reg := range s.pending {
+		registrations = append(registrations, reg)
+	}
+	data, err := json.MarshalIndent(registrations, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.file, data, 0600)
+}
+
+// Registration keeps its accounts in -users and mails the links with -smtp
+func (joki *joki) registrationUnavailable() error {
+	if joki.usersFile == "" || joki.mailer == nil {
+		return fmt.Errorf("registration needs -users and -smtp")
+	}
+	return nil
+}
+
+// Registers a visitor and mails them the link to verify their address
+func (joki *joki) registerHandler(w http.ResponseWriter, r *http.Request) {
+	page := &RegisterPage{}
+	if r.Method != http.MethodPost {
+		joki.renderTemplate(w, r, "register", page)
+		return
+	}
+	if err := joki.registrationUnavailable(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	page.Name = r.FormValue("name")
+	password := r.FormValue("password")
+	address, err := mail.ParseAddress(r.FormValue("email"))
+	_, taken := joki.userHash(page.Name)
+	switch {
+	case !validUserName.MatchString(page.Name):
+		page.Error = "Names are up to 32 letters, digits, dots, dashes and underscores"
+	case taken:
+		page.Error = page.Name + " is taken"
+	case err != nil:
+		page.Email = r.FormValue("email")
+		page.Error = "The email address is invalid"
+	case len(password) < minPasswordLength:
+		page.Email = address.Address
+		page.Error = fmt.Sprintf("Passwords have at least %d characters", minPasswordLength)
+	}
+	if page.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		joki.renderTemplate(w, r, "register", page)
+		return
+	}
+	page.Email = address.Address
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reg := Registration{Name: page.Name, Email: page.Email, Hash: string(hash), Token: hex.EncodeToString(token), Created: time.Now()}
+	err = joki.registrations.update(func(pending map[string]Registration) error {
+		if _, ok := pending[reg.Name]; ok {
+			return fmt.Errorf("%s is taken", reg.Name)
+		}
+		pending[reg.Name] = reg
+		return nil
+	})
+	if err != nil {
+		page.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		joki.renderTemplate(w, r, "register", page)
+		return
+	}
+
+	link := joki.siteURL(r) + REGISTER_VERIFY_PATH + "?token=" + url.QueryEscape(reg.Token)
+	text := "Follow this link to confirm your registration as " + reg.Name + " on " + joki.wikiName + ":\n\n" + link +
+		"\n\nAn admin approves your account afterwards. If you did not register, ignore this mail."
+	if err := joki.mailer.mail(reg.Email, "Confirm your registration on "+joki.wikiName, text); err != nil {
+		joki.registrations.update(func(pending map[string]Registration) error {
+			delete(pending, reg.Name)
+			return nil
+		})
+		http.Error(w, "Could not send the confirmation mail: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	log.Printf("%s registered with %s", reg.Name, reg.Email)
+	page.Sent = true
+	joki.renderTemplate(w, r, "register", page)
+}
+
+// Marks the registration of a verification link as verified
+func (joki *joki) registerVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	page := &RegisterPage{Verified: true}
+	err := joki.registrations.update(func(pending map[string]Registration) error {
+		for name, reg := range pending {
+			if token != "" && reg.Token == token {
+				reg.Verified = true
+				pending[name] = reg
+				page.Name = name
+				return nil
+			}
+		}
+		return fmt.Errorf("the link is invalid or expired")
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	joki.renderTemplate(w, r, "register", page)
+}
+
+// Lists the registrations and approves or rejects them
+func (joki *joki) registrationsHandler(w http.ResponseWriter, r *http.Request) {
+	page := &RegistrationsPage{}
+	if r.Method == http.MethodPost {
+		name := r.FormValue("name")
+		approve := r.FormValue("approve") != ""
+		var reg Registration
+		err := joki.registrations.update(func(pending map[string]Registration) error {
+			var ok bool
+			if reg, ok = pending[name]; !ok {
+				return fmt.Errorf("%s did not register", name)
+			}
+			if approve {
+				if !reg.Verified {
+					return fmt.Errorf("%s has not confirmed their email address yet", name)
+				}
+				if err := joki.createUser(name, []byte(reg.Hash), false); err != nil {
+					return err
+				}
+			}
+			delete(pending, name)
+			return nil
+		})
+		if err == nil {
+			action := "rejected"
+			if approve {
+				action = "approved"
+				text := "An admin approved your account " + name + " on " + joki.wikiName + ", you can log in now:\n\n" + joki.siteURL(r) + LOGIN_PATH
+				if err := joki.mailer.mail(reg.Email, "Welcome to "+joki.wikiName, text); err != nil {
+					log.Printf("Could not tell %s about the approval: %s", name, err)
+				}
+			}
+			log.Printf("%s %s the registration of %s", joki.author(r), action, name)
+			http.Redirect(w, r, REGISTRATIONS_PATH, http.StatusFound)
+			return
+		}
+		page.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	page.Registrations = joki.registrations.list()
+	joki.renderTemplate(w, r, "registrations", page)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A page like the ones of a typical wiki, with interlinks, code and a table
+var benchPage = []byte(strings.Repeat(`# Meeting notes
+
+Discussed the *release* of [[ReleasePlan]] with the FrontEnd team, see
+[the tracker](https://example.com/issues/42) and RFC 9110.
+
+- [x] Update the **changelog**
+- [ ] Ask BackEnd about the ~~old~~ new API
+
+| Who   | What          |
+|-------|---------------|
+| Alice | Release notes |
+| Bob   | Packaging     |
+
+`+"```go\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n```"+`
+
+> Quoted from an <em>earlier</em> page, with <script>alert(1)</script>
+
+`, 4))
+
+func benchJoki(b *testing.B, engine string) *joki {
+	c := DefaultConfig()
+	c.Path = b.TempDir()
+	c.Markdown = engine
+	joki, err := newJoki(&c)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := joki.open(&c); err != nil {
+		b.Fatal(err)
+	}
+	return joki
+}
+
+func BenchmarkSanitize(b *testing.B) {
+	joki := benchJoki(b, gomarkdownEngine)
+	html := joki.renderMarkdown("Notes", benchPage)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		joki.sanitize(html)
+	}
+}
+
+// Sanitizes with a policy built for every page, as before it was shared
+func BenchmarkSanitizeNewPolicy(b *testing.B) {
+	joki := benchJoki(b, gomarkdownEngine)
+	html := joki.renderMarkdown("Notes", benchPage)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		newPolicy().SanitizeBytes(html)
+	}
+}
+
+func BenchmarkRenderGoldmark(b *testing.B) {
+	joki := benchJoki(b, goldmarkEngine)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		joki.renderSafe("Notes", benchPage)
+	}
+}
+
+// Renders with a goldmark converter made for every page, as before they
+// were shared
+func BenchmarkRenderGoldmarkNewConverter(b *testing.B) {
+	joki := benchJoki(b, goldmarkEngine)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		joki.goldmarks = sync.Map{}
+		joki.renderSafe("Notes", benchPage)
+	}
+}
+
+func BenchmarkRenderGomarkdown(b *testing.B) {
+	joki := benchJoki(b, gomarkdownEngine)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		joki.renderSafe("Notes", benchPage)
+	}
+}
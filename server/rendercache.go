@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/binary"
+	"log"
+	"time"
+)
+
+// Rendered pages are dropped after a day at the latest, so a shared cache
+// does not keep pages nobody views any more
+const renderLifetime = 24 * time.Hour
+
+// renderCache keeps the sanitized html of the most recently viewed pages.
+// Entries are keyed by title and carry the modification time of the page, so
+// pages changed on disk behind the wiki's back are rendered again. As
+// interlinks render differently once their target exists, creating or
+// removing any page purges the whole cache.
+type renderCache struct {
+	cache Cache // nil if rendered pages are not cached
+}
+
+func newRenderCache(cache Cache) *renderCache {
+	return &renderCache{cache: cache}
+}
+
+func (c *renderCache) get(title string, modTime time.Time) ([]byte, bool) {
+	if c.cache == nil {
+		return nil, false
+	}
+	value, ok := c.cache.Get(title)
+	if !ok || len(value) < 8 || int64(binary.BigEndian.Uint64(value)) != modTime.UnixNano() {
+		return nil, false
+	}
+	return value[8:], true
+}
+
+func (c *renderCache) put(title string, modTime time.Time, html []byte) {
+	if c.cache == nil {
+		return
+	}
+	value := make([]byte, 8+len(html))
+	binary.BigEndian.PutUint64(value, uint64(modTime.UnixNano()))
+	copy(value[8:], html)
+	if err := c.cache.Set(title, value, renderLifetime); err != nil {
+		log.Printf("Could not cache %s: %s", title, err)
+	}
+}
+
+// Drops a page that was changed
+func (c *renderCache) invalidate(title string) {
+	if c.cache == nil {
+		return
+	}
+	if err := c.cache.Delete(title); err != nil {
+		log.Printf("Could not drop %s from the render cache: %s", title, err)
+	}
+}
+
+// Drops all pages, after pages were created, renamed or deleted
+func (c *renderCache) purge() {
+	if c.cache == nil {
+		return
+	}
+	if err := c.cache.Clear(""); err != nil {
+		log.Printf("Could not purge the render cache: %s", err)
+	}
+}
+
+// Renders a stored page to safe html, using the cache if possible
+func (joki *joki) renderStored(p *Page) []byte {
+	modTime, err := joki.modTime(p.Title)
+	if err != nil {
+		return joki.renderSafe(p.Title, p.Body)
+	}
+	if html, ok := joki.renderCache.get(p.Title, modTime); ok {
+		return html
+	}
+	html, ok := joki.renderChecked(p.Title, p.Body)
+	if ok {
+		joki.renderCache.put(p.Title, modTime, html)
+	}
+	return html
+}
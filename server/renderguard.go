@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"html"
+	"log"
+	"time"
+)
+
+// Shown above pages that were not rendered completely
+const (
+	renderTimeoutWarning = `<p class="notification is-warning">Rendering this page took too long, its markdown is shown instead.</p>`
+	renderSizeWarning    = `<p class="notification is-warning">This page is too large to show completely, it is cut short.</p>`
+)
+
+// Renders in the background and gives up after the render timeout, showing
+// the markdown of the page instead and returning false. The render keeps
+// running until it is done and holds a slot of the render limiter meanwhile,
+// but its result is dropped; a panic of a renderer only loses the page.
+func (joki *joki) renderGuarded(title string, content []byte) ([]byte, bool) {
+	done := make(chan []byte, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("Rendering %s failed: %v", title, err)
+				done <- nil
+			}
+		}()
+		done <- joki.sanitize(joki.renderMarkdown(title, content))
+	}()
+	var timeout <-chan time.Time
+	if joki.renderTimeout > 0 {
+		timer := time.NewTimer(joki.renderTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case rendered := <-done:
+		if rendered != nil {
+			return rendered, true
+		}
+	case <-timeout:
+		log.Printf("Rendering %s took longer than %s", title, joki.renderTimeout)
+		joki.renders.keep(finished)
+	}
+	return []byte(renderTimeoutWarning + "<pre>" + html.EscapeString(string(joki.cut(content))) + "</pre>"), false
+}
+
+// Cuts b to the maximum render size, at the end of a line if there is one
+func (joki *joki) cut(b []byte) []byte {
+	if joki.maxRenderSize <= 0 || int64(len(b)) <= joki.maxRenderSize {
+		return b
+	}
+	b = b[:joki.maxRenderSize]
+	if i := bytes.LastIndexByte(b, '\n'); i > 0 {
+		b = b[:i+1]
+	}
+	return b
+}
+
+// Renders the markdown of page title to html that is safe to show. Pages
+// taking too long or rendering to more than the maximum size are cut short
+// with a warning, so pathological markdown cannot hang the server.
+func (joki *joki) renderSafe(title string, content []byte) []byte {
+	rendered, _ := joki.renderChecked(title, content)
+	return rendered
+}
+
+// Is renderSafe, also returning false if the markdown is shown as rendering
+// failed or took too long. That may be different next time, so it is not
+// worth caching.
+func (joki *joki) renderChecked(title string, content []byte) ([]byte, bool) {
+	rendered, ok := joki.renderGuarded(title, content)
+	if joki.maxRenderSize <= 0 || int64(len(rendered)) <= joki.maxRenderSize {
+		return rendered, ok
+	}
+	log.Printf("Rendering %s made %d bytes, it is cut to %d", title, len(rendered), joki.maxRenderSize)
+	// cutting may leave a tag open, sanitizing again closes it safely
+	return append([]byte(renderSizeWarning), joki.sanitize(joki.cut(rendered))...), ok
+}
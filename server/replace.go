@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// ReplaceResult is the preview of a replacement on a single page
+type ReplaceResult struct {
+	Title   string
+	Version string
+	Count   int
+	Lines   []DiffLine
+}
+
+// ReplacePage is the find and replace tool with its preview or outcome
+type ReplacePage struct {
+	Find    string
+	Replace string
+	Regex   bool
+	Error   string
+	Results []ReplaceResult
+	Applied []string
+	Skipped []string
+}
+
+// Compiles the search of the replace form
+func (rp *ReplacePage) pattern() (*regexp.Regexp, error) {
+	if rp.Regex {
+		return regexp.Compile(rp.Find)
+	}
+	return regexp.Compile(regexp.QuoteMeta(rp.Find))
+}
+
+// Applies the replacement to body, expanding $1 style groups for regexes
+func (rp *ReplacePage) apply(re *regexp.Regexp, body []byte) []byte {
+	if rp.Regex {
+		return re.ReplaceAll(body, []byte(rp.Replace))
+	}
+	return re.ReplaceAllLiteral(body, []byte(rp.Replace))
+}
+
+// Returns the changes of a replacement on every page it matches, leaving
+// generated pages to their source
+func (joki *joki) replacePreview(rp *ReplacePage, re *regexp.Regexp) ([]ReplaceResult, error) {
+	titles, err := joki.listPages()
+	if err != nil {
+		return nil, err
+	}
+	var results []ReplaceResult
+	for _, title := range titles {
+		p, err := joki.loadPage(title)
+		if err != nil {
+			return nil, err
+		}
+		count := len(re.FindAllIndex(p.Body, -1))
+		if count == 0 || pageMeta(p.Body).Generated {
+			continue
+		}
+		var changed []DiffLine
+		for _, line := range diffLines(string(p.Body), string(rp.apply(re, p.Body))) {
+			if line.Kind != diffSame {
+				changed = append(changed, line)
+			}
+		}
+		results = append(results, ReplaceResult{Title: title, Version: p.Version(), Count: count, Lines: changed})
+	}
+	return results, nil
+}
+
+// Replaces on the pages ticked in the preview and records all of them as a
+// single change. Pages edited since the preview, generated, on legal hold
+// or being edited by someone else are skipped.
+func (joki *joki) replaceApply(r *http.Request, rp *ReplacePage, re *regexp.Regexp) error {
+	var changed []string
+	for _, title := range r.PostForm["page"] {
+		if !validPageTitle(title) {
+			continue
+		}
+		p, err := joki.loadPage(title)
+		if err != nil || p.Version() != r.PostFormValue("version."+title) || pageMeta(p.Body).Generated ||
+			joki.holds.held(title) || joki.checkEditLock(r, title) != nil {
+			rp.Skipped = append(rp.Skipped, title)
+			continue
+		}
+		if _, err := joki.storePage(title, rp.apply(re, p.Body), joki.currentUser(r)); err != nil {
+			return err
+		}
+		rp.Applied = append(rp.Applied, title)
+		changed = append(changed, pageFile(title))
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	message := "Replace " + strconv.Quote(rp.Find) + " with " + strconv.Quote(rp.Replace) +
+		" in " + strconv.Itoa(len(changed)) + " pages"
+	return joki.commitChange(r, message, changed, nil)
+}
+
+// Finds a literal or regex on all pages and replaces it in a batch after
+// showing a preview in which pages can be left out
+func (joki *joki) replaceHandler(w http.ResponseWriter, r *http.Request) {
+	rp := &ReplacePage{
+		Find:    r.FormValue("find"),
+		Replace: r.FormValue("replace"),
+		Regex:   r.FormValue("regex") != "",
+	}
+	if rp.Find == "" {
+		joki.renderTemplate(w, r, "replace", rp)
+		return
+	}
+	re, err := rp.pattern()
+	if err != nil {
+		rp.Error = err.Error()
+		joki.renderTemplate(w, r, "replace", rp)
+		return
+	}
+
+	if r.Method == http.MethodPost && r.FormValue("apply") != "" {
+		if err := joki.replaceApply(r, rp, re); err != nil {
+			http.Error(w, err.Error(), changeStatus(err))
+			return
+		}
+	} else if rp.Results, err = joki.replacePreview(rp, re); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	joki.renderTemplate(w, r, "replace", rp)
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxReportDetails = 2000
+	maxOpenReports   = 1000 // beyond them readers cannot report until admins catch up
+	maxPageReports   = 20   // open reports of a page, more add nothing but noise
+	maxHourlyReports = 5    // by a reader, anonymous readers are told apart by address
+	reportedLifetime = 24 * time.Hour
+)
+
+// Reasons readers report pages for
+var reportReasons = []string{"spam", "abuse", "copyright", "wrong", "other"}
+
+// Report is a page a reader reported to the moderators
+type Report struct {
+	ID       string    `json:"id"`
+	Page     string    `json:"page"`
+	Reason   string    `json:"reason"`
+	Details  string    `json:"details,omitempty"`
+	Reporter string    `json:"reporter"`
+	Time     time.Time `json:"time"`
+}
+
+// ReportPage is the form to report a page
+type ReportPage struct {
+	Title   string
+	Reasons []string
+	Sent    bool
+}
+
+// Shows the form to report a page and files the reports
+func (joki *joki) reportHandler(w http.ResponseWriter, r *http.Request, title string) {
+	if !joki.exists(title) {
+		http.NotFound(w, r)
+		return
+	}
+	page := &ReportPage{Title: title, Reasons: reportReasons}
+	if r.Method != http.MethodPost {
+		joki.renderTemplate(w, r, "report", page)
+		return
+	}
+
+	reason := r.FormValue("reason")
+	if !containsString(reportReasons, reason) {
+		http.Error(w, "Unknown reason: "+reason, http.StatusBadRequest)
+		return
+	}
+	details := strings.TrimSpace(r.FormValue("details"))
+	if len(details) > maxReportDetails {
+		cut := maxReportDetails
+		for cut > 0 && !isRuneStart(details[cut]) {
+			cut--
+		}
+		details = details[:cut]
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reporter := joki.currentUser(r)
+	if reporter == "" {
+		reporter = anonymousAuthor
+	}
+	report := Report{ID: hex.EncodeToString(id), Page: title, Reason: reason, Details: details, Reporter: reporter, Time: time.Now()}
+	filed := false
+	err := joki.moderation.update(func(s *moderationStore) error {
+		if len(s.Reports) >= maxOpenReports {
+			return fmt.Errorf("too many reports are open, try again later")
+		}
+		pageReports := 0
+		for _, open := range s.Reports {
+			// Reporting again adds nothing but noise
+			if open.Page == title && open.Reporter == report.Reporter && report.Reporter != anonymousAuthor {
+				return nil
+			}
+			if open.Page == title {
+				pageReports++
+			}
+		}
+		if pageReports >= maxPageReports {
+			return nil
+		}
+		var err error
+		filed, err = joki.countReport(r, title)
+		if err != nil || !filed {
+			return err
+		}
+		s.Reports = append(s.Reports, report)
+		return nil
+	})
+	if err, ok := err.(*reportLimitError); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(err.retry.Seconds())+1))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	page.Sent = true
+	if !filed {
+		joki.renderTemplate(w, r, "report", page)
+		return
+	}
+	log.Printf("%s reported %s as %s", report.Reporter, title, reason)
+	message := "Reported as " + reason
+	if details != "" {
+		message += ": " + details
+	}
+	joki.notify(r, reportEvent, title, report.Reporter, message)
+	joki.renderTemplate(w, r, "report", page)
+}
+
+// reportLimitError reports that a reader filed too many reports in the last
+// hour
+type reportLimitError struct {
+	retry time.Duration
+}
+
+func (e *reportLimitError) Error() string {
+	return fmt.Sprintf("You may only report %d pages an hour, try again in %s", maxHourlyReports, e.retry.Round(time.Minute))
+}
+
+// Counts a report of page title by the reader of r. It returns false if the
+// reader reported the page already in the last day, so anonymous readers
+// cannot file the same report over and over, and a reportLimitError if the
+// reader filed too many reports in the last hour.
+func (joki *joki) countReport(r *http.Request, title string) (bool, error) {
+	reader := joki.currentUser(r)
+	if reader == "" {
+		ip := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		reader = "address " + ip
+	} else {
+		reader = "user " + reader
+	}
+	if _, ok := joki.reporters.Get("page " + title + " " + reader); ok {
+		return false, nil
+	}
+	now := time.Now()
+	var counted, recent []time.Time
+	if data, ok := joki.reporters.Get(reader); ok {
+		if err := json.Unmarshal(data, &counted); err != nil {
+			log.Printf("Dropping the reports counted for %s: %s", reader, err)
+		}
+	}
+	for _, at := range counted {
+		if now.Sub(at) < time.Hour {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) >= maxHourlyReports {
+		return false, &reportLimitError{retry: time.Hour - now.Sub(recent[0])}
+	}
+	data, err := json.Marshal(append(recent, now))
+	if err != nil {
+		return false, err
+	}
+	if err := joki.reporters.Set(reader, data, time.Hour); err != nil {
+		log.Printf("Could not count a report of %s: %s", reader, err)
+	}
+	if err := joki.reporters.Set("page "+title+" "+reader, []byte{1}, reportedLifetime); err != nil {
+		log.Printf("Could not count a report of %s: %s", reader, err)
+	}
+	return true, nil
+}
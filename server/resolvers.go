@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// LinkResolver turns references like [RFC 9110] or [JIRA-123] into links to
+// other systems. URL gets the whole reference and the part after the name.
+type LinkResolver struct {
+	URL   func(ref, id string) string
+	Glyph string // open-iconic icon shown before the link
+}
+
+// Resolvers by the name references start with. More can be registered by
+// plugins with registerLinkResolver or in the config with -link.
+var linkResolvers = map[string]*LinkResolver{
+	"RFC": {
+		URL:   func(ref, id string) string { return "https://www.rfc-editor.org/rfc/rfc" + id },
+		Glyph: "document",
+	},
+	"CVE": {
+		URL:   func(ref, id string) string { return "https://www.cve.org/CVERecord?id=" + ref },
+		Glyph: "warning",
+	},
+}
+
+// A reference is a name and an id separated by a dash or a space
+const refPattern = `\[[A-Z][A-Za-z]*[- ][A-Za-z0-9][A-Za-z0-9.-]*\]`
+
+var refRegex = regexp.MustCompile(`^([A-Z][A-Za-z]*)[- ]([A-Za-z0-9][A-Za-z0-9.-]*)$`)
+var resolverName = regexp.MustCompile("^[A-Z][A-Za-z]*$")
+var glyphRegex = regexp.MustCompile("^[a-z-]+$")
+
+// Registers a resolver for references starting with name
+func registerLinkResolver(name, glyph string, url func(ref, id string) string) {
+	linkResolvers[name] = &LinkResolver{URL: url, Glyph: glyph}
+}
+
+// Returns the url a reference like "RFC 9110" links to and its resolver,
+// if it has one
+func externalURL(ref string) (string, *LinkResolver) {
+	m := refRegex.FindStringSubmatch(ref)
+	if m == nil {
+		return "", nil
+	}
+	resolver, ok := linkResolvers[m[1]]
+	if !ok {
+		return "", nil
+	}
+	return resolver.URL(ref, m[2]), resolver
+}
+
+// Returns the html of a link to a reference like "RFC 9110", if it has a
+// resolver
+func externalLink(ref string) (string, bool) {
+	url, resolver := externalURL(ref)
+	if resolver == nil {
+		return "", false
+	}
+	return "<a href=\"" + template.HTMLEscapeString(url) + "\">" +
+		"<span class=\"oi\" data-glyph=\"" + resolver.Glyph + "\"></span> " + ref + "</a>", true
+}
+
+// LinkResolverFlag is a flag registering resolvers given as "NAME=URL
+// [glyph]". In the URL {ref} is replaced by the whole reference and {id} by
+// the part after the name, e.g. JIRA=https://jira.example.com/browse/{ref}
+// bug. Resolvers are shared by all wikis of the process.
+type LinkResolverFlag struct{}
+
+func (LinkResolverFlag) String() string { return "" }
+
+func (LinkResolverFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 || !resolverName.MatchString(kv[0]) {
+			return fmt.Errorf("link resolver \"%s\" is invalid, expected NAME=URL", item)
+		}
+		glyph := "external-link"
+		fields := strings.Fields(kv[1])
+		if len(fields) == 2 && glyphRegex.MatchString(fields[1]) {
+			glyph = fields[1]
+		} else if len(fields) != 1 {
+			return fmt.Errorf("link resolver \"%s\" is invalid, expected NAME=URL [glyph]", item)
+		}
+		url := fields[0]
+		registerLinkResolver(kv[0], glyph, func(ref, id string) string {
+			return strings.NewReplacer("{ref}", ref, "{id}", id).Replace(url)
+		})
+	}
+	return nil
+}
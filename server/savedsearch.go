@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	savedSearchesFile = ".searches.json"
+	maxSavedSearches  = 100 // of a user
+	maxSearchName     = 100 // characters
+)
+
+// SavedSearch is a query a user keeps under a name
+type SavedSearch struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// SavedSearchesPage lists the saved searches of a user
+type SavedSearchesPage struct {
+	Searches []SavedSearch
+}
+
+// savedSearchStore keeps the saved searches of every user in a file of the
+// data path. Without authentication everybody shares those of "".
+type savedSearchStore struct {
+	sync.Mutex
+	file     string
+	searches map[string][]SavedSearch // user -> searches by name
+}
+
+func loadSavedSearches(dataPath string) (*savedSearchStore, error) {
+	s := &savedSearchStore{file: filepath.Join(dataPath, savedSearchesFile), searches: make(map[string][]SavedSearch)}
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.searches); err != nil {
+		return nil, fmt.Errorf("%s: %s", s.file, err)
+	}
+	return s, nil
+}
+
+// Returns the searches user saved, by name
+func (s *savedSearchStore) list(user string) []SavedSearch {
+	s.Lock()
+	defer s.Unlock()
+	return append([]SavedSearch(nil), s.searches[user]...)
+}
+
+// Saves search for user, replacing the one of the same name
+func (s *savedSearchStore) save(user string, search SavedSearch) error {
+	s.Lock()
+	defer s.Unlock()
+	searches := s.searches[user]
+	for i := range searches {
+		if searches[i].Name == search.Name {
+			searches[i] = search
+			return s.writeLocked()
+		}
+	}
+	if len(searches) >= maxSavedSearches {
+		return fmt.Errorf("you may save %d searches, delete some first", maxSavedSearches)
+	}
+	searches = append(searches, search)
+	sort.Slice(searches, func(i, j int) bool { return searches[i].Name < searches[j].Name })
+	s.searches[user] = searches
+	return s.writeLocked()
+}
+
+// Deletes the search of user called name
+func (s *savedSearchStore) remove(user, name string) error {
+	s.Lock()
+	defer s.Unlock()
+	searches := s.searches[user]
+	for i := range searches {
+		if searches[i].Name == name {
+			if s.searches[user] = append(searches[:i], searches[i+1:]...); len(s.searches[user]) == 0 {
+				delete(s.searches, user)
+			}
+			return s.writeLocked()
+		}
+	}
+	return nil
+}
+
+func (s *savedSearchStore) writeLocked() error {
+	data, err := json.MarshalIndent(s.searches, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.file, data, 0600)
+}
+
+// Lists the saved searches of the user, saves new ones and deletes them
+func (joki *joki) savedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	user := joki.currentUser(r)
+	if r.Method == http.MethodPost {
+		var err error
+		if name := r.FormValue("delete"); name != "" {
+			err = joki.savedSearches.remove(user, name)
+		} else {
+			search := SavedSearch{Name: strings.TrimSpace(r.FormValue("name")), Query: strings.TrimSpace(r.FormValue("q"))}
+			if search.Name == "" || utf8.RuneCountInString(search.Name) > maxSearchName || search.Query == "" {
+				http.Error(w, fmt.Sprintf("A saved search needs a query and a name of up to %d characters", maxSearchName), http.StatusBadRequest)
+				return
+			}
+			err = joki.savedSearches.save(user, search)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, SAVED_SEARCHES_PATH, http.StatusFound)
+		return
+	}
+	joki.renderTemplate(w, r, "searches", &SavedSearchesPage{Searches: joki.savedSearches.list(user)})
+}
+
+// Serves the pages matching ?q= as an Atom feed of their changes, newest
+// first, so readers can follow a topic
+func (joki *joki) searchFeedHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.FormValue("q")
+	results, err := joki.search.Search(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	matches := make(map[string]bool, len(results))
+	for _, result := range results {
+		matches[result.Title] = true
+	}
+	changes, err := joki.recentChanges(r, changesLimit(r), func(title string) bool { return matches[title] })
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	q := "?q=" + url.QueryEscape(query)
+	joki.writeFeed(w, r, "pages matching "+query, SEARCH_FEED_PATH+q, SEARCH_PATH+q, changes)
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"html"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const snippetContext = 80 // characters shown around the first match
+
+// SearchResult is a page matching a search query
+type SearchResult struct {
+	Title   string
+	Section string // heading of the section with the first match, "" above the first heading
+	Anchor  string // id of that heading
+	Snippet template.HTML
+	Score   float64
+	Explain []string // how the score came about
+}
+
+// SearchPage is the result page of a search
+type SearchPage struct {
+	Query   string
+	Results []SearchResult
+	Explain bool // show how the results were ranked
+	CanSave bool // the reader may save the search
+}
+
+// SearchBackend indexes the text of pages and finds the pages matching a
+// query. The embedded searchIndex suits most wikis, large ones can offload
+// indexing to Elasticsearch or Meilisearch.
+type SearchBackend interface {
+	Set(title string, body []byte) error
+	Remove(title string) error
+	Search(query string) ([]SearchResult, error)
+}
+
+// searchIndex is an in-memory inverted index over the text of all pages
+type searchIndex struct {
+	sync.RWMutex
+	postings map[string]map[string]int // term -> title -> occurrences
+	bodies   map[string]string
+	sections map[string][]pageSection
+	analyzer *searchAnalyzer
+}
+
+// pageSection is a heading of a page and where its section starts in the
+// markdown
+type pageSection struct {
+	heading
+	start int
+}
+
+func newSearchIndex(analyzer *searchAnalyzer) *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string]int),
+		bodies:   make(map[string]string),
+		sections: make(map[string][]pageSection),
+		analyzer: analyzer,
+	}
+}
+
+// Splits text into lowercased words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func (idx *searchIndex) Set(title string, body []byte) error {
+	idx.Lock()
+	defer idx.Unlock()
+	idx.removeLocked(title)
+
+	idx.bodies[title] = string(body)
+	idx.sections[title] = pageSections(string(body))
+	idx.addLocked(title, string(body))
+	return nil
+}
+
+func (idx *searchIndex) addLocked(title, body string) {
+	for _, term := range idx.analyzer.terms(body) {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[string]int)
+		}
+		idx.postings[term][title]++
+	}
+}
+
+func (idx *searchIndex) Remove(title string) error {
+	idx.Lock()
+	defer idx.Unlock()
+	idx.removeLocked(title)
+	return nil
+}
+
+func (idx *searchIndex) removeLocked(title string) {
+	body, ok := idx.bodies[title]
+	if !ok {
+		return
+	}
+	for _, term := range idx.analyzer.terms(body) {
+		delete(idx.postings[term], title)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.bodies, title)
+	delete(idx.sections, title)
+}
+
+// Returns the pages containing all query terms in body or title, scored by
+// tf-idf. Title matches are boosted by the ranking like those of every
+// backend.
+func (idx *searchIndex) Search(query string) ([]SearchResult, error) {
+	words := tokenize(query)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	idx.reindex()
+	terms := idx.analyzer.terms(query)
+	highlight := idx.analyzer.variants(query)
+
+	idx.RLock()
+	defer idx.RUnlock()
+
+	results := []SearchResult{}
+	for title, body := range idx.bodies {
+		lowerTitle := strings.ToLower(title)
+		score := 0.0
+		matchesAll := true
+		for i, term := range terms {
+			tf := idx.postings[term][title]
+			inTitle := strings.Contains(lowerTitle, words[i])
+			if tf == 0 && !inTitle {
+				matchesAll = false
+				break
+			}
+			if tf > 0 {
+				idf := math.Log(1 + float64(len(idx.bodies))/float64(len(idx.postings[term])))
+				score += (1 + math.Log(float64(tf))) * idf
+			}
+		}
+		if matchesAll {
+			result := SearchResult{Title: title, Snippet: snippet(body, highlight), Score: score}
+			result.setSection(idx.sections[title], body, highlight)
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
+
+// Indexes all pages anew when the synonyms changed
+func (idx *searchIndex) reindex() {
+	idx.Lock()
+	defer idx.Unlock()
+	changed, err := idx.analyzer.reload()
+	if err != nil {
+		log.Printf("Keeping the synonyms read before: %s", err)
+		return
+	}
+	if !changed {
+		return
+	}
+	idx.postings = make(map[string]map[string]int)
+	for title, body := range idx.bodies {
+		idx.addLocked(title, body)
+	}
+}
+
+// Finds the headings of a page in its markdown, in order. Headings whose
+// text is not found as written, like those with emphasis, are left out and
+// count to the section before them.
+func pageSections(body string) []pageSection {
+	var sections []pageSection
+	pos := 0
+	for _, h := range pageHeadings([]byte(body)) {
+		if h.Text == "" || h.ID == "" {
+			continue
+		}
+		if i := strings.Index(body[pos:], h.Text); i >= 0 {
+			sections = append(sections, pageSection{heading: h, start: pos + i})
+			pos += i + len(h.Text)
+		}
+	}
+	return sections
+}
+
+// Links the result to the section of the first query term in body
+func (result *SearchResult) setSection(sections []pageSection, body string, terms []string) {
+	first := firstMatch(matchText(body), terms)
+	if first < 0 {
+		return
+	}
+	for _, section := range sections {
+		if section.start > first {
+			break
+		}
+		result.Section, result.Anchor = section.Text, section.ID
+	}
+}
+
+// Returns body lowercased for matching query terms, or as it is if
+// lowercasing changed byte offsets
+func matchText(body string) string {
+	lower := strings.ToLower(body)
+	if len(lower) != len(body) {
+		return body // match case sensitive
+	}
+	return lower
+}
+
+// Returns the offset of the first of terms in text, or -1
+func firstMatch(text string, terms []string) int {
+	first := -1
+	for _, term := range terms {
+		if i := strings.Index(text, term); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+	return first
+}
+
+// Cuts the text around the first query term and highlights all terms in it
+func snippet(body string, terms []string) template.HTML {
+	lower := matchText(body)
+	first := firstMatch(lower, terms)
+	if first < 0 {
+		first = 0
+	}
+
+	start, end := first-snippetContext, first+snippetContext
+	if start < 0 {
+		start = 0
+	}
+	if end > len(body) {
+		end = len(body)
+	}
+	// Do not cut in the middle of a multibyte character
+	for start > 0 && !isRuneStart(body[start]) {
+		start--
+	}
+	for end < len(body) && !isRuneStart(body[end]) {
+		end++
+	}
+
+	var out strings.Builder
+	if start > 0 {
+		out.WriteString("&hellip;")
+	}
+	text, lowerText := body[start:end], lower[start:end]
+	for len(text) > 0 {
+		pos, length := -1, 0
+		for _, term := range terms {
+			if i := strings.Index(lowerText, term); i >= 0 && (pos < 0 || i < pos || (i == pos && len(term) > length)) {
+				pos, length = i, len(term)
+			}
+		}
+		if pos < 0 {
+			out.WriteString(html.EscapeString(text))
+			break
+		}
+		out.WriteString(html.EscapeString(text[:pos]))
+		out.WriteString("<mark>" + html.EscapeString(text[pos:pos+length]) + "</mark>")
+		text, lowerText = text[pos+length:], lowerText[pos+length:]
+	}
+	if end < len(body) {
+		out.WriteString("&hellip;")
+	}
+	return template.HTML(out.String())
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// Searches the text of all pages
+func (joki *joki) searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.FormValue("q")
+	results, err := joki.search.Search(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user := joki.currentUser(r)
+	visible := results[:0]
+	for _, result := range results {
+		// an external index may still know pages deleted while it was away
+		if joki.visible(result.Title, user) && joki.store.Exists(result.Title) {
+			visible = append(visible, result)
+		}
+	}
+	results = visible
+	joki.rank(results, query)
+	joki.renderTemplate(w, r, "search", &SearchPage{
+		Query:   query,
+		Results: results,
+		Explain: r.FormValue("explain") != "",
+		CanSave: !joki.authEnabled() || user != "",
+	})
+}
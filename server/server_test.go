@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The password of the users of test wikis
+const testPassword = "secret"
+
+// testWiki is a wiki in a temporary directory with the users ann, bob and
+// cy, bob being the admin and cy in the group @ops
+type testWiki struct {
+	t       *testing.T
+	joki    *joki
+	handler http.Handler
+}
+
+// Opens a test wiki, with the settings of configure if it is not nil
+func newTestWiki(t *testing.T, configure func(c *Config)) *testWiki {
+	t.Helper()
+	dir := t.TempDir()
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var users strings.Builder
+	for _, name := range []string{"ann", "bob", "cy"} {
+		fmt.Fprintf(&users, "%s:%s\n", name, hash)
+	}
+	c := DefaultConfig()
+	c.Path = filepath.Join(dir, "data")
+	c.UsersFile = filepath.Join(dir, "users")
+	c.GroupsFile = filepath.Join(dir, "groups")
+	c.Admins = "bob"
+	if err := ioutil.WriteFile(c.UsersFile, []byte(users.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(c.GroupsFile, []byte("ops: cy\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if configure != nil {
+		configure(&c)
+	}
+	wiki, err := Open(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renders := newLimiter(c.MaxRenders, c.MaxQueue, c.QueueTimeout)
+	exports := newLimiter(c.MaxExports, c.MaxQueue, c.QueueTimeout)
+	return &testWiki{t: t, joki: wiki.joki, handler: wiki.joki.handler(renders, exports)}
+}
+
+// Sends a request with a form as user, logged in with basic auth unless
+// user is "". A nil form sends no body.
+func (tw *testWiki) request(method, target, user string, form url.Values) *httptest.ResponseRecorder {
+	if form == nil {
+		return tw.send(method, target, user, "", "")
+	}
+	return tw.send(method, target, user, "application/x-www-form-urlencoded", form.Encode())
+}
+
+// Sends a request with body of contentType as user, logged in with basic
+// auth unless user is ""
+func (tw *testWiki) send(method, target, user, contentType, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if user != "" {
+		r.SetBasicAuth(user, testPassword)
+	}
+	w := httptest.NewRecorder()
+	tw.handler.ServeHTTP(w, r)
+	return w
+}
+
+// Stores a page as the admin
+func (tw *testWiki) store(title, body string) {
+	tw.t.Helper()
+	if _, err := tw.joki.storePage(title, []byte(body), "bob"); err != nil {
+		tw.t.Fatal(err)
+	}
+}
+
+// Returns the content of page title, "" if it does not exist
+func (tw *testWiki) body(title string) string {
+	p, err := tw.joki.loadPage(title)
+	if err != nil {
+		return ""
+	}
+	return string(p.Body)
+}
+
+// Saves page title through the editor as user
+func (tw *testWiki) save(title, body, user string) *httptest.ResponseRecorder {
+	return tw.request(http.MethodPost, SAVE_PATH+title, user, url.Values{"title": {title}, "body": {body}})
+}
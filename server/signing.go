@@ -0,0 +1,336 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every stored version of a page can be signed with a key of the server.
+// The signatures are appended to a log in the data path, one line per
+// version with the time, the title, the SHA-256 of the content and the
+// ed25519 signature of the title and that hash. A revision whose content has
+// no valid signature for its page in the log, or a page that is not the
+// version signed last for it, has been changed outside of the wiki. Deleting
+// or renaming a page signs deletedSignature in place of the hash, so a
+// signed page that is gone without it was removed outside of the wiki.
+const signaturesFile = ".signatures"
+
+const deletedSignature = "deleted"
+
+// Problem is a page or revision that failed verification
+type Problem struct {
+	Title    string
+	Revision string
+	Problem  string
+}
+
+// VerifyPage shows the result of verifying the signatures
+type VerifyPage struct {
+	Checked  int
+	Problems []Problem
+}
+
+// signer signs page content and remembers what it signed
+type signer struct {
+	sync.Mutex
+	key    ed25519.PrivateKey
+	file   string
+	signed map[string]bool   // signedMessage of the signed versions
+	latest map[string]string // title -> hex SHA-256 of the version signed last, or deletedSignature
+}
+
+// Returns what is signed for the content with hex SHA-256 id of page title.
+// The title is part of it so content is only valid for the page it was
+// saved as; titles have no spaces.
+func signedMessage(title, id string) string {
+	return title + " " + id
+}
+
+// Loads the ed25519 key in PKCS #8 PEM from file, which is created with a
+// new key if it does not exist
+func loadSigningKey(file string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			return nil, err
+		}
+		log.Printf("Created signing key %s", file)
+		return key, nil
+	} else if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", file)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 key", file)
+	}
+	return key, nil
+}
+
+// Opens the signature log of the data path for signing with the key in
+// keyFile. Lines with a signature the key did not make are ignored.
+func openSigner(keyFile, dataPath string) (*signer, error) {
+	key, err := loadSigningKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	s := &signer{key: key, file: filepath.Join(dataPath, signaturesFile), signed: make(map[string]bool), latest: make(map[string]string)}
+	f, err := os.Open(s.file)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 {
+			continue
+		}
+		title, id := fields[1], fields[2]
+		sig, err := base64.StdEncoding.DecodeString(fields[3])
+		if err != nil || !ed25519.Verify(key.Public().(ed25519.PublicKey), []byte(signedMessage(title, id)), sig) {
+			continue
+		}
+		if id != deletedSignature {
+			s.signed[signedMessage(title, id)] = true
+		}
+		s.latest[title] = id
+	}
+	return s, scanner.Err()
+}
+
+// Signs a version of page title as its latest, unless it is already. Going
+// back to an earlier version signs it again.
+func (s *signer) sign(title string, body []byte) error {
+	hash := sha256.Sum256(body)
+	id := hex.EncodeToString(hash[:])
+	s.Lock()
+	defer s.Unlock()
+	if s.latest[title] == id {
+		return nil
+	}
+	if err := s.append(title, id); err != nil {
+		return err
+	}
+	s.signed[signedMessage(title, id)] = true
+	s.latest[title] = id
+	return nil
+}
+
+// Signs that page title was deleted or renamed in the wiki, unless nothing
+// was signed for it or its deletion is already
+func (s *signer) remove(title string) error {
+	s.Lock()
+	defer s.Unlock()
+	if id, ok := s.latest[title]; !ok || id == deletedSignature {
+		return nil
+	}
+	if err := s.append(title, deletedSignature); err != nil {
+		return err
+	}
+	s.latest[title] = deletedSignature
+	return nil
+}
+
+// Appends the signature of id for page title to the log
+func (s *signer) append(title, id string) error {
+	sig := ed25519.Sign(s.key, []byte(signedMessage(title, id)))
+	f, err := os.OpenFile(s.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s %s %s %s\n", time.Now().UTC().Format(time.RFC3339),
+		title, id, base64.StdEncoding.EncodeToString(sig))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Returns whether body was signed as a version of page title, and whether it
+// is the version signed last
+func (s *signer) valid(title string, body []byte) (signed, latest bool) {
+	hash := sha256.Sum256(body)
+	id := hex.EncodeToString(hash[:])
+	s.Lock()
+	defer s.Unlock()
+	return s.signed[signedMessage(title, id)], s.latest[title] == id
+}
+
+// Returns the pages whose last signature is not a deletion, sorted
+func (s *signer) titles() []string {
+	s.Lock()
+	defer s.Unlock()
+	var titles []string
+	for title, id := range s.latest {
+		if id != deletedSignature {
+			titles = append(titles, title)
+		}
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+// Signs the history and then the current version of a page renamed to
+// title, as their signatures name the old title
+func (joki *joki) resign(title string) error {
+	if joki.signer == nil {
+		return nil
+	}
+	revs, err := joki.revisions(title)
+	if err != nil {
+		return err
+	}
+	ids := []string{currentRevision}
+	for _, rev := range revs {
+		ids = append(ids, rev.ID)
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		body, err := joki.loadRevision(title, ids[i])
+		if err != nil {
+			return err
+		}
+		if err := joki.signer.sign(title, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errNoSigning = errors.New("signing is not enabled, see -signing-key")
+
+// Checks every page and revision against the signatures, and that every
+// signed page still exists unless its deletion is signed. With sign, content
+// without a signature is signed instead of reported, to start signing a wiki
+// that already has pages, and missing pages are signed as deleted.
+func (joki *joki) verifySignatures(sign bool) (*VerifyPage, error) {
+	if joki.signer == nil {
+		return nil, errNoSigning
+	}
+	titles, err := joki.listPages()
+	if err != nil {
+		return nil, err
+	}
+	vp := &VerifyPage{}
+	check := func(title, id string) error {
+		body, err := joki.loadRevision(title, id)
+		if err != nil {
+			return err
+		}
+		vp.Checked++
+		signed, latest := joki.signer.valid(title, body)
+		if signed && (latest || id != currentRevision) {
+			return nil
+		}
+		if sign {
+			return joki.signer.sign(title, body)
+		}
+		problem := "content does not match any signature of the page"
+		if signed {
+			problem = "an earlier version than the one signed last"
+		}
+		vp.Problems = append(vp.Problems, Problem{Title: title, Revision: id, Problem: problem})
+		return nil
+	}
+	for _, title := range titles {
+		revs, err := joki.revisions(title)
+		if err != nil {
+			return nil, err
+		}
+		// The oldest first, so signing existing pages leaves the current
+		// version signed last
+		for i := len(revs) - 1; i >= 0; i-- {
+			if err := check(title, revs[i].ID); err != nil {
+				return nil, err
+			}
+		}
+		if err := check(title, currentRevision); err != nil {
+			return nil, err
+		}
+	}
+	listed := make(map[string]bool, len(titles))
+	for _, title := range titles {
+		listed[title] = true
+	}
+	for _, title := range joki.signer.titles() {
+		if listed[title] {
+			continue
+		}
+		if sign {
+			if err := joki.signer.remove(title); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		vp.Problems = append(vp.Problems, Problem{Title: title, Revision: currentRevision, Problem: "missing without a signed deletion"})
+	}
+	return vp, nil
+}
+
+// Verifies the signatures and prints the problems, for -verify
+func (joki *joki) verifyCommand(sign bool) error {
+	vp, err := joki.verifySignatures(sign)
+	if err != nil {
+		return err
+	}
+	for _, p := range vp.Problems {
+		fmt.Printf("%s %s: %s\n", p.Title, p.Revision, p.Problem)
+	}
+	if sign {
+		log.Printf("All %d versions are signed", vp.Checked)
+		return nil
+	}
+	log.Printf("Verified %d versions, %d problems", vp.Checked, len(vp.Problems))
+	if len(vp.Problems) > 0 {
+		return fmt.Errorf("%d versions were changed outside of the wiki", len(vp.Problems))
+	}
+	return nil
+}
+
+// Shows the result of verifying the signatures
+func (joki *joki) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	vp, err := joki.verifySignatures(false)
+	if err == errNoSigning {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	joki.renderTemplate(w, r, "verify", vp)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// SitemapNode is a page or namespace in the sitemap. Namespaces without a
+// page of their own are listed but not linked.
+type SitemapNode struct {
+	Name     string
+	Title    string
+	Exists   bool
+	Children []*SitemapNode
+}
+
+// Returns the child called name, adding it if needed
+func (n *SitemapNode) child(name string) *SitemapNode {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	title := name
+	if n.Title != "" {
+		title = n.Title + "/" + name
+	}
+	c := &SitemapNode{Name: name, Title: title}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+func (n *SitemapNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+// Builds the namespace tree of titles
+func sitemap(titles []string) *SitemapNode {
+	root := &SitemapNode{}
+	for _, title := range titles {
+		n := root
+		for _, name := range strings.Split(title, "/") {
+			n = n.child(name)
+		}
+		n.Exists = true
+	}
+	root.sort()
+	return root
+}
+
+// Shows all pages as a tree of namespaces
+func (joki *joki) sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	titles, err := joki.listPages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	joki.renderTemplate(w, r, "sitemap", sitemap(joki.visiblePages(r, titles)))
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The formats a wiki can be exported in
+const (
+	htmlExport   = "html"
+	hugoExport   = "hugo"
+	jekyllExport = "jekyll"
+)
+
+// A line containing only {{toc}} in the markdown of a page
+var tocLine = regexp.MustCompile(`^\s*\{\{\s*toc\s*\}\}\s*$`)
+
+var codeSpan = regexp.MustCompile("`+")
+
+// Matches the destination of markdown links to pages, ](/view/Title#anchor)
+var viewLink = regexp.MustCompile(`\]\(` + VIEW_PATH + `(` + titlePattern + `)(#[^)\s]*)?\)`)
+
+// Returns the url of a page on the static site
+func ssgURL(title string) string {
+	return "/" + title + "/"
+}
+
+// Rewrites the wiki syntax of the markdown of page from for static site
+// generators: interlinks, CamelCase words and references become plain
+// markdown links and links to /view/ point to the pages on the site. Links
+// to missing or draft pages become text. Code is left alone.
+func (joki *joki) portableMarkdown(from string, body []byte, wikiWords bool, toc string) []byte {
+	links := textLinkRegex
+	if wikiWords {
+		links = linkOrWordRegex
+	}
+	link := func(text []byte, before, after byte) []byte {
+		// [text](url), [text][ref], [ref]: url and ![image] are markdown
+		if before == ']' || before == '!' || after == '(' || after == '[' || after == ':' {
+			return text
+		}
+		switch text[0] {
+		case '!':
+			return text[1:]
+		case '[':
+			inner := string(text[1 : len(text)-1])
+			if !validTitle.MatchString(inner) {
+				if url, resolver := externalURL(inner); resolver != nil {
+					return []byte("[" + inner + "](" + url + ")")
+				}
+				return text
+			}
+			text = []byte(inner)
+		}
+		title := joki.resolveLink(from, string(text))
+		if !joki.exists(title) || joki.meta.get(title).Draft {
+			return []byte(title)
+		}
+		return []byte("[" + title + "](" + ssgURL(title) + ")")
+	}
+
+	var out bytes.Buffer
+	fence := ""
+	for _, line := range strings.SplitAfter(string(body), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case fence != "":
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			out.WriteString(line)
+			continue
+		case strings.HasPrefix(trimmed, "```"), strings.HasPrefix(trimmed, "~~~"):
+			fence = trimmed[:3]
+			out.WriteString(line)
+			continue
+		case tocLine.MatchString(line):
+			out.WriteString(toc)
+			continue
+		}
+
+		// Only text outside of `code spans` holds links
+		inCode := ""
+		rest := line
+		for rest != "" {
+			loc := codeSpan.FindStringIndex(rest)
+			if loc == nil {
+				loc = []int{len(rest), len(rest)}
+			}
+			text, ticks := rest[:loc[0]], rest[loc[0]:loc[1]]
+			rest = rest[loc[1]:]
+			if inCode != "" {
+				out.WriteString(text + ticks)
+				if ticks == inCode {
+					inCode = ""
+				}
+				continue
+			}
+			text = viewLink.ReplaceAllString(text, "](/$1/$2)")
+			last := 0
+			for _, m := range links.FindAllStringIndex(text, -1) {
+				var before, after byte
+				if m[0] > 0 {
+					before = text[m[0]-1]
+				}
+				if m[1] < len(text) {
+					after = text[m[1]]
+				}
+				out.WriteString(text[last:m[0]])
+				out.Write(link([]byte(text[m[0]:m[1]]), before, after))
+				last = m[1]
+			}
+			out.WriteString(text[last:] + ticks)
+			inCode = ticks
+		}
+	}
+	return out.Bytes()
+}
+
+// Quotes a string for YAML front matter
+func yamlQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Exports every page as markdown with front matter and a directory layout
+// Hugo or Jekyll publish as they are. Pages keep their urls, like
+// /Projects/GoWiki/, attachments are copied to /files/ like on the wiki.
+func (joki *joki) exportSSG(out, format string) error {
+	content, static, toc := filepath.Join(out, "content"), filepath.Join(out, "static"), ""
+	if format == jekyllExport {
+		// kramdown replaces this list with the table of contents
+		content, static, toc = out, out, "* TOC\n{:toc}\n"
+	}
+
+	titles, err := joki.listPages()
+	if err != nil {
+		return err
+	}
+	exported := 0
+	for _, title := range titles {
+		p, err := joki.loadPage(title)
+		if err != nil {
+			return err
+		}
+		meta, body := frontMatter(bytes.Replace(p.Body, []byte{13}, nil, -1))
+		pm := pageMeta(p.Body)
+		if pm.Draft || len(joki.meta.get(title).Readers) > 0 {
+			continue
+		}
+		modTime, err := joki.modTime(title)
+		if err != nil {
+			return err
+		}
+
+		var fm strings.Builder
+		fm.WriteString("---\ntitle: " + yamlQuote(title) + "\n")
+		if format == jekyllExport {
+			fm.WriteString("permalink: " + ssgURL(title) + "\n")
+			fm.WriteString("render_with_liquid: false\n") // pages may contain {{ }}
+		} else {
+			fm.WriteString("url: " + ssgURL(title) + "\n")
+			fm.WriteString("lastmod: " + modTime.UTC().Format(time.RFC3339) + "\n")
+		}
+		date := modTime.UTC().Format(time.RFC3339)
+		if pm.Date != "" {
+			date = pm.Date
+		}
+		fm.WriteString("date: " + date + "\n")
+		if pm.Author != "" {
+			fm.WriteString("author: " + yamlQuote(pm.Author) + "\n")
+		}
+		if len(pm.Tags) > 0 {
+			quoted := make([]string, len(pm.Tags))
+			for i, tag := range pm.Tags {
+				quoted[i] = yamlQuote(tag)
+			}
+			fm.WriteString("tags: [" + strings.Join(quoted, ", ") + "]\n")
+		}
+		fm.WriteString("---\n")
+
+		body = joki.portableMarkdown(title, body, joki.wikiWordsEnabled(meta), toc)
+		path := filepath.Join(content, filepath.FromSlash(title)+extension)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, append([]byte(fm.String()), body...), 0644); err != nil {
+			return err
+		}
+		exported++
+	}
+
+	if err := copyTree(filepath.Join(joki.dataPath, attachmentsDir), filepath.Join(static, FILES_PATH)); err != nil {
+		return err
+	}
+	log.Printf("Exported %d pages for %s to %s", exported, format, out)
+	return nil
+}
+
+// Exports the wiki into out in format
+func (joki *joki) exportAs(out, format string) error {
+	switch format {
+	case htmlExport:
+		return joki.export(out)
+	case hugoExport, jekyllExport:
+		return joki.exportSSG(out, format)
+	}
+	return fmt.Errorf("unknown export format \"%s\"", format)
+}
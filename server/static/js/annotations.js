@@ -0,0 +1,190 @@
+// Inline comments on the rendered page. Selecting text offers to comment on
+// it; comments are shown as margin notes next to the highlighted text. The
+// highlight is found again by the quoted text and its surroundings, so
+// comments stay in place when other parts of the page are edited.
+(function () {
+	var article = document.querySelector("article.article-body[data-title]");
+	var notes = document.getElementById("annotations");
+	if (!article || !notes || !window.fetch || !window.getSelection) {
+		return;
+	}
+	var base = document.querySelector("link[rel=home]").getAttribute("href").replace(/\/$/, "");
+	var url = base + "/api/v1/comments/" + article.dataset.title;
+	var readOnly = "readonly" in article.dataset; // comments are only shown
+	var context = 32;
+
+	// Text nodes of the article together with their offset in its text
+	function textNodes() {
+		var nodes = [], offset = 0;
+		var walker = document.createTreeWalker(article, NodeFilter.SHOW_TEXT, null, false);
+		while (walker.nextNode()) {
+			nodes.push({node: walker.currentNode, start: offset});
+			offset += walker.currentNode.nodeValue.length;
+		}
+		return nodes;
+	}
+
+	function offsetOf(nodes, node, offset) {
+		for (var i = 0; i < nodes.length; i++) {
+			if (nodes[i].node === node) {
+				return nodes[i].start + offset;
+			}
+		}
+		return -1;
+	}
+
+	// Finds the quote, preferring the place with matching surroundings
+	function locate(text, a) {
+		var exact = text.indexOf(a.prefix + a.quote + a.suffix);
+		if (exact !== -1) {
+			return exact + a.prefix.length;
+		}
+		var best = -1, bestScore = -1;
+		for (var i = text.indexOf(a.quote); i !== -1; i = text.indexOf(a.quote, i + 1)) {
+			var score = 0;
+			if (a.prefix && text.slice(Math.max(0, i - a.prefix.length), i).indexOf(a.prefix.slice(-8)) !== -1) {
+				score++;
+			}
+			if (a.suffix && text.slice(i + a.quote.length).indexOf(a.suffix.slice(0, 8)) === 0) {
+				score++;
+			}
+			if (score > bestScore) {
+				best = i;
+				bestScore = score;
+			}
+		}
+		return best;
+	}
+
+	// Wraps the characters start to end of the article text in marks
+	function highlight(start, end, id) {
+		var marks = [];
+		textNodes().forEach(function (t) {
+			var from = Math.max(start, t.start) - t.start;
+			var to = Math.min(end, t.start + t.node.nodeValue.length) - t.start;
+			if (from >= to) {
+				return;
+			}
+			var range = document.createRange();
+			range.setStart(t.node, from);
+			range.setEnd(t.node, to);
+			var mark = document.createElement("mark");
+			mark.className = "annotation";
+			mark.dataset.id = id;
+			range.surroundContents(mark);
+			marks.push(mark);
+		});
+		return marks;
+	}
+
+	function show(a) {
+		var text = article.textContent;
+		var start = locate(text, a);
+		var note = document.createElement("div");
+		note.className = "annotation-note box";
+		var body = document.createElement("p");
+		body.textContent = a.text;
+		var meta = document.createElement("small");
+		meta.textContent = a.author + ", " + new Date(a.created).toLocaleDateString();
+		note.appendChild(body);
+		note.appendChild(meta);
+
+		if (!readOnly) {
+			var remove = document.createElement("a");
+			remove.textContent = " Remove";
+			remove.href = "#";
+			remove.addEventListener("click", function (ev) {
+				ev.preventDefault();
+				fetch(url + "?id=" + a.id, {method: "DELETE", credentials: "same-origin"}).then(function (resp) {
+					if (resp.ok) {
+						location.reload();
+					}
+				});
+			});
+			meta.appendChild(remove);
+		}
+
+		if (start === -1) {
+			note.classList.add("is-orphaned");
+			body.textContent = "“" + a.quote + "” — " + a.text;
+			notes.appendChild(note);
+			return;
+		}
+		var marks = highlight(start, start + a.quote.length, a.id);
+		notes.appendChild(note);
+		if (marks.length) {
+			note.style.top = (marks[0].offsetTop) + "px";
+		}
+	}
+
+	function load() {
+		fetch(url, {credentials: "same-origin"}).then(function (resp) {
+			return resp.ok ? resp.json() : [];
+		}).then(function (annotations) {
+			annotations.forEach(show);
+			notes.classList.toggle("is-hidden", annotations.length === 0);
+		});
+	}
+
+	var button = document.createElement("button");
+	button.className = "button is-small is-info annotate-button is-hidden";
+	button.textContent = "Comment";
+	document.body.appendChild(button);
+
+	var pending = null;
+	document.addEventListener("mouseup", function (ev) {
+		if (readOnly || ev.target === button) {
+			return;
+		}
+		var sel = window.getSelection();
+		pending = null;
+		button.classList.add("is-hidden");
+		if (sel.isCollapsed || !article.contains(sel.anchorNode) || !article.contains(sel.focusNode)) {
+			return;
+		}
+		var range = sel.getRangeAt(0);
+		var nodes = textNodes();
+		var start = offsetOf(nodes, range.startContainer, range.startOffset);
+		var end = offsetOf(nodes, range.endContainer, range.endOffset);
+		if (start === -1 || end <= start) {
+			return;
+		}
+		var text = article.textContent;
+		pending = {
+			quote: text.slice(start, end),
+			prefix: text.slice(Math.max(0, start - context), start),
+			suffix: text.slice(end, end + context)
+		};
+		var rect = range.getBoundingClientRect();
+		button.style.top = (window.scrollY + rect.bottom + 4) + "px";
+		button.style.left = (window.scrollX + rect.left) + "px";
+		button.classList.remove("is-hidden");
+	});
+
+	button.addEventListener("click", function () {
+		if (!pending) {
+			return;
+		}
+		var text = prompt("Comment on “" + pending.quote + "”");
+		button.classList.add("is-hidden");
+		if (!text) {
+			return;
+		}
+		pending.text = text;
+		fetch(url, {
+			method: "POST",
+			body: JSON.stringify(pending),
+			headers: {"Content-Type": "application/json"},
+			credentials: "same-origin"
+		}).then(function (resp) {
+			if (!resp.ok) {
+				return resp.json().then(function (e) { throw new Error(e.error); });
+			}
+			location.reload();
+		}).catch(function (err) {
+			alert("Saving the comment failed: " + err.message);
+		});
+	});
+
+	load();
+})();
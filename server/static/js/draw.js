@@ -0,0 +1,220 @@
+// The drawing editor: pen, lines, rectangles, ellipses and text on an SVG,
+// saved back to its attachment through the upload, which keeps the version
+// it replaces. Drawings that exist are loaded to draw on, but only their
+// shapes, never scripts, links or styles.
+(function () {
+	var svg = document.getElementById("drawing");
+	if (!svg || !window.fetch || !window.FormData || !window.DOMParser) {
+		return;
+	}
+	var base = document.querySelector("link[rel=home]").getAttribute("href").replace(/\/$/, "");
+	var ns = "http://www.w3.org/2000/svg";
+	var title = svg.dataset.title, name = svg.dataset.name;
+	var color = document.getElementById("draw-color"), width = document.getElementById("draw-width");
+	var tool = "pen", shape = null, start = null, history = [];
+
+	var layer = document.createElementNS(ns, "g");
+	svg.appendChild(layer);
+
+	var common = ["stroke", "stroke-width", "stroke-linecap", "stroke-linejoin", "fill", "opacity", "transform", "font-size", "font-family", "text-anchor"];
+	var shapes = {
+		g: [], path: ["d"], line: ["x1", "y1", "x2", "y2"], polyline: ["points"], polygon: ["points"],
+		rect: ["x", "y", "width", "height", "rx", "ry"], circle: ["cx", "cy", "r"],
+		ellipse: ["cx", "cy", "rx", "ry"], text: ["x", "y"], tspan: ["x", "y", "dx", "dy"]
+	};
+
+	// Copies the shapes of a loaded drawing, dropping everything else
+	function copy(node) {
+		var tag = node.localName;
+		if (!shapes.hasOwnProperty(tag)) {
+			return null;
+		}
+		var el = document.createElementNS(ns, tag);
+		shapes[tag].concat(common).forEach(function (a) {
+			var v = node.getAttribute(a);
+			if (v !== null && !/url\s*\(|javascript:/i.test(v)) {
+				el.setAttribute(a, v);
+			}
+		});
+		Array.prototype.forEach.call(node.childNodes, function (child) {
+			if (child.nodeType === Node.TEXT_NODE && (tag === "text" || tag === "tspan")) {
+				el.appendChild(document.createTextNode(child.nodeValue));
+			} else if (child.nodeType === Node.ELEMENT_NODE) {
+				var c = copy(child);
+				if (c) {
+					el.appendChild(c);
+				}
+			}
+		});
+		return el;
+	}
+
+	if (svg.dataset.exists === "true") {
+		fetch(base + "/files/" + title + "/" + name, {credentials: "same-origin"}).then(function (resp) {
+			if (!resp.ok) {
+				throw new Error(resp.statusText);
+			}
+			return resp.text();
+		}).then(function (text) {
+			var doc = new DOMParser().parseFromString(text, "image/svg+xml");
+			var root = doc.documentElement;
+			if (root.localName !== "svg") {
+				throw new Error(name + " is no SVG");
+			}
+			var box = root.getAttribute("viewBox");
+			if (!box && root.getAttribute("width") && root.getAttribute("height")) {
+				box = "0 0 " + parseFloat(root.getAttribute("width")) + " " + parseFloat(root.getAttribute("height"));
+			}
+			if (box && /^[\d.\s,-]+$/.test(box)) {
+				svg.setAttribute("viewBox", box);
+			}
+			Array.prototype.forEach.call(root.childNodes, function (child) {
+				var c = child.nodeType === Node.ELEMENT_NODE && copy(child);
+				if (c) {
+					layer.appendChild(c);
+				}
+			});
+		}).catch(function (err) {
+			window.alert("Could not load " + name + ": " + err.message);
+		});
+	}
+
+	function point(ev) {
+		var p = svg.createSVGPoint();
+		p.x = ev.clientX;
+		p.y = ev.clientY;
+		p = p.matrixTransform(svg.getScreenCTM().inverse());
+		return {x: Math.round(p.x * 10) / 10, y: Math.round(p.y * 10) / 10};
+	}
+
+	function create(tag, attrs) {
+		var el = document.createElementNS(ns, tag);
+		Object.keys(attrs).forEach(function (a) { el.setAttribute(a, attrs[a]); });
+		return el;
+	}
+
+	function stroke() {
+		return {stroke: color.value, "stroke-width": width.value, fill: "none", "stroke-linecap": "round", "stroke-linejoin": "round"};
+	}
+
+	function add(el) {
+		layer.appendChild(el);
+		history.push({added: el});
+	}
+
+	document.getElementById("draw-tools").addEventListener("click", function (ev) {
+		var button = ev.target.closest("button[data-tool]");
+		if (!button) {
+			return;
+		}
+		tool = button.dataset.tool;
+		Array.prototype.forEach.call(this.querySelectorAll("button[data-tool]"), function (b) {
+			b.classList.toggle("is-info", b === button);
+		});
+	});
+
+	svg.addEventListener("pointerdown", function (ev) {
+		var p = point(ev);
+		if (tool === "erase") {
+			var target = ev.target;
+			while (target.parentNode && target.parentNode !== layer) {
+				target = target.parentNode;
+			}
+			if (target.parentNode === layer) {
+				history.push({removed: target, before: target.nextSibling});
+				layer.removeChild(target);
+			}
+			return;
+		}
+		if (tool === "text") {
+			var text = window.prompt("Text");
+			if (text) {
+				var el = create("text", {x: p.x, y: p.y, fill: color.value, "font-size": 6 * width.value + 10, "font-family": "sans-serif"});
+				el.textContent = text;
+				add(el);
+			}
+			return;
+		}
+		start = p;
+		var attrs = stroke();
+		if (tool === "pen") {
+			attrs.d = "M" + p.x + " " + p.y;
+			shape = create("path", attrs);
+		} else if (tool === "line") {
+			attrs.x1 = attrs.x2 = p.x;
+			attrs.y1 = attrs.y2 = p.y;
+			shape = create("line", attrs);
+		} else if (tool === "rect") {
+			shape = create("rect", Object.assign(attrs, {x: p.x, y: p.y, width: 0, height: 0}));
+		} else if (tool === "ellipse") {
+			shape = create("ellipse", Object.assign(attrs, {cx: p.x, cy: p.y, rx: 0, ry: 0}));
+		}
+		svg.setPointerCapture(ev.pointerId);
+		add(shape);
+	});
+
+	svg.addEventListener("pointermove", function (ev) {
+		if (!shape) {
+			return;
+		}
+		var p = point(ev);
+		if (tool === "pen") {
+			shape.setAttribute("d", shape.getAttribute("d") + " L" + p.x + " " + p.y);
+		} else if (tool === "line") {
+			shape.setAttribute("x2", p.x);
+			shape.setAttribute("y2", p.y);
+		} else if (tool === "rect") {
+			shape.setAttribute("x", Math.min(start.x, p.x));
+			shape.setAttribute("y", Math.min(start.y, p.y));
+			shape.setAttribute("width", Math.abs(p.x - start.x));
+			shape.setAttribute("height", Math.abs(p.y - start.y));
+		} else if (tool === "ellipse") {
+			shape.setAttribute("cx", (start.x + p.x) / 2);
+			shape.setAttribute("cy", (start.y + p.y) / 2);
+			shape.setAttribute("rx", Math.abs(p.x - start.x) / 2);
+			shape.setAttribute("ry", Math.abs(p.y - start.y) / 2);
+		}
+	});
+
+	svg.addEventListener("pointerup", function () {
+		shape = null;
+	});
+
+	document.getElementById("draw-undo").addEventListener("click", function () {
+		var last = history.pop();
+		if (!last) {
+			return;
+		}
+		if (last.added) {
+			layer.removeChild(last.added);
+		} else {
+			layer.insertBefore(last.removed, last.before && last.before.parentNode === layer ? last.before : null);
+		}
+	});
+
+	document.getElementById("draw-save").addEventListener("click", function () {
+		var box = svg.getAttribute("viewBox").split(/[\s,]+/);
+		var out = new XMLSerializer();
+		var drawing = '<svg xmlns="' + ns + '" viewBox="' + box.join(" ") + '" width="' + box[2] + '" height="' + box[3] + '">\n';
+		Array.prototype.forEach.call(layer.childNodes, function (child) {
+			drawing += out.serializeToString(child).replace(/ xmlns="[^"]*"/, "") + "\n";
+		});
+		drawing += "</svg>\n";
+
+		var form = new FormData();
+		form.append("file", new Blob([drawing], {type: "image/svg+xml"}), name);
+		fetch(base + "/upload/" + title, {
+			method: "POST",
+			body: form,
+			headers: {"Accept": "application/json"},
+			credentials: "same-origin"
+		}).then(function (resp) {
+			if (!resp.ok) {
+				return resp.text().then(function (msg) { throw new Error(msg); });
+			}
+			window.location = base + "/edit/" + title + "#attachments";
+		}).catch(function (err) {
+			window.alert("Saving failed: " + err.message);
+		});
+	});
+})();
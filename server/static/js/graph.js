@@ -0,0 +1,113 @@
+// Draws the page link graph from /api/v1/graph with a small force layout.
+// Clicking a node opens the page, the filter box hides non-matching pages.
+// The namespace and tag filters of the page are passed on to the server.
+(function () {
+	var canvas = document.getElementById("graph");
+	var filter = document.getElementById("graph-filter");
+	if (!canvas || !window.fetch) {
+		return;
+	}
+	var base = document.querySelector("link[rel=home]").getAttribute("href").replace(/\/$/, "");
+	var ctx = canvas.getContext("2d");
+	var nodes = [], edges = [], byId = {};
+	var radius = 6;
+
+	function visible(n) {
+		var f = filter.value.toLowerCase();
+		return f === "" || n.id.toLowerCase().indexOf(f) !== -1;
+	}
+
+	// One step of a simple spring embedder
+	function step() {
+		var i, j, a, b, dx, dy, d, f;
+		for (i = 0; i < nodes.length; i++) {
+			a = nodes[i];
+			for (j = i + 1; j < nodes.length; j++) {
+				b = nodes[j];
+				dx = a.x - b.x;
+				dy = a.y - b.y;
+				d = Math.max(Math.sqrt(dx * dx + dy * dy), 1);
+				f = 800 / (d * d);
+				a.vx += f * dx / d; a.vy += f * dy / d;
+				b.vx -= f * dx / d; b.vy -= f * dy / d;
+			}
+		}
+		edges.forEach(function (e) {
+			a = byId[e.from]; b = byId[e.to];
+			dx = b.x - a.x; dy = b.y - a.y;
+			d = Math.max(Math.sqrt(dx * dx + dy * dy), 1);
+			f = (d - 80) * 0.01;
+			a.vx += f * dx / d; a.vy += f * dy / d;
+			b.vx -= f * dx / d; b.vy -= f * dy / d;
+		});
+		nodes.forEach(function (n) {
+			n.vx += (canvas.width / 2 - n.x) * 0.001;
+			n.vy += (canvas.height / 2 - n.y) * 0.001;
+			n.x += n.vx; n.y += n.vy;
+			n.vx *= 0.6; n.vy *= 0.6;
+			n.x = Math.min(Math.max(n.x, radius), canvas.width - radius);
+			n.y = Math.min(Math.max(n.y, radius), canvas.height - radius);
+		});
+	}
+
+	function draw() {
+		ctx.clearRect(0, 0, canvas.width, canvas.height);
+		ctx.strokeStyle = "#dbdbdb";
+		edges.forEach(function (e) {
+			var a = byId[e.from], b = byId[e.to];
+			if (visible(a) && visible(b)) {
+				ctx.beginPath();
+				ctx.moveTo(a.x, a.y);
+				ctx.lineTo(b.x, b.y);
+				ctx.stroke();
+			}
+		});
+		ctx.font = "12px sans-serif";
+		nodes.forEach(function (n) {
+			if (!visible(n)) {
+				return;
+			}
+			ctx.fillStyle = n.exists ? "#3273dc" : "#ff3860";
+			ctx.beginPath();
+			ctx.arc(n.x, n.y, radius, 0, 2 * Math.PI);
+			ctx.fill();
+			ctx.fillStyle = "#4a4a4a";
+			ctx.fillText(n.id, n.x + radius + 2, n.y + 4);
+		});
+	}
+
+	function tick(iterations) {
+		step();
+		draw();
+		if (iterations > 0) {
+			window.requestAnimationFrame(function () { tick(iterations - 1); });
+		}
+	}
+
+	canvas.addEventListener("click", function (ev) {
+		var rect = canvas.getBoundingClientRect();
+		var x = (ev.clientX - rect.left) * canvas.width / rect.width;
+		var y = (ev.clientY - rect.top) * canvas.height / rect.height;
+		nodes.forEach(function (n) {
+			if (visible(n) && Math.abs(n.x - x) <= radius * 2 && Math.abs(n.y - y) <= radius * 2) {
+				window.location = base + "/view/" + n.id;
+			}
+		});
+	});
+	filter.addEventListener("input", draw);
+
+	fetch(base + "/api/v1/graph" + window.location.search)
+		.then(function (resp) { return resp.json(); })
+		.then(function (graph) {
+			nodes = graph.nodes;
+			edges = graph.edges;
+			nodes.forEach(function (n) {
+				n.x = Math.random() * canvas.width;
+				n.y = Math.random() * canvas.height;
+				n.vx = 0;
+				n.vy = 0;
+				byId[n.id] = n;
+			});
+			tick(300);
+		});
+})();
@@ -0,0 +1,54 @@
+// Reloads the viewed page when it is saved. The server says "changed" over a
+// WebSocket; the article is then replaced by the new one, keeping the scroll
+// position. Pages with inline comments are reloaded as a whole, so the
+// comments are placed again.
+(function () {
+	var article = document.querySelector("article.article-body[data-title]");
+	if (!article || !window.WebSocket || !window.fetch || !window.DOMParser) {
+		return;
+	}
+	var title = article.dataset.title;
+	var base = document.querySelector("link[rel=home]").getAttribute("href").replace(/\/$/, "");
+	var delay = 1000;
+
+	function refresh() {
+		var notes = document.getElementById("annotations");
+		if (notes && notes.children.length) {
+			location.reload();
+			return;
+		}
+		fetch(location.pathname, {credentials: "same-origin"}).then(function (resp) {
+			if (!resp.ok || resp.redirected) {
+				location.reload();
+				return;
+			}
+			return resp.text().then(function (html) {
+				var doc = new DOMParser().parseFromString(html, "text/html");
+				var fresh = doc.querySelector("article.article-body[data-title]");
+				if (fresh) {
+					article.innerHTML = fresh.innerHTML;
+				}
+			});
+		});
+	}
+
+	function connect() {
+		var scheme = location.protocol === "https:" ? "wss://" : "ws://";
+		var ws = new WebSocket(scheme + location.host + base + "/ws?title=" + encodeURIComponent(title));
+		ws.onopen = function () {
+			delay = 1000;
+		};
+		ws.onmessage = function (ev) {
+			if (ev.data === "changed") {
+				refresh();
+			}
+		};
+		ws.onclose = function () {
+			// reconnect after a restart of the wiki, waiting longer each time
+			setTimeout(connect, delay);
+			delay = Math.min(delay * 2, 60000);
+		};
+	}
+
+	connect();
+})();
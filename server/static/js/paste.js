@@ -0,0 +1,52 @@
+// Turns rich text pasted from web pages, Confluence or Google Docs into
+// markdown, and tables pasted from spreadsheets into markdown tables. Plain
+// text is pasted unchanged, as is anything pasted with Ctrl+Shift+V.
+(function () {
+	var body = document.querySelector("textarea[name=body]");
+	if (!body || !window.fetch) {
+		return;
+	}
+
+	var base = document.querySelector("link[rel=home]").getAttribute("href").replace(/\/$/, "");
+
+	function insert(text) {
+		var start = body.selectionStart, end = body.selectionEnd;
+		body.value = body.value.slice(0, start) + text + body.value.slice(end);
+		body.selectionStart = body.selectionEnd = start + text.length;
+	}
+
+	// Markup that is more than the plain text wrapped in a paragraph
+	var rich = /<(a|b|strong|i|em|h[1-6]|ul|ol|li|table|pre|code|blockquote|img|s|del|span[^>]*style)[\s>]/i;
+
+	body.addEventListener("paste", function (ev) {
+		if (!ev.clipboardData) {
+			return;
+		}
+		var html = ev.clipboardData.getData("text/html");
+		var text = ev.clipboardData.getData("text/plain");
+		var data, type, path;
+		if (html && rich.test(html)) {
+			data = html;
+			type = "text/html";
+			path = base + "/api/v1/convert/html";
+		} else if (text.indexOf("\t") !== -1 && text.trim().indexOf("\n") !== -1) {
+			data = text;
+			type = "text/tab-separated-values";
+			path = base + "/api/v1/convert/table";
+		} else {
+			return;
+		}
+
+		ev.preventDefault();
+		fetch(path, {
+			method: "POST",
+			body: data,
+			headers: {"Content-Type": type},
+			credentials: "same-origin"
+		}).then(function (resp) {
+			return resp.ok ? resp.text() : text;
+		}).catch(function () {
+			return text;
+		}).then(insert);
+	});
+})();
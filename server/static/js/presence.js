@@ -0,0 +1,45 @@
+// Tells the wiki that this page is being edited and warns when somebody else
+// edits it, too. A heartbeat is sent while the editor is open; leaving the
+// page says goodbye, otherwise the wiki forgets the tab after a while.
+(function () {
+	var banner = document.getElementById("presence");
+	if (!banner || !window.fetch) {
+		return;
+	}
+	var base = document.querySelector("link[rel=home]").getAttribute("href").replace(/\/$/, "");
+	var url = base + "/api/v1/presence/" + banner.dataset.title;
+	var id = Math.random().toString(36).slice(2) + Date.now().toString(36);
+
+	function show(editors) {
+		if (!editors.length) {
+			banner.classList.add("is-hidden");
+			return;
+		}
+		banner.textContent = editors.join(", ") + (editors.length === 1 ? " is" : " are") +
+			" currently editing this page.";
+		banner.classList.remove("is-hidden");
+	}
+
+	function beat() {
+		var body = new URLSearchParams({id: id});
+		fetch(url, {method: "POST", body: body, credentials: "same-origin"}).then(function (resp) {
+			return resp.ok ? resp.json() : null;
+		}).then(function (p) {
+			if (p) {
+				show(p.editors);
+				setTimeout(beat, p.interval * 1000);
+			}
+		});
+	}
+
+	window.addEventListener("pagehide", function () {
+		fetch(url, {
+			method: "POST",
+			body: new URLSearchParams({id: id, leave: "1"}),
+			credentials: "same-origin",
+			keepalive: true
+		});
+	});
+
+	beat();
+})();
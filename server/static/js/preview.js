@@ -0,0 +1,56 @@
+// Switches the editor between writing and a rendered preview of the text.
+// The preview is rendered by the server so [Links] show up like on the page.
+(function () {
+	var tabs = document.getElementById("editor-tabs");
+	var body = document.querySelector("textarea[name=body]");
+	var title = document.querySelector("input[name=title]");
+	var preview = document.getElementById("preview");
+	if (!tabs || !body || !preview || !window.fetch || !window.FormData) {
+		return;
+	}
+	var base = document.querySelector("link[rel=home]").getAttribute("href").replace(/\/$/, "");
+	tabs.classList.remove("is-hidden");
+
+	function show(tab) {
+		var items = tabs.querySelectorAll("li");
+		for (var i = 0; i < items.length; i++) {
+			items[i].classList.toggle("is-active", items[i].dataset.tab === tab);
+		}
+		body.classList.toggle("is-hidden", tab !== "write");
+		preview.classList.toggle("is-hidden", tab !== "preview");
+	}
+
+	function render() {
+		var data = new FormData();
+		data.append("body", body.value);
+		data.append("title", title ? title.value : "");
+		preview.textContent = "Rendering…";
+		fetch(base + "/preview", {
+			method: "POST",
+			body: data,
+			credentials: "same-origin"
+		}).then(function (resp) {
+			if (!resp.ok) {
+				return resp.text().then(function (msg) { throw new Error(msg); });
+			}
+			return resp.text();
+		}).then(function (html) {
+			// The fragment is sanitized by the server like any page
+			preview.innerHTML = html;
+		}).catch(function (err) {
+			preview.textContent = "Preview failed: " + err.message;
+		});
+	}
+
+	tabs.addEventListener("click", function (ev) {
+		var item = ev.target.closest("li");
+		if (!item) {
+			return;
+		}
+		ev.preventDefault();
+		if (item.dataset.tab === "preview") {
+			render();
+		}
+		show(item.dataset.tab);
+	});
+})();
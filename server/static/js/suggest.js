@@ -0,0 +1,39 @@
+// Fills the nav search box with title and heading suggestions and jumps to
+// the chosen page. Without javascript the search box simply does nothing.
+(function () {
+	var input = document.getElementById("search");
+	var list = document.getElementById("search-suggestions");
+	if (!input || !list || !window.fetch) {
+		return;
+	}
+
+	var base = document.querySelector("link[rel=home]").getAttribute("href").replace(/\/$/, "");
+	var urls = {};
+
+	function label(s) {
+		return s.heading ? s.title + " › " + s.heading : s.title;
+	}
+
+	input.addEventListener("input", function () {
+		var q = input.value;
+		if (urls[q]) {
+			window.location = urls[q];
+			return;
+		}
+		fetch(base + "/api/v1/search/suggest?q=" + encodeURIComponent(q))
+			.then(function (resp) { return resp.json(); })
+			.then(function (suggestions) {
+				if (input.value !== q) {
+					return; // stale answer
+				}
+				urls = {};
+				list.innerHTML = "";
+				suggestions.forEach(function (s) {
+					var option = document.createElement("option");
+					option.value = label(s);
+					urls[option.value] = base + s.url;
+					list.appendChild(option);
+				});
+			});
+	});
+})();
@@ -0,0 +1,129 @@
+// Uploads attachments without leaving the editor and inserts their markdown
+// reference at the cursor. Without javascript the form posts normally and
+// the reference can be copied from the attachment list. Big files are sent
+// in chunks over tus, which resume after a failure or a reload.
+(function () {
+	var form = document.getElementById("upload");
+	var body = document.querySelector("textarea[name=body]");
+	if (!form || !body || !window.fetch || !window.FormData) {
+		return;
+	}
+
+	function insert(text) {
+		var start = body.selectionStart, end = body.selectionEnd;
+		body.value = body.value.slice(0, start) + text + body.value.slice(end);
+		body.selectionStart = body.selectionEnd = start + text.length;
+		body.focus();
+	}
+
+	var base = document.querySelector("link[rel=home]").getAttribute("href").replace(/\/$/, "");
+	var chunkedFrom = 8 << 20, chunkSize = 4 << 20, retries = 5;
+
+	function tus(url, method, headers, body) {
+		headers["Tus-Resumable"] = "1.0.0";
+		return fetch(url, {method: method, headers: headers, body: body, credentials: "same-origin"}).then(function (resp) {
+			if (!resp.ok) {
+				return resp.text().then(function (msg) { throw new Error(msg || resp.statusText); });
+			}
+			return resp;
+		});
+	}
+
+	// Resolves to the upload of file to title and the offset to go on from
+	function start(title, file, key) {
+		var url = window.localStorage && localStorage.getItem(key);
+		var create = function () {
+			return tus(base + "/uploads/", "POST", {
+				"Upload-Length": String(file.size),
+				"Upload-Metadata": "title " + btoa(unescape(encodeURIComponent(title))) + ",filename " + btoa(unescape(encodeURIComponent(file.name)))
+			}).then(function (resp) {
+				url = resp.headers.get("Location");
+				if (window.localStorage) {
+					localStorage.setItem(key, url);
+				}
+				return {url: url, offset: 0};
+			});
+		};
+		if (!url) {
+			return create();
+		}
+		return tus(url, "HEAD", {}).then(function (resp) {
+			return {url: url, offset: parseInt(resp.headers.get("Upload-Offset"), 10)};
+		}, create);
+	}
+
+	function send(upload, file, tries) {
+		if (upload.offset >= file.size) {
+			return Promise.resolve();
+		}
+		var chunk = file.slice(upload.offset, upload.offset + chunkSize);
+		return tus(upload.url, "PATCH", {
+			"Content-Type": "application/offset+octet-stream",
+			"Upload-Offset": String(upload.offset)
+		}, chunk).then(function (resp) {
+			upload.offset = parseInt(resp.headers.get("Upload-Offset"), 10);
+			return send(upload, file, retries);
+		}, function (err) {
+			if (tries <= 0 || upload.offset + chunk.size >= file.size) {
+				throw err; // the last chunk failing is a rejection
+			}
+			return new Promise(function (resolve) { setTimeout(resolve, 2000); }).then(function () {
+				return tus(upload.url, "HEAD", {});
+			}).then(function (resp) {
+				upload.offset = parseInt(resp.headers.get("Upload-Offset"), 10);
+				return send(upload, file, tries - 1);
+			}, function () {
+				return send(upload, file, tries - 1);
+			});
+		});
+	}
+
+	function uploadChunked(file) {
+		var title = decodeURIComponent(new URL(form.action).pathname.slice((base + "/upload/").length));
+		var key = "upload:" + title + "/" + file.name + ":" + file.size + ":" + file.lastModified;
+		return start(title, file, key).then(function (upload) {
+			return send(upload, file, retries);
+		}).then(function () {
+			if (window.localStorage) {
+				localStorage.removeItem(key);
+			}
+			var url = "/files/" + title + "/" + file.name;
+			var markdown = "[" + file.name + "](" + url + ")";
+			insert(/^image\//.test(file.type) ? "!" + markdown : markdown);
+			form.reset();
+		}, function (err) {
+			// Network failures are resumed on the next try, rejections not
+			if (window.localStorage && !(err instanceof TypeError)) {
+				localStorage.removeItem(key);
+			}
+			throw err;
+		});
+	}
+
+	form.addEventListener("submit", function (ev) {
+		ev.preventDefault();
+		var file = form.querySelector("input[type=file]").files[0];
+		if (file && file.size > chunkedFrom) {
+			uploadChunked(file).catch(function (err) {
+				window.alert("Upload failed: " + err.message);
+			});
+			return;
+		}
+		fetch(form.action, {
+			method: "POST",
+			body: new FormData(form),
+			headers: {"Accept": "application/json"},
+			credentials: "same-origin"
+		}).then(function (resp) {
+			if (!resp.ok) {
+				return resp.text().then(function (msg) { throw new Error(msg); });
+			}
+			return resp.json();
+		}).then(function (attachment) {
+			insert(attachment.markdown);
+			form.reset();
+		}).catch(function (err) {
+			window.alert("Upload failed: " + err.message);
+		});
+	});
+})();
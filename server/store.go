@@ -0,0 +1,216 @@
+package server
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PageStore stores the markdown source of pages by title.
+// Load and ModTime return an error for which os.IsNotExist is true if the
+// page does not exist, so handlers can tell missing pages from failures.
+type PageStore interface {
+	Load(title string) ([]byte, error)
+	Save(title string, body []byte) error
+	Remove(title string) error
+	Rename(oldTitle, newTitle string) error
+	Exists(title string) bool
+	List() ([]string, error)
+	ModTime(title string) (time.Time, error)
+}
+
+// fsStore keeps every page as a markdown file in a directory
+type fsStore struct {
+	dir string
+}
+
+func newFSStore(dir string) *fsStore {
+	return &fsStore{dir: dir}
+}
+
+// Pages in a namespace live in subdirectories, Projects/GoWiki.md
+func (s *fsStore) fileName(title string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(title)+extension)
+}
+
+func (s *fsStore) Load(title string) ([]byte, error) {
+	return ioutil.ReadFile(s.fileName(title))
+}
+
+func (s *fsStore) Save(title string, body []byte) error {
+	fileName := s.fileName(title)
+	err := ioutil.WriteFile(fileName, body, 0600)
+	_, isPerr := err.(*os.PathError)
+	if err != nil && isPerr {
+		// Try to fix path error by making dataPath or namespace directory
+		err = os.MkdirAll(filepath.Dir(fileName), 0700)
+		if err != nil {
+			return err
+		}
+		log.Printf("Creating %s directory for pages", filepath.Dir(fileName))
+		return s.Save(title, body)
+	}
+	return err
+}
+
+func (s *fsStore) Remove(title string) error {
+	if err := os.Remove(s.fileName(title)); err != nil {
+		return err
+	}
+	s.pruneNamespace(title)
+	return nil
+}
+
+func (s *fsStore) Rename(oldTitle, newTitle string) error {
+	newName := s.fileName(newTitle)
+	if err := os.MkdirAll(filepath.Dir(newName), 0700); err != nil {
+		return err
+	}
+	if err := os.Rename(s.fileName(oldTitle), newName); err != nil {
+		return err
+	}
+	s.pruneNamespace(oldTitle)
+	return nil
+}
+
+// Removes the namespace directories of title that became empty
+func (s *fsStore) pruneNamespace(title string) {
+	for ns := namespace(title); ns != ""; ns = namespace(ns) {
+		// Remove fails on directories that still hold pages
+		if os.Remove(filepath.Join(s.dir, filepath.FromSlash(ns))) != nil {
+			return
+		}
+	}
+}
+
+func (s *fsStore) Exists(title string) bool {
+	_, err := os.Stat(s.fileName(title))
+	return !os.IsNotExist(err)
+}
+
+func (s *fsStore) List() ([]string, error) {
+	if _, err := os.Stat(s.dir); err != nil {
+		return nil, err
+	}
+
+	// Walk the namespaces, skipping history, git and attachments
+	var pages []string
+	err := filepath.Walk(s.dir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		fName := f.Name()
+		if f.IsDir() {
+			if rel != "." && (strings.HasPrefix(fName, ".") || rel == attachmentsDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(fName, extension) {
+			title := rel[:len(rel)-len(extension)]
+			if validPageTitle(title) {
+				pages = append(pages, title)
+			}
+		}
+		return nil
+	})
+	return pages, err
+}
+
+func (s *fsStore) ModTime(title string) (time.Time, error) {
+	info, err := os.Stat(s.fileName(title))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Stores a new version of a page for user, "" for none. The quotas are
+// checked, the previous version is kept in the history and the indexes are
+// updated. Recording the change in git is left to the caller, which knows
+// author and message.
+func (joki *joki) storePage(title string, body []byte, user string) (*Page, error) {
+	if err := joki.holds.check(title); err != nil {
+		return nil, err
+	}
+	if err := joki.checkQuota(title, len(body), user); err != nil {
+		return nil, err
+	}
+	if err := joki.archive(title); err != nil {
+		return nil, err
+	}
+	// links to the page change with it when it appears or is published
+	relinked := !joki.exists(title) || joki.meta.get(title).Draft != pageMeta(body).Draft
+	p := joki.newPage(title)
+	p.Body = body
+	if err := p.save(); err != nil {
+		return nil, err
+	}
+	if err := joki.usage.add(user, int64(len(body))); err != nil {
+		log.Printf("Could not count %s for %s: %s", title, user, err)
+	}
+	if joki.signer != nil {
+		if err := joki.signer.sign(title, body); err != nil {
+			return nil, err
+		}
+	}
+	if relinked {
+		joki.renderCache.purge()
+	} else {
+		joki.renderCache.invalidate(title)
+	}
+	joki.reindex(title)
+	joki.watchers.notify(title)
+	return p, nil
+}
+
+// Moves a page to the trash and drops it from the indexes
+func (joki *joki) removePage(title string) error {
+	if err := joki.holds.check(title); err != nil {
+		return err
+	}
+	// the history keeps the last version for browsing old snapshots
+	if err := joki.archive(title); err != nil {
+		return err
+	}
+	if err := joki.trash(title); err != nil {
+		return err
+	}
+	if err := joki.newPage(title).remove(); err != nil {
+		return err
+	}
+	if joki.signer != nil {
+		if err := joki.signer.remove(title); err != nil {
+			return err
+		}
+	}
+	joki.unindex(title)
+	joki.watchers.notify(title)
+	joki.purgeTrash()
+	return nil
+}
+
+// Returns the http status a failed storePage or removePage should be
+// reported with
+func changeStatus(err error) int {
+	switch e := err.(type) {
+	case *quotaError:
+		return http.StatusRequestEntityTooLarge
+	case *holdError, *lockError:
+		return http.StatusLocked
+	case *throttleError:
+		return http.StatusTooManyRequests
+	case *uploadError:
+		return e.status
+	}
+	return http.StatusInternalServerError
+}
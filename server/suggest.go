@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+const (
+	suggestDefaultLimit = 10
+	suggestMaxLimit     = 50
+)
+
+// Suggestion is a page title or heading offered to the nav search box
+type Suggestion struct {
+	Title   string `json:"title"`
+	Heading string `json:"heading,omitempty"`
+	URL     string `json:"url"`
+}
+
+type prefixEntry struct {
+	key string // lowercased word suffix used for prefix matching
+	Suggestion
+}
+
+// prefixIndex keeps page titles and headings sorted for fast prefix lookups.
+// It is independent of the markdown content and only holds what the
+// navigation suggestions need.
+type prefixIndex struct {
+	sync.RWMutex
+	entries []prefixEntry
+}
+
+func newPrefixIndex() *prefixIndex {
+	return &prefixIndex{}
+}
+
+type heading struct {
+	Text string
+	ID   string
+}
+
+// Extracts the headings of a markdown document together with their ids
+func pageHeadings(content []byte) []heading {
+	var headings []heading
+	doc := parser.NewWithExtensions(mdExt).Parse(content)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		h, ok := node.(*ast.Heading)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		var text strings.Builder
+		ast.WalkFunc(h, func(n ast.Node, entering bool) ast.WalkStatus {
+			if leaf := n.AsLeaf(); entering && leaf != nil {
+				text.Write(leaf.Literal)
+			}
+			return ast.GoToNext
+		})
+		headings = append(headings, heading{Text: text.String(), ID: h.HeadingID})
+		return ast.SkipChildren
+	})
+	return headings
+}
+
+// Returns an entry for every word of text so matches are found mid-title,
+// also for the last part of a title in a namespace
+func wordEntries(text string, s Suggestion) []prefixEntry {
+	var entries []prefixEntry
+	lower := strings.ToLower(text)
+	for i := range lower {
+		if i == 0 || lower[i-1] == ' ' || lower[i-1] == '/' {
+			entries = append(entries, prefixEntry{key: lower[i:], Suggestion: s})
+		}
+	}
+	return entries
+}
+
+func (idx *prefixIndex) set(title string, headings []heading) {
+	idx.Lock()
+	defer idx.Unlock()
+	idx.removeLocked(title)
+
+	added := wordEntries(title, Suggestion{Title: title, URL: VIEW_PATH + title})
+	for _, h := range headings {
+		s := Suggestion{Title: title, Heading: h.Text, URL: VIEW_PATH + title + "#" + h.ID}
+		added = append(added, wordEntries(h.Text, s)...)
+	}
+	sort.Slice(added, func(i, j int) bool {
+		return added[i].key < added[j].key
+	})
+
+	// Merge the few entries of the page into the sorted ones, as sorting all
+	// of them for every page makes indexing a large wiki slow
+	merged := make([]prefixEntry, 0, len(idx.entries)+len(added))
+	i := 0
+	for _, e := range added {
+		j := i + sort.Search(len(idx.entries)-i, func(k int) bool {
+			return idx.entries[i+k].key > e.key
+		})
+		merged = append(append(merged, idx.entries[i:j]...), e)
+		i = j
+	}
+	idx.entries = append(merged, idx.entries[i:]...)
+}
+
+func (idx *prefixIndex) remove(title string) {
+	idx.Lock()
+	defer idx.Unlock()
+	idx.removeLocked(title)
+}
+
+func (idx *prefixIndex) removeLocked(title string) {
+	kept := idx.entries[:0]
+	for _, e := range idx.entries {
+		if e.Title != title {
+			kept = append(kept, e)
+		}
+	}
+	idx.entries = kept
+}
+
+// Returns up to limit suggestions starting with prefix, titles first, of the
+// pages visible reports true for
+func (idx *prefixIndex) lookup(prefix string, limit int, visible func(title string) bool) []Suggestion {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	results := make([]Suggestion, 0, limit)
+	if prefix == "" {
+		return results
+	}
+
+	idx.RLock()
+	defer idx.RUnlock()
+
+	seen := make(map[string]bool)
+	allowed := make(map[string]bool) // by title, as it costs an ACL check
+	start := sort.Search(len(idx.entries), func(i int) bool {
+		return idx.entries[i].key >= prefix
+	})
+	for i := start; i < len(idx.entries) && strings.HasPrefix(idx.entries[i].key, prefix); i++ {
+		s := idx.entries[i].Suggestion
+		ok, checked := allowed[s.Title]
+		if !checked {
+			ok = visible(s.Title)
+			allowed[s.Title] = ok
+		}
+		if ok && !seen[s.URL] {
+			seen[s.URL] = true
+			results = append(results, s)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Heading == "" && results[j].Heading != ""
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// Answers nav search box queries with matching titles and headings as JSON
+func (joki *joki) suggestHandler(w http.ResponseWriter, r *http.Request) {
+	limit := suggestDefaultLimit
+	if l, err := strconv.Atoi(r.FormValue("limit")); err == nil && l > 0 && l <= suggestMaxLimit {
+		limit = l
+	}
+
+	user := joki.currentUser(r)
+	visible := func(title string) bool { return joki.visible(title, user) }
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(joki.suggest.lookup(r.FormValue("q"), limit, visible))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestPrefixIndex(t *testing.T) {
+	idx := newPrefixIndex()
+	idx.set("Projects/GoWiki", []heading{{Text: "Release Notes", ID: "release-notes"}})
+	idx.set("Home", []heading{{Text: "Getting Started", ID: "getting-started"}})
+	idx.set("Notes", nil)
+	idx.set("Home", []heading{{Text: "Welcome", ID: "welcome"}})
+
+	if !sort.SliceIsSorted(idx.entries, func(i, j int) bool { return idx.entries[i].key < idx.entries[j].key }) {
+		t.Fatal("the entries are not sorted")
+	}
+	all := func(string) bool { return true }
+	tests := []struct {
+		prefix string
+		urls   []string
+	}{
+		{"gow", []string{VIEW_PATH + "Projects/GoWiki"}},
+		{"proj", []string{VIEW_PATH + "Projects/GoWiki"}},
+		{"notes", []string{VIEW_PATH + "Notes", VIEW_PATH + "Projects/GoWiki#release-notes"}},
+		{"getting", nil},
+		{"wel", []string{VIEW_PATH + "Home#welcome"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got := idx.lookup(tt.prefix, suggestDefaultLimit, all)
+		if len(got) != len(tt.urls) {
+			t.Errorf("lookup of %q: %v, want %v", tt.prefix, got, tt.urls)
+			continue
+		}
+		for i, s := range got {
+			if s.URL != tt.urls[i] {
+				t.Errorf("lookup of %q: %v, want %v", tt.prefix, got, tt.urls)
+				break
+			}
+		}
+	}
+
+	idx.remove("Projects/GoWiki")
+	if got := idx.lookup("gow", suggestDefaultLimit, all); len(got) != 0 {
+		t.Errorf("lookup of a removed page: %v", got)
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Parses tab separated values as copied from spreadsheets. Cells containing
+// tabs or newlines are quoted by spreadsheets, which csv understands.
+func tsvRows(data []byte) ([][]string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = '\t'
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	return reader.ReadAll()
+}
+
+// Returns the rows of the first table in an html fragment
+func htmlTableRows(r io.Reader) ([][]string, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	table := findElement(doc, "table")
+	if table == nil {
+		return nil, nil
+	}
+	return tableRows(table), nil
+}
+
+// Returns the text of the cells of a table element
+func tableRows(table *html.Node) [][]string {
+	var rows [][]string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.Data {
+			case "table":
+				// nested tables end up as text of their cell
+			case "tr":
+				var row []string
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+						row = append(row, nodeText(cell))
+					}
+				}
+				rows = append(rows, row)
+			default:
+				walk(c) // thead, tbody, tfoot
+			}
+		}
+	}
+	walk(table)
+	return rows
+}
+
+// Returns the first element with the given name in depth first order
+func findElement(n *html.Node, name string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == name {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Returns the text content of a node with collapsed whitespace
+func nodeText(n *html.Node) string {
+	var text strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			text.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+// Formats rows as a markdown table using the first row as header
+func markdownTable(rows [][]string) string {
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	if columns == 0 {
+		return ""
+	}
+
+	cell := func(row []string, i int) string {
+		if i >= len(row) {
+			return ""
+		}
+		c := strings.Join(strings.Fields(row[i]), " ")
+		return strings.Replace(c, "|", `\|`, -1)
+	}
+	widths := make([]int, columns)
+	for _, row := range rows {
+		for i := range widths {
+			if l := len([]rune(cell(row, i))); l > widths[i] {
+				widths[i] = l
+			}
+		}
+	}
+	for i := range widths {
+		if widths[i] < 3 {
+			widths[i] = 3 // the separator needs at least ---
+		}
+	}
+
+	var out strings.Builder
+	line := func(cells func(i int) string) {
+		out.WriteString("|")
+		for i, width := range widths {
+			c := cells(i)
+			out.WriteString(" " + c + strings.Repeat(" ", width-len([]rune(c))) + " |")
+		}
+		out.WriteString("\n")
+	}
+	line(func(i int) string { return cell(rows[0], i) })
+	line(func(i int) string { return strings.Repeat("-", widths[i]) })
+	for _, row := range rows[1:] {
+		line(func(i int) string { return cell(row, i) })
+	}
+	return out.String()
+}
+
+// Converts a pasted html table or tab separated values to a markdown table
+func (joki *joki) convertTableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Converting requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var rows [][]string
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/html" {
+		rows, err = htmlTableRows(bytes.NewReader(data))
+	} else {
+		rows, err = tsvRows(data)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	table := markdownTable(rows)
+	if table == "" {
+		http.Error(w, "No table found", http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	io.WriteString(w, table)
+}
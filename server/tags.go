@@ -0,0 +1,191 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Pages below it describe the tag of their name, Tags/ops/network describes
+// ops/network
+const tagDescriptions = "Tags"
+
+// Tags are single words, which may be nested with slashes like ops/network
+var validTag = regexp.MustCompile(`^[^\s,\[\]"'#:]+$`)
+
+// TagsPage lists all tags with how many pages carry them. Admins get the
+// form to rename tags, and the outcome of the last rename.
+type TagsPage struct {
+	Tags    []NameCount
+	Admin   bool
+	From    string
+	To      string
+	Renamed []string // pages the rename changed
+	Skipped []string // generated pages the rename left alone
+	Error   string
+}
+
+// Shows all tags of the pages with how many pages carry them
+func (joki *joki) tagsHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := joki.tagsPage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	joki.renderTemplate(w, r, "tags", page)
+}
+
+func (joki *joki) tagsPage(r *http.Request) (*TagsPage, error) {
+	titles, err := joki.listPages()
+	if err != nil {
+		return nil, err
+	}
+	// a page counts once for every tag its tags are nested in
+	counts := make(map[string]int)
+	for _, title := range joki.visiblePages(r, titles) {
+		seen := make(map[string]bool)
+		for _, tag := range joki.meta.get(title).Tags {
+			for _, t := range tagPath(tag) {
+				if !seen[t] {
+					seen[t] = true
+					counts[t]++
+				}
+			}
+		}
+	}
+	return &TagsPage{Tags: sortedCounts(counts), Admin: joki.isAdmin(r) && !joki.readonly}, nil
+}
+
+// Lists the pages with the tag in the path, e.g. /tag/howto
+func (joki *joki) tagHandler(w http.ResponseWriter, r *http.Request) {
+	tag := strings.ToLower(strings.TrimPrefix(r.URL.Path, TAG_PATH))
+	if tag == "" {
+		http.Redirect(w, r, TAGS_PATH, http.StatusFound)
+		return
+	}
+	joki.renderPageList(w, r, tag)
+}
+
+// Returns the title of the page describing tag, and its html if the request
+// may read it
+func (joki *joki) tagDescription(r *http.Request, tag string) (string, template.HTML) {
+	title := tagDescriptions + "/" + tag
+	if !validPageTitle(title) || !joki.visible(title, joki.currentUser(r)) {
+		return title, ""
+	}
+	p, err := joki.loadPage(title)
+	if err != nil {
+		return title, ""
+	}
+	return title, template.HTML(joki.renderStored(p))
+}
+
+// Renames the tag from to to on all pages, with the tags nested in it. That
+// merges the two if pages carry to already.
+func (joki *joki) renameTagHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, TAGS_PATH, http.StatusFound)
+		return
+	}
+	page, err := joki.tagsPage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page.From = strings.ToLower(strings.TrimSpace(r.FormValue("from")))
+	page.To = strings.ToLower(strings.TrimSpace(r.FormValue("to")))
+	switch {
+	case !validTag.MatchString(page.From) || !validTag.MatchString(page.To):
+		page.Error = "Tags are single words without commas, brackets, quotes, # or :"
+	case page.From == page.To:
+		page.Error = page.From + " would be renamed to itself"
+	default:
+		page.Renamed, page.Skipped, err = joki.renameTag(r, page.From, page.To)
+		if err != nil && changeStatus(err) == http.StatusInternalServerError {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if err != nil {
+			page.Error = err.Error() + ", no page was changed"
+		}
+		// the counts changed with the tags
+		if counted, err := joki.tagsPage(r); err == nil {
+			page.Tags = counted.Tags
+		}
+	}
+	joki.renderTemplate(w, r, "tags", page)
+}
+
+// Replaces the tag from with to on all pages carrying it, as a single
+// change. Either all pages are changed or none: pages on hold or being
+// edited stop the rename before anything is written, and pages written
+// before a failure are put back. Generated pages are left to their source.
+func (joki *joki) renameTag(r *http.Request, from, to string) (renamed, skipped []string, err error) {
+	titles, err := joki.listPages()
+	if err != nil {
+		return nil, nil, err
+	}
+	var pages []*Page
+	for _, title := range titles {
+		if !joki.meta.tagged(title, from) {
+			continue
+		}
+		p, err := joki.loadPage(title)
+		if err != nil {
+			return nil, nil, err
+		}
+		if pageMeta(p.Body).Generated {
+			skipped = append(skipped, title)
+			continue
+		}
+		if err := joki.holds.check(title); err != nil {
+			return nil, nil, err
+		}
+		if err := joki.checkEditLock(r, title); err != nil {
+			return nil, nil, err
+		}
+		pages = append(pages, p)
+	}
+
+	user := joki.currentUser(r)
+	var changed []string
+	for i, p := range pages {
+		if _, err := joki.storePage(p.Title, retag(p.Body, from, to), user); err != nil {
+			for _, done := range pages[:i] {
+				if _, rerr := joki.storePage(done.Title, done.Body, user); rerr != nil {
+					return nil, nil, fmt.Errorf("renaming %s failed on %s: %s, and putting back %s: %s", from, p.Title, err, done.Title, rerr)
+				}
+			}
+			return nil, nil, err
+		}
+		renamed = append(renamed, p.Title)
+		changed = append(changed, pageFile(p.Title))
+	}
+	if len(changed) == 0 {
+		return nil, skipped, nil
+	}
+	message := fmt.Sprintf("Rename tag %s to %s on %d pages", from, to, len(changed))
+	return renamed, skipped, joki.commitChange(r, message, changed, nil)
+}
+
+// Returns body with the tag from in its front matter replaced by to, and
+// the tags nested in from moved below to. Other tags keep their spelling, a
+// tag listed twice after the rename once.
+func retag(body []byte, from, to string) []byte {
+	meta, _ := frontMatter(body)
+	var tags []string
+	for _, tag := range metaList(meta, "tags") {
+		if lower := strings.ToLower(tag); lower == from || strings.HasPrefix(lower, from+"/") {
+			tag = to + lower[len(from):]
+		}
+		duplicate := false
+		for _, t := range tags {
+			duplicate = duplicate || strings.EqualFold(t, tag)
+		}
+		if !duplicate {
+			tags = append(tags, tag)
+		}
+	}
+	return setMeta(body, "tags", "["+strings.Join(tags, ", ")+"]")
+}
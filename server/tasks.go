@@ -0,0 +1,168 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	taskItem     = regexp.MustCompile(`^\s*[-*+]\s+\[([ xX])\]\s+(.*)$`)
+	taskAssignee = regexp.MustCompile(`@([a-zA-Z0-9_.-]+)`)
+	taskTag      = regexp.MustCompile(`(?:^|\s)#([a-zA-Z0-9_-]+)`)
+)
+
+// Task is a task list item found on a page, like "- [ ] @bob: fix #build"
+type Task struct {
+	Page      string
+	Line      int
+	Text      string
+	Done      bool
+	Assignees []string
+	Tags      []string
+}
+
+// Extracts the task list items of a markdown document. Items inside
+// fenced code blocks are skipped.
+func pageTasks(title string, content []byte) []Task {
+	var tasks []Task
+	inCode := false
+	for i, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		m := taskItem.FindStringSubmatch(line)
+		if inCode || m == nil {
+			continue
+		}
+		task := Task{Page: title, Line: i + 1, Text: m[2], Done: m[1] != " "}
+		for _, a := range taskAssignee.FindAllStringSubmatch(m[2], -1) {
+			task.Assignees = append(task.Assignees, strings.ToLower(strings.TrimRight(a[1], ".")))
+		}
+		for _, t := range taskTag.FindAllStringSubmatch(m[2], -1) {
+			task.Tags = append(task.Tags, strings.ToLower(t[1]))
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// taskIndex keeps the tasks of every page, updated whenever a page is saved
+type taskIndex struct {
+	sync.RWMutex
+	tasks map[string][]Task
+}
+
+func newTaskIndex() *taskIndex {
+	return &taskIndex{tasks: make(map[string][]Task)}
+}
+
+func (idx *taskIndex) set(title string, tasks []Task) {
+	idx.Lock()
+	defer idx.Unlock()
+	if len(tasks) == 0 {
+		delete(idx.tasks, title)
+		return
+	}
+	idx.tasks[title] = tasks
+}
+
+func (idx *taskIndex) remove(title string) {
+	idx.Lock()
+	defer idx.Unlock()
+	delete(idx.tasks, title)
+}
+
+// Returns the tasks matching the filter, ordered by page and line
+func (idx *taskIndex) find(keep func(Task) bool) []Task {
+	idx.RLock()
+	defer idx.RUnlock()
+	var tasks []Task
+	for _, pageTasks := range idx.tasks {
+		for _, task := range pageTasks {
+			if keep(task) {
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].Page != tasks[j].Page {
+			return tasks[i].Page < tasks[j].Page
+		}
+		return tasks[i].Line < tasks[j].Line
+	})
+	return tasks
+}
+
+// NameCount is how often a name occurs, e.g. the number of open tasks of a
+// user or the number of pages with a tag
+type NameCount struct {
+	Name  string
+	Count int
+}
+
+// TaskList shows the tasks of the wiki filtered by user or tag
+type TaskList struct {
+	User       string
+	Tag        string
+	Done       bool
+	Tasks      []Task
+	Users      []NameCount
+	Tags       []NameCount
+	Unassigned int
+}
+
+func sortedCounts(counts map[string]int) []NameCount {
+	list := make([]NameCount, 0, len(counts))
+	for name, count := range counts {
+		list = append(list, NameCount{Name: name, Count: count})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Lists open tasks, by user with ?user=bob (or ?user=- for unassigned ones),
+// by tag with ?tag=build and including finished ones with ?done=1
+func (joki *joki) tasksHandler(w http.ResponseWriter, r *http.Request) {
+	list := &TaskList{
+		User: strings.ToLower(r.FormValue("user")),
+		Tag:  strings.ToLower(r.FormValue("tag")),
+		Done: r.FormValue("done") != "",
+	}
+
+	// Tasks of pages the request cannot see are left out
+	user := joki.currentUser(r)
+	users, tags := make(map[string]int), make(map[string]int)
+	for _, task := range joki.tasks.find(func(t Task) bool { return !t.Done && joki.visible(t.Page, user) }) {
+		for _, a := range task.Assignees {
+			users[a]++
+		}
+		for _, t := range task.Tags {
+			tags[t]++
+		}
+		if len(task.Assignees) == 0 {
+			list.Unassigned++
+		}
+	}
+	list.Users, list.Tags = sortedCounts(users), sortedCounts(tags)
+
+	list.Tasks = joki.tasks.find(func(t Task) bool {
+		switch {
+		case !joki.visible(t.Page, user):
+			return false
+		case t.Done && !list.Done:
+			return false
+		case list.User == "-":
+			return len(t.Assignees) == 0
+		case list.User != "" && !containsString(t.Assignees, list.User):
+			return false
+		case list.Tag != "" && !containsString(t.Tags, list.Tag):
+			return false
+		}
+		return true
+	})
+	joki.renderTemplate(w, r, "tasks", list)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	accountsFile   = ".accounts.json"
+	throttleWindow = time.Hour
+	maxThrottled   = 10000 // users counted in memory, the least recent are forgotten
+)
+
+// accountStore remembers when the accounts created by registrations and
+// invitations joined. Users of -users and -user are not in it.
+type accountStore struct {
+	sync.Mutex
+	file   string
+	joined map[string]time.Time
+}
+
+func loadAccounts(dataPath string) (*accountStore, error) {
+	s := &accountStore{file: filepath.Join(dataPath, accountsFile), joined: make(map[string]time.Time)}
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.joined); err != nil {
+		return nil, fmt.Errorf("%s: %s", s.file, err)
+	}
+	return s, nil
+}
+
+// Records that user joined now
+func (s *accountStore) add(user string) error {
+	s.Lock()
+	defer s.Unlock()
+	s.joined[user] = time.Now()
+	data, err := json.MarshalIndent(s.joined, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.file, data, 0600)
+}
+
+// Returns when user joined, false for users of -users
+func (s *accountStore) since(user string) (time.Time, bool) {
+	s.Lock()
+	defer s.Unlock()
+	joined, ok := s.joined[user]
+	return joined, ok
+}
+
+// throttleError reports that a new account changed too much in the last hour
+type throttleError struct {
+	limit int
+	what  string
+	retry time.Duration
+}
+
+func (e *throttleError) Error() string {
+	return fmt.Sprintf("New accounts may only make %d %s an hour, try again in %s", e.limit, e.what, e.retry.Round(time.Minute))
+}
+
+// editThrottle limits the edits and new pages of accounts in their first
+// days. The changes of the last hour are counted in a Cache by user, in
+// memory or in the Redis all replicas share.
+type editThrottle struct {
+	sync.Mutex
+	days   int
+	edits  int // per hour, 0 for no limit
+	pages  int // created per hour, 0 for no limit
+	exempt []string
+	counts Cache
+}
+
+type throttled struct {
+	At      time.Time `json:"at"`
+	Created bool      `json:"created,omitempty"`
+}
+
+// Returns the throttle of the settings in c counting in memory, or nil when
+// accounts are never throttled
+func newEditThrottle(c *Config) *editThrottle {
+	if c.NewAccountDays <= 0 || (c.NewAccountEdits <= 0 && c.NewAccountPages <= 0) {
+		return nil
+	}
+	var exempt []string
+	for _, name := range strings.Split(c.ThrottleExempt, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			exempt = append(exempt, name)
+		}
+	}
+	return &editThrottle{
+		days:   c.NewAccountDays,
+		edits:  c.NewAccountEdits,
+		pages:  c.NewAccountPages,
+		exempt: exempt,
+		counts: newMemoryCache(maxThrottled),
+	}
+}
+
+// Counts a change of user unless it exceeds the limits. Replicas counting
+// the same user at once may both let a change pass.
+func (t *editThrottle) allow(user string, created bool) error {
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	var counted, recent []throttled
+	if data, ok := t.counts.Get(user); ok {
+		if err := json.Unmarshal(data, &counted); err != nil {
+			log.Printf("Dropping the changes counted for %s: %s", user, err)
+		}
+	}
+	edits, pages := 0, 0
+	for _, c := range counted {
+		if now.Sub(c.At) < throttleWindow {
+			recent = append(recent, c)
+			edits++
+			if c.Created {
+				pages++
+			}
+		}
+	}
+	// The oldest change counted leaves the window first
+	retry := func(created bool) time.Duration {
+		for _, c := range recent {
+			if c.Created || !created {
+				return throttleWindow - now.Sub(c.At)
+			}
+		}
+		return throttleWindow
+	}
+	if t.edits > 0 && edits >= t.edits {
+		return &throttleError{limit: t.edits, what: "edits", retry: retry(false)}
+	}
+	if created && t.pages > 0 && pages >= t.pages {
+		return &throttleError{limit: t.pages, what: "new pages", retry: retry(true)}
+	}
+	data, err := json.Marshal(append(recent, throttled{At: now, Created: created}))
+	if err != nil {
+		return err
+	}
+	// All changes counted leave the window an hour after the last one
+	if err := t.counts.Set(user, data, throttleWindow); err != nil {
+		log.Printf("Could not count a change of %s: %s", user, err)
+	}
+	return nil
+}
+
+// Counts a change of page title by the user of r, unless the user is new and
+// made too many changes already. Admins, exempt users and groups and the
+// users of -users are never throttled.
+func (joki *joki) throttle(r *http.Request, title string) error {
+	user := joki.currentUser(r)
+	if joki.editThrottle == nil || user == "" || joki.adminUser(user) || joki.listed(user, joki.editThrottle.exempt) {
+		return nil
+	}
+	joined, ok := joki.accounts.since(user)
+	if !ok || time.Since(joined) > time.Duration(joki.editThrottle.days)*24*time.Hour {
+		return nil
+	}
+	return joki.editThrottle.allow(user, !joki.exists(title))
+}
+
+// Reports a throttleError with the Retry-After header browsers and scripts
+// understand
+func setRetryAfter(w http.ResponseWriter, err error) {
+	if t, ok := err.(*throttleError); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(t.retry.Seconds())+1))
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// A paragraph containing only {{toc}} is replaced by the table of contents
+var tocDirective = regexp.MustCompile(`<p>\s*\{\{\s*toc\s*\}\}\s*</p>`)
+var headingRegex = regexp.MustCompile(`(?s)<h([1-6]) id="([^"]+)">(.*?)</h[1-6]>`)
+var tagRegex = regexp.MustCompile(`<[^>]*>`)
+
+// Expands {{toc}} in rendered html to a nested list linking the headings
+// by the ids the markdown engine gave them
+func insertTOC(body []byte) []byte {
+	if !tocDirective.Match(body) {
+		return body
+	}
+
+	headings := headingRegex.FindAllSubmatch(body, -1)
+	minLevel := 6
+	for _, h := range headings {
+		if level := int(h[1][0] - '0'); level < minLevel {
+			minLevel = level
+		}
+	}
+
+	var toc strings.Builder
+	depth := -1
+	for _, h := range headings {
+		d := int(h[1][0]-'0') - minLevel
+		if d > depth+1 {
+			d = depth + 1 // skipped levels are nested only once
+		}
+		if d > depth {
+			toc.WriteString("<ul class=\"toc\">")
+		} else {
+			toc.WriteString("</li>")
+			for ; depth > d; depth-- {
+				toc.WriteString("</ul></li>")
+			}
+		}
+		depth = d
+		toc.WriteString("<li><a href=\"#" + string(h[2]) + "\">")
+		toc.Write(bytes.TrimSpace(tagRegex.ReplaceAll(h[3], nil)))
+		toc.WriteString("</a>")
+	}
+	for ; depth >= 0; depth-- {
+		toc.WriteString("</li></ul>")
+	}
+
+	return tocDirective.ReplaceAllLiteral(body, []byte(toc.String()))
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Spans are made by the global tracer, which does nothing until the program
+// sets a tracer provider, like gowiki does for -otlp-endpoint
+var tracer = otel.Tracer("github.com/Paspartout/gowiki/server")
+
+// Starts a span for every request handled by mux, continuing the trace of
+// the caller. Spans are named after the route, not the path, so pages do not
+// make a span name each.
+func traceRequests(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		_, route := mux.Handler(r)
+		ctx, span := tracer.Start(ctx, r.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path)))
+		defer span.End()
+
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r.WithContext(ctx))
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", sr.status))
+		if sr.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, strconv.Itoa(sr.status))
+		}
+	})
+}
+
+// Starts a span below the one of the request, e.g. for loading or rendering
+// a page. The caller ends it.
+func startSpan(r *http.Request, name string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := tracer.Start(r.Context(), name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// Ends a span, marking it as failed if err is not nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const trashDir = ".trash"
+
+// TrashEntry is a deleted page that can be restored
+type TrashEntry struct {
+	Title   string
+	ID      string // when the page was deleted, like a revision
+	Deleted time.Time
+	Size    int64
+}
+
+// TrashPage lists the deleted pages
+type TrashPage struct {
+	Entries  []TrashEntry
+	KeepDays int // 0 if deleted pages are kept forever
+}
+
+func (joki *joki) trashPath(title string) string {
+	return filepath.Join(joki.dataPath, trashDir, filepath.FromSlash(title))
+}
+
+// Moves a page to the trash before it gets removed. The entry is named after
+// the time of the deletion, so a page deleted twice is in the trash twice.
+func (joki *joki) trash(title string) error {
+	body, err := joki.store.Load(title)
+	if err != nil {
+		return err
+	}
+	dir := joki.trashPath(title)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	id := time.Now().UTC().Format(revisionTimeFormat)
+	return ioutil.WriteFile(filepath.Join(dir, id+extension), body, 0600)
+}
+
+// Lists the deleted pages, newest first
+func (joki *joki) trashEntries() ([]TrashEntry, error) {
+	root := filepath.Join(joki.dataPath, trashDir)
+	var entries []TrashEntry
+	err := filepath.Walk(root, func(path string, f os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return filepath.SkipDir // nothing was deleted yet
+		} else if err != nil {
+			return err
+		}
+		id := strings.TrimSuffix(f.Name(), extension)
+		if f.IsDir() || !validRevision.MatchString(id) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		t, _ := time.Parse(revisionTimeFormat, id)
+		entries = append(entries, TrashEntry{Title: filepath.ToSlash(rel), ID: id, Deleted: t, Size: f.Size()})
+		return nil
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Deleted.After(entries[j].Deleted) })
+	return entries, err
+}
+
+// Returns whether user may see the deleted page of e. It is gone from the
+// index, so its own front matter and its namespace tell.
+func (joki *joki) trashVisible(e TrashEntry, user string) bool {
+	if !validRevision.MatchString(e.ID) {
+		return false
+	}
+	body, err := ioutil.ReadFile(filepath.Join(joki.trashPath(e.Title), e.ID+extension))
+	return err == nil && joki.visibleMeta(user, joki.meta.namespaceConfig(e.Title).apply(pageMeta(body)))
+}
+
+// Removes an entry from the trash and the directories that became empty
+func (joki *joki) removeTrashEntry(title, id string) error {
+	if err := os.Remove(filepath.Join(joki.trashPath(title), id+extension)); err != nil {
+		return err
+	}
+	for ; title != ""; title = namespace(title) {
+		// Remove fails on directories that still hold entries
+		if os.Remove(joki.trashPath(title)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Restores a deleted page for user and takes it out of the trash
+func (joki *joki) restore(title, id, user string) error {
+	if !validRevision.MatchString(id) {
+		return fmt.Errorf("trash entry \"%s\" is invalid", id)
+	}
+	body, err := ioutil.ReadFile(filepath.Join(joki.trashPath(title), id+extension))
+	if err != nil {
+		return err
+	}
+	if _, err := joki.storePage(title, body, user); err != nil {
+		return err
+	}
+	return joki.removeTrashEntry(title, id)
+}
+
+// Removes the pages deleted more than trashDays ago for good
+func (joki *joki) purgeTrash() {
+	if joki.trashDays <= 0 {
+		return
+	}
+	entries, err := joki.trashEntries()
+	if err != nil {
+		log.Printf("Error listing the trash: %s", err)
+		return
+	}
+	limit := time.Now().AddDate(0, 0, -joki.trashDays)
+	for _, e := range entries {
+		if e.Deleted.After(limit) || joki.holds.held(e.Title) {
+			continue
+		}
+		if err := joki.removeTrashEntry(e.Title, e.ID); err != nil {
+			log.Printf("Error purging %s from the trash: %s", e.Title, err)
+			continue
+		}
+		log.Printf("Purged %s deleted %s from the trash", e.Title, e.ID)
+	}
+}
+
+// Lists the deleted pages the request may see
+func (joki *joki) trashHandler(w http.ResponseWriter, r *http.Request) {
+	joki.purgeTrash()
+	entries, err := joki.trashEntries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user := joki.currentUser(r)
+	visible := entries[:0]
+	for _, e := range entries {
+		if joki.trashVisible(e, user) {
+			visible = append(visible, e)
+		}
+	}
+	joki.renderTemplate(w, r, "trash", &TrashPage{Entries: visible, KeepDays: joki.trashDays})
+}
+
+// Restores a deleted page given by title and id
+func (joki *joki) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Restoring requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	title, id := r.FormValue("title"), r.FormValue("id")
+	if !validPageTitle(title) {
+		http.Error(w, "Title name is invalid: "+title, http.StatusBadRequest)
+		return
+	}
+	if !joki.trashVisible(TrashEntry{Title: title, ID: id}, joki.currentUser(r)) {
+		http.NotFound(w, r)
+		return
+	}
+	if joki.exists(title) {
+		http.Error(w, title+" exists, rename it to restore the deleted page", http.StatusConflict)
+		return
+	}
+	if err := joki.checkEditLock(r, title); err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+	if joki.quarantined(r) {
+		body, err := ioutil.ReadFile(filepath.Join(joki.trashPath(title), id+extension))
+		if err == nil {
+			_, err = joki.holdForModeration(r, HeldEdit{Title: title, Body: string(body), Message: "Restore " + title})
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, TRASH_PATH, http.StatusFound)
+		return
+	}
+	if err := joki.throttle(r, title); err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+
+	if err := joki.restore(title, id, joki.currentUser(r)); os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), changeStatus(err))
+		return
+	}
+	log.Printf("Restored %s deleted %s", title, id)
+	if err := joki.commitChange(r, "Restore "+title, []string{pageFile(title)}, nil); err != nil {
+		http.Error(w, "Page restored but not committed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, VIEW_PATH+title, http.StatusFound)
+}
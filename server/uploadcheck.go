@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Rejected uploads are kept in this directory of the data path, as
+// <time>.<name> in the directory of their page, for the admins to look at
+const quarantineDir = ".quarantine"
+
+const clamTimeout = time.Minute // of a scan
+
+// uploadError reports why an upload was rejected
+type uploadError struct {
+	status int
+	msg    string
+}
+
+func (e *uploadError) Error() string {
+	return e.msg
+}
+
+// Returns the type of an upload by its content. SVG images are XML to
+// content sniffing and keep the type of their name.
+func contentType(name string, head []byte) string {
+	sniffed := strings.SplitN(http.DetectContentType(head), ";", 2)[0]
+	if byName := mime.TypeByExtension(filepath.Ext(name)); strings.HasPrefix(byName, "image/svg+xml") &&
+		(sniffed == "text/xml" || sniffed == "text/plain") && bytes.Contains(head, []byte("<svg")) {
+		return "image/svg+xml"
+	}
+	return sniffed
+}
+
+// Returns whether mediaType is one of types, which may end in /* for all
+// subtypes
+func typeAllowed(mediaType string, types []string) bool {
+	for _, t := range types {
+		if t == mediaType || strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks an upload before it is stored: images, audio and video must be
+// what their name says, the type of the content must be in -upload-types if
+// given, and -clamav-socket must find it clean if given. Rejected uploads
+// are quarantined.
+func (joki *joki) checkUpload(title, name string, file io.ReadSeeker) error {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	reason := ""
+	actual := contentType(name, head[:n])
+	byName := strings.SplitN(mime.TypeByExtension(filepath.Ext(name)), ";", 2)[0]
+	kind := strings.SplitN(byName, "/", 2)[0]
+	if (kind == "image" || kind == "audio" || kind == "video") && !strings.HasPrefix(actual, kind+"/") {
+		reason = fmt.Sprintf("%s is %s, not %s", name, actual, byName)
+	} else if len(joki.uploadTypes) > 0 && !typeAllowed(actual, joki.uploadTypes) {
+		reason = fmt.Sprintf("%s is %s, which may not be uploaded", name, actual)
+	}
+	if reason != "" {
+		joki.quarantine(title, name, file, reason)
+		return &uploadError{status: http.StatusUnsupportedMediaType, msg: reason}
+	}
+
+	if joki.clamSocket == "" {
+		return nil
+	}
+	virus, err := clamScan(joki.clamSocket, file)
+	if _, serr := file.Seek(0, io.SeekStart); err == nil {
+		err = serr
+	}
+	if err != nil {
+		log.Printf("Could not scan %s for %s: %s", name, title, err)
+		return &uploadError{status: http.StatusServiceUnavailable, msg: "The virus scanner is not available, try again later"}
+	}
+	if virus != "" {
+		reason = fmt.Sprintf("%s contains %s", name, virus)
+		joki.quarantine(title, name, file, reason)
+		return &uploadError{status: http.StatusUnprocessableEntity, msg: reason}
+	}
+	return nil
+}
+
+// Keeps a rejected upload away from the attachments
+func (joki *joki) quarantine(title, name string, file io.ReadSeeker, reason string) {
+	dir := filepath.Join(joki.dataPath, quarantineDir, title)
+	id := time.Now().UTC().Format(revisionTimeFormat)
+	err := os.MkdirAll(dir, 0700)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		var data []byte
+		if data, err = ioutil.ReadAll(file); err == nil {
+			err = ioutil.WriteFile(filepath.Join(dir, id+"."+name), data, 0600)
+		}
+	}
+	if err != nil {
+		log.Printf("Could not quarantine %s for %s: %s", name, title, err)
+		return
+	}
+	log.Printf("Quarantined %s for %s as %s: %s", name, title, id, reason)
+}
+
+// Sends r to the clamd listening on address, a unix socket or host:port, and
+// returns the name of the malware it found, "" if none
+func clamScan(address string, r io.Reader) (string, error) {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+	conn, err := net.DialTimeout(network, address, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(clamTimeout))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	// The stream is sent in chunks with their length, the last one empty
+	chunk := make([]byte, 4+32<<10)
+	for {
+		n, err := r.Read(chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			if _, werr := conn.Write(chunk[:4+n]); werr != nil {
+				return "", werr
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+
+	// stream: OK or stream: Eicar-Signature FOUND
+	answer := strings.TrimPrefix(strings.TrimRight(string(reply), "\x00\n"), "stream: ")
+	switch {
+	case answer == "OK":
+		return "", nil
+	case strings.HasSuffix(answer, " FOUND"):
+		return strings.TrimSuffix(answer, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd answered %q", answer)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsConfig holds how the wiki serves https, if at all
+type tlsConfig struct {
+	certFile, keyFile string
+
+	// Let's Encrypt
+	domains  string // comma separated host names to get certificates for
+	cacheDir string
+	email    string
+	httpAddr string // answers the ACME challenges and redirects to https
+}
+
+func (c *tlsConfig) enabled() bool {
+	return c.certFile != "" || c.keyFile != "" || c.domains != ""
+}
+
+// Redirects plain http requests to https
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// Serves the wiki on address, over https if certificates are configured,
+// until it is stopped by a signal
+func listen(address string, handler http.Handler, c *tlsConfig, shutdownTimeout time.Duration) error {
+	server := &http.Server{Addr: address, Handler: handler}
+	if !c.enabled() {
+		return runServer(server, server.Serve, shutdownTimeout)
+	}
+	server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if c.domains == "" {
+		if c.certFile == "" || c.keyFile == "" {
+			return errors.New("-tls-cert and -tls-key must be given together")
+		}
+		return runServer(server, func(ln net.Listener) error {
+			return server.ServeTLS(ln, c.certFile, c.keyFile)
+		}, shutdownTimeout)
+	}
+	if c.certFile != "" || c.keyFile != "" {
+		return errors.New("-autocert cannot be combined with -tls-cert and -tls-key")
+	}
+
+	var hosts []string
+	for _, host := range strings.Split(c.domains, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(c.cacheDir),
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Email:      c.email,
+	}
+	server.TLSConfig = m.TLSConfig()
+	server.TLSConfig.MinVersion = tls.VersionTLS12
+
+	// Let's Encrypt verifies the domains over plain http on port 80
+	go func() {
+		err := http.ListenAndServe(c.httpAddr, m.HTTPHandler(http.HandlerFunc(redirectHTTPS)))
+		log.Print("Error serving ACME challenges: ", err)
+	}()
+	return runServer(server, func(ln net.Listener) error {
+		return server.ServeTLS(ln, "", "")
+	}, shutdownTimeout)
+}